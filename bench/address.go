@@ -0,0 +1,129 @@
+package bench
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+)
+
+// addressSchemes are the supported schemes of the server addresses.
+var addressSchemes = []string{"udp", "tcp", "tls", "https", "quic", "h3", "sdns", "unix"}
+
+// schemeCorrections are the supported schemes for the common names of the
+// protocols mistaken for the schemes.
+var schemeCorrections = map[string]string{
+	"dns":  "udp",
+	"doh":  "https",
+	"doh3": "h3",
+	"doq":  "quic",
+	"dot":  "tls",
+	"http": "https",
+}
+
+// validateAddress returns an error if addr isn't a valid server address.  The
+// error includes the hint on how to fix the address if there is one.
+func validateAddress(addr string) (err error) {
+	var u upstream.Upstream
+	if isUnixAddress(addr) {
+		u, err = newUnixUpstream(addr, 0, 0)
+	} else {
+		u, err = upstream.AddressToUpstream(addr, &upstream.Options{})
+	}
+
+	if err != nil {
+		if hint := addressHint(addr); hint != "" {
+			return fmt.Errorf("%w; %s", err, hint)
+		}
+
+		return err
+	}
+
+	return u.Close()
+}
+
+// addressHint returns the suggestion on how to fix the invalid server address
+// addr or an empty string if there is none.
+func addressHint(addr string) (hint string) {
+	scheme, rest, ok := strings.Cut(addr, "://")
+	if !ok {
+		if strings.Contains(addr, "/") {
+			// A path is only allowed in the DNS-over-HTTPS addresses.
+			return fmt.Sprintf("the address has no scheme and is parsed as plain DNS, did you mean https://%s?", addr)
+		}
+
+		return "the address has no scheme and is parsed as plain DNS, use the tls://, https://, quic://, or h3:// prefix for the encrypted protocols"
+	}
+
+	scheme = strings.ToLower(scheme)
+	if s, found := schemeCorrections[scheme]; found {
+		return fmt.Sprintf("the scheme %s:// isn't supported, did you mean %s://%s?", scheme, s, rest)
+	}
+
+	if !slices.Contains(addressSchemes, scheme) {
+		return fmt.Sprintf(
+			"the scheme %s:// isn't supported, use one of %s://",
+			scheme,
+			strings.Join(addressSchemes, "://, "),
+		)
+	}
+
+	return ""
+}
+
+// encryptedPortHint returns the suggestion on the scheme of the address addr
+// without one if its port is the default port of an encrypted protocol, or an
+// empty string otherwise.
+func encryptedPortHint(addr string) (hint string) {
+	if strings.Contains(addr, "://") {
+		return ""
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+
+	var scheme string
+	switch port {
+	case "443":
+		scheme = "https"
+	case "853":
+		scheme = "tls"
+	default:
+		return ""
+	}
+
+	return fmt.Sprintf("%s is queried over plain DNS, did you mean %s://%s?", addr, scheme, addr)
+}
+
+// invalidUpstream is an upstream for an invalid server address that fails
+// every query.  It's only used if the validation of the address is disabled.
+type invalidUpstream struct {
+	// err is the error of creating the upstream.
+	err error
+
+	// addr is the server address.
+	addr string
+}
+
+// type check
+var _ upstream.Upstream = (*invalidUpstream)(nil)
+
+// Exchange implements the [upstream.Upstream] interface for *invalidUpstream.
+func (u *invalidUpstream) Exchange(_ *dns.Msg) (resp *dns.Msg, err error) {
+	return nil, fmt.Errorf("creating upstream: %w", u.err)
+}
+
+// Address implements the [upstream.Upstream] interface for *invalidUpstream.
+func (u *invalidUpstream) Address() (addr string) {
+	return u.addr
+}
+
+// Close implements the [upstream.Upstream] interface for *invalidUpstream.
+func (u *invalidUpstream) Close() (err error) {
+	return nil
+}
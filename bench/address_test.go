@@ -0,0 +1,75 @@
+package bench
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAddress(t *testing.T) {
+	testCases := []struct {
+		name    string
+		addr    string
+		wantErr string
+	}{{
+		name:    "valid",
+		addr:    "tls://127.0.0.1",
+		wantErr: "",
+	}, {
+		name:    "protocol_name",
+		addr:    "dot://127.0.0.1",
+		wantErr: "did you mean tls://127.0.0.1?",
+	}, {
+		name:    "unknown_scheme",
+		addr:    "foo://127.0.0.1",
+		wantErr: "use one of udp://, tcp://, tls://",
+	}, {
+		name:    "no_scheme_path",
+		addr:    "dns.example/dns-query",
+		wantErr: "did you mean https://dns.example/dns-query?",
+	}, {
+		name:    "no_scheme",
+		addr:    "127.0.0.1:dns",
+		wantErr: "use the tls://, https://, quic://, or h3:// prefix",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateAddress(tc.addr)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestEncryptedPortHint(t *testing.T) {
+	assert.Contains(t, encryptedPortHint("127.0.0.1:853"), "did you mean tls://127.0.0.1:853?")
+	assert.Contains(t, encryptedPortHint("127.0.0.1:443"), "did you mean https://127.0.0.1:443?")
+	assert.Empty(t, encryptedPortHint("127.0.0.1:53"))
+	assert.Empty(t, encryptedPortHint("127.0.0.1"))
+	assert.Empty(t, encryptedPortHint("tls://127.0.0.1:853"))
+}
+
+func Test_runNoValidateAddress(t *testing.T) {
+	o := &Options{
+		Address:      "dot://127.0.0.1:853",
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: 3,
+	}
+
+	_, err := run(context.Background(), o, nil)
+	require.Error(t, err)
+
+	// Every query to the invalid address fails instead.
+	o.NoValidateAddress = true
+	state := runTest(t, o)
+	require.Zero(t, state.processed)
+	require.Equal(t, o.QueriesCount, state.errors)
+}
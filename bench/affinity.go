@@ -0,0 +1,112 @@
+package bench
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+)
+
+// maxCPUIndex is the largest CPU core index that can be pinned to, since the
+// CPU sets of Linux have 1024 bits.
+const maxCPUIndex = 1023
+
+// parseCPUList parses a comma-separated list of CPU core indices and ranges of
+// them, e.g. "0,1,4-7".  Duplicate indices are only returned once, in the order
+// of their first occurrence.
+func parseCPUList(s string) (cpus []int, err error) {
+	seen := map[int]struct{}{}
+	for _, v := range stringutil.SplitTrimmed(s, ",") {
+		var first, last int
+		first, last, err = parseCPURange(v)
+		if err != nil {
+			return nil, err
+		}
+
+		for cpu := first; cpu <= last; cpu++ {
+			if _, ok := seen[cpu]; !ok {
+				seen[cpu] = struct{}{}
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("empty cpu list %q", s)
+	}
+
+	return cpus, nil
+}
+
+// parseCPURange parses a CPU core index or an inclusive range of them, e.g.
+// "4-7".  first and last are equal for a single index.
+func parseCPURange(s string) (first, last int, err error) {
+	firstStr, lastStr, isRange := strings.Cut(s, "-")
+
+	first, err = parseCPUIndex(firstStr)
+	if err != nil {
+		return 0, 0, err
+	} else if !isRange {
+		return first, first, nil
+	}
+
+	last, err = parseCPUIndex(lastStr)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if last < first {
+		return 0, 0, fmt.Errorf("cpu range %q: first index must not exceed last", s)
+	}
+
+	return first, last, nil
+}
+
+// parseCPUIndex parses a CPU core index.
+func parseCPUIndex(s string) (cpu int, err error) {
+	s = strings.TrimSpace(s)
+	cpu, err = strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu index %q: %w", s, err)
+	}
+
+	if cpu < 0 || cpu > maxCPUIndex {
+		return 0, fmt.Errorf("cpu index %d is out of range [0, %d]", cpu, maxCPUIndex)
+	}
+
+	return cpu, nil
+}
+
+// formatCPUList formats the list of CPU core indices for printing.
+func formatCPUList(cpus []int) (s string) {
+	strs := make([]string, 0, len(cpus))
+	for _, cpu := range cpus {
+		strs = append(strs, strconv.Itoa(cpu))
+	}
+
+	return strings.Join(strs, ",")
+}
+
+// applyCPUAffinity pins the process to the CPU cores specified in
+// options.CPUAffinity.  If pinning is not supported on this platform, it prints
+// a warning.  It returns an error if the list of cores is invalid.
+func applyCPUAffinity(options *Options) (err error) {
+	cpuList := options.CPUAffinity
+	cpus, err := parseCPUList(cpuList)
+	if err != nil {
+		return fmt.Errorf("invalid cpu affinity %s: %w", cpuList, err)
+	}
+
+	applied, err := setCPUAffinity(cpus)
+	if err != nil {
+		log.Info("Warning: failed to set CPU affinity: %v", err)
+
+		return nil
+	}
+
+	options.logProgress("Pinned the benchmark to CPU cores: %s", formatCPUList(applied))
+
+	return nil
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package bench
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// setCPUAffinity pins every thread of the current process to the specified
+// CPU cores and returns the cores that were actually applied.  Threads that
+// are created later inherit the affinity from the thread that creates them.
+func setCPUAffinity(cpus []int) (applied []int, err error) {
+	set := &unix.CPUSet{}
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return nil, fmt.Errorf("listing process threads: %w", err)
+	}
+
+	for _, e := range entries {
+		tid, convErr := strconv.Atoi(e.Name())
+		if convErr != nil {
+			continue
+		}
+
+		err = unix.SchedSetaffinity(tid, set)
+		if err != nil {
+			return nil, fmt.Errorf("setting affinity for thread %d: %w", tid, err)
+		}
+	}
+
+	actual := &unix.CPUSet{}
+	err = unix.SchedGetaffinity(0, actual)
+	if err != nil {
+		return nil, fmt.Errorf("getting affinity: %w", err)
+	}
+
+	for _, cpu := range cpus {
+		if actual.IsSet(cpu) {
+			applied = append(applied, cpu)
+		}
+	}
+
+	return applied, nil
+}
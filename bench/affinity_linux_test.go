@@ -0,0 +1,59 @@
+//go:build linux
+
+package bench
+
+import (
+	"testing"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// currentCPUs returns the CPU cores the current thread may run on.
+func currentCPUs(t *testing.T) (cpus []int) {
+	t.Helper()
+
+	set := &unix.CPUSet{}
+	require.NoError(t, unix.SchedGetaffinity(0, set))
+
+	for cpu := range maxCPUIndex + 1 {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+
+	return cpus
+}
+
+func TestSetCPUAffinity(t *testing.T) {
+	orig := currentCPUs(t)
+	require.NotEmpty(t, orig)
+
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		_, err = setCPUAffinity(orig)
+
+		return err
+	})
+
+	applied, err := setCPUAffinity(orig[:1])
+	require.NoError(t, err)
+
+	assert.Equal(t, orig[:1], applied)
+	assert.Equal(t, orig[:1], currentCPUs(t))
+}
+
+func TestSetCPUAffinity_error(t *testing.T) {
+	orig := currentCPUs(t)
+	if orig[len(orig)-1] == maxCPUIndex {
+		t.Skip("the largest cpu index is available")
+	}
+
+	// The kernel rejects the sets without any of the available cores, and
+	// the affinity stays the same.
+	_, err := setCPUAffinity([]int{maxCPUIndex})
+	require.ErrorIs(t, err, unix.EINVAL)
+
+	assert.Equal(t, orig, currentCPUs(t))
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package bench
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// setCPUAffinity is not supported on this platform and always returns an
+// error.
+func setCPUAffinity(_ []int) (applied []int, err error) {
+	return nil, fmt.Errorf("cpu affinity is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,96 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCPUList(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      string
+		wantErr string
+		want    []int
+	}{{
+		name:    "list",
+		in:      "0,1, 3",
+		wantErr: "",
+		want:    []int{0, 1, 3},
+	}, {
+		name:    "range",
+		in:      "0,4-7",
+		wantErr: "",
+		want:    []int{0, 4, 5, 6, 7},
+	}, {
+		name:    "single_range",
+		in:      "2 - 2",
+		wantErr: "",
+		want:    []int{2},
+	}, {
+		name:    "duplicates",
+		in:      "3,1-4,1",
+		wantErr: "",
+		want:    []int{3, 1, 2, 4},
+	}, {
+		name:    "max",
+		in:      "1023",
+		wantErr: "",
+		want:    []int{maxCPUIndex},
+	}, {
+		name:    "empty",
+		in:      " , ",
+		wantErr: `empty cpu list " , "`,
+		want:    nil,
+	}, {
+		name:    "reversed",
+		in:      "7-4",
+		wantErr: `cpu range "7-4": first index must not exceed last`,
+		want:    nil,
+	}, {
+		name:    "out_of_range",
+		in:      "0,1024",
+		wantErr: "cpu index 1024 is out of range [0, 1023]",
+		want:    nil,
+	}, {
+		name:    "out_of_range_end",
+		in:      "1000-2000",
+		wantErr: "cpu index 2000 is out of range [0, 1023]",
+		want:    nil,
+	}, {
+		name:    "negative",
+		in:      "-1",
+		wantErr: `invalid cpu index ""`,
+		want:    nil,
+	}, {
+		name:    "not_number",
+		in:      "0,a",
+		wantErr: `invalid cpu index "a"`,
+		want:    nil,
+	}, {
+		name:    "open_range",
+		in:      "4-",
+		wantErr: `invalid cpu index ""`,
+		want:    nil,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cpus, err := parseCPUList(tc.in)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, cpus)
+		})
+	}
+}
+
+func TestFormatCPUList(t *testing.T) {
+	assert.Equal(t, "0,4,5", formatCPUList([]int{0, 4, 5}))
+	assert.Empty(t, formatCPUList(nil))
+}
@@ -0,0 +1,98 @@
+package bench
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+)
+
+// parseIPSet parses a comma-separated list of IP addresses and returns them
+// sorted and without duplicates.
+func parseIPSet(s string) (ips []netip.Addr, err error) {
+	for _, str := range stringutil.SplitTrimmed(s, ",") {
+		var ip netip.Addr
+		ip, err = netip.ParseAddr(str)
+		if err != nil {
+			return nil, err
+		}
+
+		ips = append(ips, ip.Unmap())
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("empty list of ip addresses %q", s)
+	}
+
+	return sortedSet(ips), nil
+}
+
+// answerIPs returns the sorted set of IP addresses from the A and AAAA records
+// in the answer section of resp.  TTLs and the order of the records are
+// ignored.
+func answerIPs(resp *dns.Msg) (ips []netip.Addr) {
+	for _, rr := range resp.Answer {
+		var ip netip.Addr
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip, _ = netip.AddrFromSlice(rr.A)
+		case *dns.AAAA:
+			ip, _ = netip.AddrFromSlice(rr.AAAA)
+		default:
+			continue
+		}
+
+		ips = append(ips, ip.Unmap())
+	}
+
+	return sortedSet(ips)
+}
+
+// sortedSet sorts ips and removes the duplicates in place.
+func sortedSet(ips []netip.Addr) (res []netip.Addr) {
+	slices.SortFunc(ips, netip.Addr.Compare)
+
+	return slices.Compact(ips)
+}
+
+// ttlStats accumulates the TTLs of the answer records.  It is not safe for
+// concurrent use.
+type ttlStats struct {
+	// count is the number of recorded TTLs.
+	count int
+
+	// total is the sum of all recorded TTLs.
+	total uint64
+
+	// min is the minimum recorded TTL.
+	min uint32
+
+	// max is the maximum recorded TTL.
+	max uint32
+}
+
+// addAnswers records the TTLs of the records in the answer section of resp.
+func (s *ttlStats) addAnswers(resp *dns.Msg) {
+	for _, rr := range resp.Answer {
+		ttl := rr.Header().Ttl
+		if s.count == 0 || ttl < s.min {
+			s.min = ttl
+		}
+
+		s.max = max(s.max, ttl)
+		s.total += uint64(ttl)
+		s.count++
+	}
+}
+
+// average returns the average TTL in seconds or zero if nothing has been
+// recorded.
+func (s *ttlStats) average() (avg float64) {
+	if s.count == 0 {
+		return 0
+	}
+
+	return float64(s.total) / float64(s.count)
+}
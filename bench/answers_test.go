@@ -0,0 +1,71 @@
+package bench
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIPSet(t *testing.T) {
+	ips, err := parseIPSet("2001:db8::1, 1.2.3.4,1.2.3.4")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{
+		netip.MustParseAddr("1.2.3.4"),
+		netip.MustParseAddr("2001:db8::1"),
+	}, ips)
+
+	_, err = parseIPSet("1.2.3.4,foo")
+	assert.Error(t, err)
+
+	_, err = parseIPSet(" , ")
+	assert.Error(t, err)
+}
+
+func TestAnswerIPs(t *testing.T) {
+	hdr := dns.RR_Header{Name: "example.org.", Class: dns.ClassINET}
+
+	resp := &dns.Msg{}
+	resp.Answer = []dns.RR{
+		&dns.CNAME{Hdr: hdr, Target: "example.net."},
+		&dns.AAAA{Hdr: hdr, AAAA: net.ParseIP("2001:db8::1")},
+		&dns.A{Hdr: hdr, A: net.ParseIP("1.2.3.4")},
+		&dns.A{Hdr: dns.RR_Header{Name: "example.org.", Ttl: 300}, A: net.IPv4(1, 2, 3, 4)},
+	}
+
+	assert.Equal(t, []netip.Addr{
+		netip.MustParseAddr("1.2.3.4"),
+		netip.MustParseAddr("2001:db8::1"),
+	}, answerIPs(resp))
+
+	assert.Empty(t, answerIPs(&dns.Msg{}))
+}
+
+func TestTTLStats(t *testing.T) {
+	s := &ttlStats{}
+	assert.Zero(t, s.average())
+
+	newResp := func(ttls ...uint32) (resp *dns.Msg) {
+		resp = &dns.Msg{}
+		for _, ttl := range ttls {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeA, Ttl: ttl},
+				A:   net.IP{1, 2, 3, 4},
+			})
+		}
+
+		return resp
+	}
+
+	s.addAnswers(newResp(300, 60))
+	s.addAnswers(newResp())
+	s.addAnswers(newResp(0))
+
+	assert.Equal(t, 3, s.count)
+	assert.Equal(t, uint32(0), s.min)
+	assert.Equal(t, uint32(300), s.max)
+	assert.Equal(t, 120.0, s.average())
+}
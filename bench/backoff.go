@@ -0,0 +1,53 @@
+package bench
+
+import "time"
+
+// backoffInitial is the delay before re-creating the upstream after the first
+// of the consecutive errors.
+const backoffInitial = 10 * time.Millisecond
+
+// backoff calculates the exponentially growing delays after the consecutive
+// errors of a connection.  A nil *backoff means that there is no delay.  It is
+// not safe for concurrent use.
+type backoff struct {
+	// max is the maximum delay.
+	max time.Duration
+
+	// next is the delay after the next error.
+	next time.Duration
+}
+
+// newBackoff returns a new *backoff with the delays capped at maxDelay or nil
+// if maxDelay is not positive.
+func newBackoff(maxDelay time.Duration) (b *backoff) {
+	if maxDelay <= 0 {
+		return nil
+	}
+
+	return &backoff{
+		max:  maxDelay,
+		next: min(backoffInitial, maxDelay),
+	}
+}
+
+// failure returns the delay after one more consecutive error and doubles the
+// next one.
+func (b *backoff) failure() (d time.Duration) {
+	if b == nil {
+		return 0
+	}
+
+	d = b.next
+	b.next = min(b.next*2, b.max)
+
+	return d
+}
+
+// reset resets the delay after the successful query.
+func (b *backoff) reset() {
+	if b == nil {
+		return
+	}
+
+	b.next = min(backoffInitial, b.max)
+}
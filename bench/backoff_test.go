@@ -0,0 +1,28 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	var b *backoff
+	assert.Nil(t, newBackoff(0))
+	assert.Zero(t, b.failure())
+	b.reset()
+
+	b = newBackoff(50 * time.Millisecond)
+	assert.Equal(t, 10*time.Millisecond, b.failure())
+	assert.Equal(t, 20*time.Millisecond, b.failure())
+	assert.Equal(t, 40*time.Millisecond, b.failure())
+	assert.Equal(t, 50*time.Millisecond, b.failure())
+	assert.Equal(t, 50*time.Millisecond, b.failure())
+
+	b.reset()
+	assert.Equal(t, 10*time.Millisecond, b.failure())
+
+	b = newBackoff(time.Millisecond)
+	assert.Equal(t, time.Millisecond, b.failure())
+}
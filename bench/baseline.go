@@ -0,0 +1,178 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// ANSI escape sequences coloring the differences from the baseline.
+const (
+	colorBetter = "\x1b[32m"
+	colorWorse  = "\x1b[31m"
+	colorReset  = "\x1b[0m"
+)
+
+// ReadResult reads the test results written by [Result.WriteJSON] from the
+// file at path, e.g. to compare a later run with them.
+func ReadResult(path string) (res *Result, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	res = &Result{}
+	err = json.Unmarshal(b, res)
+	if err != nil {
+		return nil, fmt.Errorf("decoding results: %w", err)
+	}
+
+	return res, nil
+}
+
+// errorRate returns the percentage of failed queries.
+func (res *Result) errorRate() (rate float64) {
+	return 100 * float64(res.Errors) / float64(max(res.Processed+res.Errors, 1))
+}
+
+// baselineMetric is a single metric compared with the baseline.
+type baselineMetric struct {
+	// name is the human-readable name of the metric.
+	name string
+
+	// unit is appended to the values of the metric.
+	unit string
+
+	// baseline is the value of the metric in the baseline.
+	baseline float64
+
+	// current is the value of the metric in the current run.
+	current float64
+
+	// higherIsBetter is true if the increase of the metric is an
+	// improvement.
+	higherIsBetter bool
+
+	// absolute is true if only the absolute difference makes sense, e.g. for
+	// percentages.
+	absolute bool
+
+	// count is true if the metric is a number of queries.
+	count bool
+}
+
+// format returns v with the unit of m.
+func (m baselineMetric) format(v float64, sign bool) (s string) {
+	verb := "%.2f"
+	if m.count {
+		verb = "%.0f"
+	}
+
+	if sign {
+		verb = "%+" + verb[1:]
+	}
+
+	return fmt.Sprintf(verb, v) + m.unit
+}
+
+// diff returns the signed difference of m from the baseline, colored if color
+// is true.
+func (m baselineMetric) diff(color bool) (s string) {
+	d := m.current - m.baseline
+	s = m.format(d, true)
+	if !m.absolute && m.baseline != 0 {
+		s += fmt.Sprintf(" (%+.2f%%)", 100*d/m.baseline)
+	}
+
+	if !color || d == 0 {
+		return s
+	}
+
+	if (d > 0) == m.higherIsBetter {
+		return colorBetter + s + colorReset
+	}
+
+	return colorWorse + s + colorReset
+}
+
+// PrintBaselineDiff logs the main metrics of res along with their differences
+// from the metrics of baseline.  The differences are colored when printed to a
+// terminal.
+func PrintBaselineDiff(baseline, res *Result) {
+	metrics := []baselineMetric{{
+		name:           "Average QPS",
+		baseline:       baseline.QPS,
+		current:        res.QPS,
+		higherIsBetter: true,
+	}, {
+		name:           "Processed queries",
+		baseline:       float64(baseline.Processed),
+		current:        float64(res.Processed),
+		higherIsBetter: true,
+		count:          true,
+	}, {
+		name:     "Errors count",
+		baseline: float64(baseline.Errors),
+		current:  float64(res.Errors),
+		count:    true,
+	}, {
+		name:     "Error rate",
+		unit:     "%",
+		baseline: baseline.errorRate(),
+		current:  res.errorRate(),
+		absolute: true,
+	}, {
+		name:     "Latency average",
+		unit:     "ms",
+		baseline: baseline.LatencyAverage,
+		current:  res.LatencyAverage,
+	}, {
+		name:     "Latency p50",
+		unit:     "ms",
+		baseline: baseline.LatencyP50,
+		current:  res.LatencyP50,
+	}, {
+		name:     "Latency p90",
+		unit:     "ms",
+		baseline: baseline.LatencyP90,
+		current:  res.LatencyP90,
+	}, {
+		name:     "Latency p99",
+		unit:     "ms",
+		baseline: baseline.LatencyP99,
+		current:  res.LatencyP99,
+	}, {
+		name:     "Latency max",
+		unit:     "ms",
+		baseline: baseline.LatencyMax,
+		current:  res.LatencyMax,
+	}}
+
+	color := isTerminal(os.Stdout) && (res.options == nil || res.options.LogOutput == "")
+
+	b := &strings.Builder{}
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "\tBaseline\tCurrent\tDifference")
+	for _, m := range metrics {
+		_, _ = fmt.Fprintf(
+			w,
+			"%s:\t%s\t%s\t%s\n",
+			m.name,
+			m.format(m.baseline, false),
+			m.format(m.current, false),
+			m.diff(color),
+		)
+	}
+	_ = w.Flush()
+
+	log.Info(
+		"The difference from the baseline of %s is:\n%s",
+		baseline.Address,
+		strings.TrimSuffix(b.String(), "\n"),
+	)
+}
@@ -0,0 +1,54 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadResult(t *testing.T) {
+	state := &runState{
+		startTime: time.Now().Add(-time.Second),
+		processed: 9,
+		errors:    1,
+	}
+	state.latency.add(10 * time.Millisecond)
+
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	err := newResult(&Options{Address: "8.8.8.8"}, state).WriteJSON(path)
+	require.NoError(t, err)
+
+	res, err := ReadResult(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, "8.8.8.8", res.Address)
+	assert.Equal(t, 9, res.Processed)
+	assert.Equal(t, 10.0, res.errorRate())
+	assert.Equal(t, 10.0, res.LatencyP99)
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = ReadResult(path)
+	assert.Error(t, err)
+
+	PrintBaselineDiff(res, &Result{Address: "8.8.8.8", QPS: 10, Processed: 10})
+}
+
+func TestBaselineMetric_diff(t *testing.T) {
+	qps := baselineMetric{baseline: 100, current: 120, higherIsBetter: true}
+	assert.Equal(t, "+20.00 (+20.00%)", qps.diff(false))
+	assert.Equal(t, colorBetter+"+20.00 (+20.00%)"+colorReset, qps.diff(true))
+
+	p99 := baselineMetric{unit: "ms", baseline: 50, current: 38}
+	assert.Equal(t, "-12.00ms (-24.00%)", p99.diff(false))
+	assert.Equal(t, colorBetter+"-12.00ms (-24.00%)"+colorReset, p99.diff(true))
+
+	errs := baselineMetric{baseline: 0, current: 3, count: true}
+	assert.Equal(t, colorWorse+"+3"+colorReset, errs.diff(true))
+
+	rate := baselineMetric{unit: "%", baseline: 1, current: 1, absolute: true}
+	assert.Equal(t, "+0.00%", rate.diff(true))
+}
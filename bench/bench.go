@@ -0,0 +1,237 @@
+// Package bench implements the DNS benchmarking engine, so that it could be
+// used both by the command-line interface and programmatically.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// printEveryNRecords regulates when we should print the intermediate results.
+const printEveryNRecords = 100
+
+// errTooManyErrors is the reason of aborting the test after the number of
+// errors exceeds the threshold.
+const errTooManyErrors errors.Error = "too many errors"
+
+// errMaxRuntime is the reason of aborting the test after it has run for the
+// maximum runtime.
+const errMaxRuntime errors.Error = "maximum runtime exceeded"
+
+// shutdownTimeout is how long to wait for the connections to finish after the
+// test has been interrupted.
+const shutdownTimeout = 2 * time.Second
+
+// randomLen is a length of the random string that replaces {random} in the
+// queried domain name.
+const randomLen = 16
+
+// CheckErrorRate returns an error if the error rate of any of the results
+// exceeds the --fail-over threshold from options.
+func CheckErrorRate(options *Options, results ...*Result) (err error) {
+	if options.FailOver == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, res := range results {
+		rate := res.state.errorRate()
+		if rate > *options.FailOver {
+			errs = append(errs, fmt.Errorf(
+				"error rate of %s is %.2f%%, more than %.2f%%",
+				res.Address,
+				rate,
+				*options.FailOver,
+			))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Run runs the test configured by options and returns its results.  It
+// returns an error if options are invalid.  Cancelling ctx interrupts the test
+// as soon as the queries in flight are finished, the results of the queries
+// already sent are still returned.  Every value received from snapshots prints
+// the results of the test so far without stopping it, snapshots may be nil.
+func Run(ctx context.Context, options *Options, snapshots <-chan os.Signal) (res *Result, err error) {
+	state, err := run(ctx, options, snapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	return newResult(options, state), nil
+}
+
+// run interprets options and runs the test.  It returns the final state of the
+// test.  See [Run] for snapshots.
+func run(ctx context.Context, options *Options, snapshots <-chan os.Signal) (state *runState, err error) {
+	options.logProgress("Run godnsbench with the following configuration:\n%s", options)
+
+	if options.CPUAffinity != "" {
+		err = applyCPUAffinity(options)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = options.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err = newRunState(options)
+	if err != nil {
+		return nil, err
+	}
+
+	warmupNames, err := readWarmupNames(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.DryRun {
+		// Don't start any connections, the single query is enough to check
+		// the options.
+		err = dryRun(ctx, options, state)
+		state.finish()
+
+		return state, err
+	}
+
+	err = state.openOutputs(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.SharedUpstream {
+		u := createUpstream(options, state)
+		defer log.OnCloserError(u, log.DEBUG)
+
+		state.shared = &sharedUpstream{Upstream: u}
+	}
+
+	// Let the connections abort the test, e.g. after too many errors.
+	ctx, state.abort = context.WithCancelCause(ctx)
+	defer state.abort(nil)
+
+	if options.MaxRuntime > 0 {
+		timer := time.AfterFunc(options.MaxRuntime, func() { state.abort(errMaxRuntime) })
+		defer timer.Stop()
+	}
+
+	if len(warmupNames) > 0 {
+		warmUpCache(ctx, options, state, warmupNames)
+		state.startMeasurement(options.Duration)
+	}
+
+	// Subscribe to the bench run close event.
+	closeChannel := make(chan bool, 1)
+
+	startReporters(options, state, snapshots, closeChannel)
+	progress := newTestProgressBar(options, state)
+
+	// Run it in a separate goroutine so that we could react to the cancellation.
+	// The connections stop sending queries and abandon the ones in flight once
+	// ctx is cancelled.
+	go func() {
+		runConnections(ctx, options, state, progress)
+		close(closeChannel)
+	}()
+
+	waitForConnections(ctx, options, progress, closeChannel)
+
+	state.finish()
+	state.closeOutputs()
+
+	return state, nil
+}
+
+// startReporters starts the goroutines reporting the progress of the test
+// described by options until done is closed.  See [Run] for snapshots.
+func startReporters(options *Options, state *runState, snapshots <-chan os.Signal, done <-chan bool) {
+	if ramp, ok := state.rate.(*rampLimiter); ok {
+		go monitorRamp(state, ramp, done)
+	}
+
+	if options.ReportInterval > 0 && (!state.quiet || state.jsonl != nil) {
+		go reportPeriodically(state, options.ReportInterval, done)
+	}
+
+	if options.SelfMetrics > 0 {
+		state.selfPeak = readSelfMetrics()
+		go logSelfMetrics(state, options.SelfMetrics, done)
+	}
+
+	if snapshots != nil {
+		go printSnapshots(state, snapshots, done)
+	}
+
+	state.statsd.start(state)
+	state.timeseries.start()
+}
+
+// waitForConnections waits until done is closed after all connections have
+// finished.  If ctx is cancelled first, it logs the reason and gives the
+// connections some time to finish.
+func waitForConnections(ctx context.Context, options *Options, progress *progressBar, done <-chan bool) {
+	select {
+	case <-ctx.Done():
+		progress.stop()
+
+		cause := context.Cause(ctx)
+		if errors.Is(cause, errTooManyErrors) {
+			log.Info("The test has been aborted after more than %d errors.", options.MaxErrors)
+		} else if errors.Is(cause, errMaxRuntime) {
+			log.Info("The test has been aborted after the maximum runtime of %s.", options.MaxRuntime)
+		} else {
+			log.Info("The test has been interrupted.")
+		}
+
+		// Give the connections some time to abandon the queries in flight,
+		// the upstreams that can't be cancelled are left behind.
+		select {
+		case <-done:
+		case <-time.After(shutdownTimeout):
+			log.Info("Not all connections have finished in %s", shutdownTimeout)
+		}
+	case <-done:
+		options.logProgress("The test has finished.")
+	}
+}
+
+// newRand returns a new random number generator with the seed.
+func newRand(seed int64) (rng *rand.Rand) {
+	// #nosec G404 -- The random names don't need to be unpredictable, they
+	// need to be reproducible.
+	return rand.New(rand.NewSource(seed))
+}
+
+var letterRunes = []rune("abcdefghijklmnopqrstuvwxyz")
+
+func randString(rng *rand.Rand, n int) string {
+	b := make([]rune, n)
+	for i := range b {
+		b[i] = letterRunes[rng.Intn(len(letterRunes))]
+	}
+	return string(b)
+}
+
+// randomizeCase randomly changes the case of every ASCII letter in s.
+func randomizeCase(rng *rand.Rand, s string) (res string) {
+	b := []byte(s)
+	for i, c := range b {
+		if ('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') && rng.Intn(2) == 0 {
+			// Flip the case of the letter.
+			b[i] = c ^ 0x20
+		}
+	}
+
+	return string(b)
+}
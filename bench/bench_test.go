@@ -0,0 +1,2465 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		Rate:               50,
+		QueriesCount:       1000,
+		InsecureSkipVerify: true,
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		res, err := Run(ctx, o, nil)
+		require.NoError(t, err)
+
+		require.Equal(t, serverAddress, res.Address)
+		require.Positive(t, res.Processed)
+		require.Less(t, res.Processed, o.QueriesCount)
+		require.Zero(t, res.Errors)
+	})
+
+	t.Run("invalid_options", func(t *testing.T) {
+		invalid := *o
+		invalid.QType = "FOO"
+
+		_, err := Run(context.Background(), &invalid, nil)
+		require.Error(t, err)
+	})
+}
+
+func Test_run(t *testing.T) {
+	tlsConfig, _ := createServerTLSConfig(t, "example.org")
+	p := createTestProxy(t, tlsConfig)
+
+	p.RequestHandler = func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	}
+
+	err := p.Start(context.Background())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return p.Shutdown(context.Background())
+	})
+
+	addr := p.Addr(proxy.ProtoHTTPS)
+	serverAddress := fmt.Sprintf("https://%s/dns-query", addr)
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		Rate:               50,
+		QueriesCount:       100,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, 0, state.errors)
+}
+
+func Test_runLatency(t *testing.T) {
+	addr := startUDPServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		time.Sleep(time.Millisecond)
+
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		_ = w.WriteMsg(resp)
+	})
+
+	o := &Options{
+		Address:      addr.String(),
+		Connections:  2,
+		Query:        "example.org",
+		Timeout:      10,
+		QueriesCount: 20,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	require.Equal(t, o.QueriesCount, state.latency.count())
+	require.GreaterOrEqual(t, state.latency.percentile(50), time.Millisecond)
+	require.LessOrEqual(t, state.latency.percentile(50), state.latency.percentile(90))
+	require.LessOrEqual(t, state.latency.percentile(90), state.latency.percentile(99))
+	require.LessOrEqual(t, state.latency.percentile(99), state.latency.maximum())
+}
+
+func Test_runFirstResponse(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        2,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       10,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	// Only the first response of every connection includes the handshake.
+	require.Equal(t, o.Connections, state.latencyFirst.count())
+	require.GreaterOrEqual(t, state.latencyFirst.maximum(), state.latency.percentile(50))
+}
+
+func Test_runBytes(t *testing.T) {
+	addr := startUDPServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		resp.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{
+				Name:   req.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    60,
+			},
+			A: net.IP{192, 0, 2, 1},
+		}}
+		_ = w.WriteMsg(resp)
+	})
+
+	o := &Options{
+		Address:      addr.String(),
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      10,
+		QueriesCount: 10,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	// The query for example.org without EDNS is 29 bytes long, and the
+	// response has an additional 27-byte A record with an uncompressed name.
+	require.Equal(t, o.QueriesCount*29, state.bytesSent)
+	require.Equal(t, o.QueriesCount, state.sizedResponses)
+	require.Equal(t, o.QueriesCount*(29+27), state.bytesReceived)
+}
+
+func Test_runWithQueriesFile(t *testing.T) {
+	str := `example.org
+example.com
+example.net`
+	filePath := path.Join(os.TempDir(), "queries.txt")
+	err := os.WriteFile(filePath, []byte(str), 0600)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = os.Remove(filePath)
+	}()
+
+	tlsConfig, _ := createServerTLSConfig(t, "example.org")
+	p := createTestProxy(t, tlsConfig)
+
+	p.RequestHandler = func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	}
+
+	err = p.Start(context.Background())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return p.Shutdown(context.Background())
+	})
+
+	addr := p.Addr(proxy.ProtoHTTPS)
+	serverAddress := fmt.Sprintf("https://%s/dns-query", addr)
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		QueriesPath:        filePath,
+		Timeout:            10,
+		Rate:               50,
+		QueriesCount:       100,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, 0, state.errors)
+}
+
+func Test_runExtendedErrors(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		resp.SetEdns0(dns.DefaultMsgSize, false)
+		opt := resp.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeStaleAnswer})
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        2,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       10,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, map[uint16]int{dns.ExtendedErrorCodeStaleAnswer: o.QueriesCount}, state.extendedErrors)
+	require.Equal(t, "Stale Answer: 10", state.extendedErrorsBreakdown())
+}
+
+func Test_runCDAB(t *testing.T) {
+	var cdCount atomic.Int32
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		if d.Req.CheckingDisabled {
+			cdCount.Add(1)
+		}
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       10,
+		InsecureSkipVerify: true,
+		CDAB:               true,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, int32(5), cdCount.Load())
+	require.Equal(t, 5, state.latencyValidated.count())
+	require.Equal(t, 5, state.latencyUnvalidated.count())
+}
+
+func Test_runCDAB_pairs(t *testing.T) {
+	type sentQuery struct {
+		question dns.Question
+		size     int
+		cd       bool
+	}
+
+	var mu sync.Mutex
+	var sent []sentQuery
+	addr := startUDPServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		mu.Lock()
+		sent = append(sent, sentQuery{question: req.Question[0], size: req.Len(), cd: req.CheckingDisabled})
+		mu.Unlock()
+
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		_ = w.WriteMsg(resp)
+	})
+
+	o := &Options{
+		Address:           addr.String(),
+		Connections:       1,
+		Query:             "example.org",
+		QTypes:            "A,AAAA,TXT,MX",
+		PayloadRandomSize: "64-512",
+		Timeout:           10,
+		QueriesCount:      20,
+		CDAB:              true,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, sent, o.QueriesCount)
+	for i := 0; i < len(sent); i += 2 {
+		first, second := sent[i], sent[i+1]
+
+		require.False(t, first.cd)
+		require.True(t, second.cd)
+		require.Equal(t, first.question, second.question, "pair %d", i/2)
+		require.Equal(t, first.size, second.size, "pair %d", i/2)
+	}
+}
+
+func Test_runLateResponses(t *testing.T) {
+	p := createTestProxy(t, nil)
+
+	var reqCount atomic.Int32
+	p.RequestHandler = func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		// Answer the first query after the timeout.
+		if reqCount.Add(1) == 1 {
+			time.Sleep(1200 * time.Millisecond)
+		}
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	}
+
+	err := p.Start(context.Background())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return p.Shutdown(context.Background())
+	})
+
+	o := &Options{
+		Address:      p.Addr(proxy.ProtoUDP).String(),
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: 3,
+		LateWait:     time.Second,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, 1, state.late)
+	require.Equal(t, 2, state.processed)
+	require.Equal(t, 0, state.errors)
+	require.Greater(t, state.latencyLate.average(), time.Second)
+}
+
+func Test_runRetries(t *testing.T) {
+	p := createTestProxy(t, nil)
+
+	var reqCount atomic.Int32
+	p.RequestHandler = func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		// Make the first query time out.
+		if reqCount.Add(1) == 1 {
+			time.Sleep(1200 * time.Millisecond)
+		}
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	}
+
+	err := p.Start(context.Background())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return p.Shutdown(context.Background())
+	})
+
+	o := &Options{
+		Address:      p.Addr(proxy.ProtoUDP).String(),
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: 3,
+		Retries:      1,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, 3, state.processed)
+	require.Zero(t, state.errors)
+	require.Equal(t, 1, state.retried)
+}
+
+func Test_runTimeout(t *testing.T) {
+	// The server reads the queries, but never answers them.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			if _, _, rErr := conn.ReadFrom(buf); rErr != nil {
+				return
+			}
+		}
+	}()
+
+	const queriesCount = 3
+
+	o := &Options{
+		Address:      conn.LocalAddr().String(),
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: queriesCount,
+	}
+
+	start := time.Now()
+	state := runTest(t, o)
+	elapsed := time.Since(start)
+
+	require.Equal(t, queriesCount, state.errors)
+	require.Zero(t, state.processed)
+
+	// Every query must be abandoned after the timeout even though the
+	// upstream retries it by itself.
+	require.Less(t, elapsed, queriesCount*1500*time.Millisecond)
+}
+
+func Test_runBackoff(t *testing.T) {
+	// Nothing listens on the port, so every query fails right away.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	const queriesCount = 5
+
+	o := &Options{
+		Address:      "tcp://" + addr,
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: queriesCount,
+		BackoffMax:   40 * time.Millisecond,
+	}
+
+	start := time.Now()
+	state := runTest(t, o)
+	elapsed := time.Since(start)
+
+	require.Equal(t, queriesCount, state.errors)
+
+	// The delays are 10ms, 20ms, 40ms, 40ms, and 40ms.
+	require.GreaterOrEqual(t, elapsed, 150*time.Millisecond)
+}
+
+func Test_runConnectionsOpened(t *testing.T) {
+	// Nothing listens on the port, so every query fails right away.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	o := &Options{
+		Address:      "tcp://" + addr,
+		Connections:  2,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: 6,
+	}
+
+	// Every connection is re-created after each error.
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.errors)
+	require.Equal(t, o.Connections+o.QueriesCount, state.upstreams)
+	require.Equal(t, state.upstreams, newResult(o, state).ConnectionsOpened)
+}
+
+func Test_runSharedUpstream(t *testing.T) {
+	var mu sync.Mutex
+	clients := map[netip.AddrPort]struct{}{}
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		mu.Lock()
+		clients[d.Addr] = struct{}{}
+		mu.Unlock()
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        4,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       40,
+		SharedUpstream:     true,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, 1, state.upstreams)
+
+	// The queries of all connections are multiplexed over a single HTTP/2
+	// connection.
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, clients, 1)
+
+	t.Run("not_concurrent", func(t *testing.T) {
+		invalid := *o
+		invalid.Address = "unix:///tmp/dns.sock"
+
+		_, err := run(context.Background(), &invalid, nil)
+		require.ErrorContains(t, err, "--shared-upstream can't be used with unix:// addresses")
+	})
+}
+
+func Test_runConnectTimeout(t *testing.T) {
+	// The server accepts the connections, but never completes the TLS
+	// handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, l.Close)
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, aErr := l.Accept()
+			if aErr != nil {
+				return
+			}
+
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+
+	testCases := []struct {
+		name    string
+		address string
+	}{{
+		name:    "dot",
+		address: "tls://" + l.Addr().String(),
+	}, {
+		name:    "doh",
+		address: "https://" + l.Addr().String() + "/dns-query",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := &Options{
+				Address:            tc.address,
+				Connections:        1,
+				Query:              "example.org",
+				Timeout:            10,
+				ConnectTimeout:     100 * time.Millisecond,
+				QueriesCount:       2,
+				InsecureSkipVerify: true,
+			}
+
+			state := runTest(t, o)
+			require.Equal(t, o.QueriesCount, state.errors)
+			require.Equal(t, o.QueriesCount, state.errorCategories[errCategoryConnectTimeout])
+			require.Less(t, state.elapsed(), time.Duration(o.Timeout)*time.Second)
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		o := &Options{
+			Address:        "tcp://" + l.Addr().String(),
+			Connections:    1,
+			Query:          "example.org",
+			Timeout:        10,
+			ConnectTimeout: 100 * time.Millisecond,
+			QueriesCount:   2,
+		}
+
+		_, err = run(context.Background(), o, nil)
+		require.Error(t, err)
+	})
+}
+
+func Test_runDelay(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        2,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       6,
+		Delay:              50 * time.Millisecond,
+		InsecureSkipVerify: true,
+	}
+
+	start := time.Now()
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	// Every connection sends 3 queries and pauses after each of them
+	// regardless of the number of connections.
+	require.GreaterOrEqual(t, time.Since(start), 3*o.Delay)
+
+	// The pause is cut short by the deadline of the test.
+	o.QueriesCount = 0
+	o.Delay = time.Minute
+	o.Duration = 200 * time.Millisecond
+
+	start = time.Now()
+	state = runTest(t, o)
+	require.Equal(t, o.Connections, state.processed)
+	require.Less(t, time.Since(start), 10*time.Second)
+}
+
+func Test_runSnapshots(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	snapshots := make(chan os.Signal, 1)
+	snapshots <- syscall.SIGHUP
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       5,
+		Delay:              50 * time.Millisecond,
+		InsecureSkipVerify: true,
+	}
+
+	// The snapshot doesn't stop the test.
+	state, err := run(context.Background(), o, snapshots)
+	require.NoError(t, err)
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Contains(t, out.String(), "Snapshot after")
+}
+
+func Test_runCancel(t *testing.T) {
+	// The server reads the queries, but never answers them.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, conn.Close)
+
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			if _, _, rErr := conn.ReadFrom(buf); rErr != nil {
+				return
+			}
+		}
+	}()
+
+	o := &Options{
+		Address:      conn.LocalAddr().String(),
+		Connections:  2,
+		Query:        "example.org",
+		Timeout:      10,
+		QueriesCount: 10,
+		LateWait:     time.Second,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	state, err := run(ctx, o, nil)
+	elapsed := time.Since(start)
+	require.NoError(t, err)
+
+	// The queries in flight are abandoned right away instead of waiting for
+	// the timeout and aren't counted as errors.
+	require.Less(t, elapsed, shutdownTimeout)
+	require.Zero(t, state.errors, state.errorCategories)
+	require.Zero(t, state.late)
+	require.Zero(t, state.processed)
+}
+
+func Test_runDryRun(t *testing.T) {
+	var n atomic.Int32
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		n.Add(1)
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        10,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       1000,
+		InsecureSkipVerify: true,
+		DryRun:             true,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, 1, state.processed)
+	require.Equal(t, int32(1), n.Load())
+
+	o.Address = "udp://127.0.0.1:1"
+	o.Timeout = 1
+	_, err := run(context.Background(), o, nil)
+	require.Error(t, err)
+}
+
+func Test_runTTLs(t *testing.T) {
+	var n atomic.Uint32
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   d.Req.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    n.Add(1) * 10,
+			},
+			A: net.IP{1, 2, 3, 4},
+		})
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       3,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, 3, state.ttls.count)
+	require.Equal(t, uint32(10), state.ttls.min)
+	require.Equal(t, uint32(30), state.ttls.max)
+	require.Equal(t, 20.0, state.ttls.average())
+	require.Zero(t, state.noData)
+}
+
+func Test_runMaxErrors(t *testing.T) {
+	// Nothing listens on the port, so every query fails right away.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	o := &Options{
+		Address:      "tcp://" + addr,
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: 1000,
+		MaxErrors:    3,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.MaxErrors+1, state.errors)
+	require.Less(t, state.queriesSent, o.QueriesCount)
+}
+
+func Test_runMaxRuntime(t *testing.T) {
+	// The server never responds, so every query waits for the timeout.
+	addr := startUDPServer(t, func(_ dns.ResponseWriter, _ *dns.Msg) {})
+
+	o := &Options{
+		Address:      addr.String(),
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      10,
+		QueriesCount: 10,
+		MaxRuntime:   200 * time.Millisecond,
+	}
+
+	start := time.Now()
+	state := runTest(t, o)
+
+	require.Less(t, time.Since(start), time.Duration(o.Timeout)*time.Second)
+	require.Less(t, state.queriesSent, o.QueriesCount)
+}
+
+func Test_runUnixSocket(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "dns.sock")
+	l, err := net.Listen("unix", sockPath)
+	require.NoError(t, err)
+
+	srv := &dns.Server{
+		Listener: l,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := &dns.Msg{}
+			resp.SetReply(req)
+			_ = w.WriteMsg(resp)
+		}),
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+
+	o := &Options{
+		Address:      "unix://" + sockPath,
+		Connections:  2,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: 20,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, 0, state.errors)
+	require.Equal(t, o.QueriesCount, state.processed)
+}
+
+func Test_runTCPKeepalive(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "dns.sock")
+	l, err := net.Listen("unix", sockPath)
+	require.NoError(t, err)
+
+	var withOption atomic.Int64
+	srv := &dns.Server{
+		Listener: l,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			if _, ok := responseTCPKeepalive(req); ok {
+				withOption.Add(1)
+			}
+
+			resp := &dns.Msg{}
+			resp.SetReply(req)
+			addTCPKeepalive(resp, 100*time.Millisecond)
+			_ = w.WriteMsg(resp)
+		}),
+		// Close the connections idle for longer than advertised.
+		IdleTimeout: func() (d time.Duration) { return 100 * time.Millisecond },
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+
+	keepalive := time.Duration(0)
+	o := &Options{
+		Address:      "unix://" + sockPath,
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: 4,
+		Delay:        300 * time.Millisecond,
+		TCPKeepalive: &keepalive,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, 100*time.Millisecond, state.keepaliveMax)
+
+	// Every other query is sent over the connection closed by the server
+	// after the previous one, so it doesn't reach the server, and the next one
+	// reconnects.
+	require.Equal(t, 2, state.idleExpired)
+	require.Equal(t, 2, state.idleExpiredErrors)
+	require.Equal(t, 2, state.keepaliveResponses)
+	require.Equal(t, int64(state.processed), withOption.Load())
+}
+
+func Test_runIPVersion(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	// The test server only listens on 127.0.0.1.
+	u, err := url.Parse(serverAddress)
+	require.NoError(t, err)
+	u.Host = net.JoinHostPort("localhost", u.Port())
+
+	newOptions := func(ipVersion string) (o *Options) {
+		return &Options{
+			Address:            u.String(),
+			Connections:        1,
+			Query:              "example.org",
+			Timeout:            1,
+			QueriesCount:       3,
+			InsecureSkipVerify: true,
+			IPVersion:          ipVersion,
+		}
+	}
+
+	state := runTest(t, newOptions(ipVersion4))
+	require.Equal(t, 3, state.processed)
+	require.Zero(t, state.errors)
+
+	state = runTest(t, newOptions(ipVersion6))
+	require.Zero(t, state.processed)
+	require.Equal(t, 3, state.errors)
+}
+
+func Test_runCookies(t *testing.T) {
+	p := createTestProxy(t, nil)
+
+	serverCookie := []byte("0123456789abcdef")
+
+	var withServerCookie atomic.Int32
+	p.RequestHandler = func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		client, server, _ := responseCookie(d.Req)
+		if string(server) == string(serverCookie) {
+			withServerCookie.Add(1)
+		}
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		addCookie(resp, append(client, serverCookie...))
+		d.Res = resp
+
+		return nil
+	}
+
+	err := p.Start(context.Background())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return p.Shutdown(context.Background())
+	})
+
+	o := &Options{
+		Address:      p.Addr(proxy.ProtoUDP).String(),
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: 5,
+		Cookies:      true,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, 5, state.processed)
+	require.Equal(t, 5, state.serverCookies)
+
+	// Only the first query is sent without the server cookie.
+	require.Equal(t, int32(4), withServerCookie.Load())
+}
+
+func Test_runEDNSVersion(t *testing.T) {
+	addr := startUDPServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		resp.SetEdns0(dns.DefaultMsgSize, false)
+
+		// Only support the EDNS version 0 as RFC 6891 requires.
+		if opt := req.IsEdns0(); opt != nil && opt.Version() > 0 {
+			resp.Rcode = dns.RcodeBadVers
+		}
+
+		_ = w.WriteMsg(resp)
+	})
+
+	o := &Options{
+		Address:      addr.String(),
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      10,
+		QueriesCount: 5,
+		EDNSVersion:  1,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, o.QueriesCount, state.rcodes[dns.RcodeBadVers])
+	require.Equal(t, "BADVERS: 5", state.rcodesBreakdown())
+}
+
+func Test_runNSID(t *testing.T) {
+	var n, withoutNSID atomic.Int32
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		opt := d.Req.IsEdns0()
+		if opt == nil || len(opt.Option) != 1 || opt.Option[0].Option() != dns.EDNS0NSID {
+			withoutNSID.Add(1)
+		}
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		resp.SetEdns0(dns.DefaultMsgSize, false)
+
+		nsid := "backend-1"
+		if n.Add(1)%2 == 0 {
+			nsid = "backend-2"
+			resp.Rcode = dns.RcodeServerFailure
+		}
+
+		resp.IsEdns0().Option = append(resp.IsEdns0().Option, &dns.EDNS0_NSID{
+			Code: dns.EDNS0NSID,
+			Nsid: hex.EncodeToString([]byte(nsid)),
+		})
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       4,
+		InsecureSkipVerify: true,
+		NSID:               true,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, 4, state.processed)
+	require.Zero(t, withoutNSID.Load())
+	require.Equal(t, map[string]map[int]int{
+		"backend-1": {dns.RcodeSuccess: 2},
+		"backend-2": {dns.RcodeServerFailure: 2},
+	}, state.nsidRcodes)
+	require.Equal(
+		t,
+		"  backend-1: 2 (NOERROR: 2)\n  backend-2: 2 (SERVFAIL: 2)",
+		state.nsidBreakdown(),
+	)
+}
+
+func Test_runSeed(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		names []string
+	)
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		mu.Lock()
+		names = append(names, d.Req.Question[0].Name)
+		mu.Unlock()
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "{random}.example.org",
+		RandomizeCase:      true,
+		Timeout:            10,
+		QueriesCount:       5,
+		InsecureSkipVerify: true,
+		Seed:               42,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, 5, state.processed)
+
+	first := names
+	names = nil
+
+	state = runTest(t, o)
+	require.Equal(t, 5, state.processed)
+	require.Equal(t, first, names)
+
+	o.Seed = 43
+	names = nil
+
+	_ = runTest(t, o)
+	require.NotEqual(t, first, names)
+}
+
+func Test_runReplay(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		names []string
+	)
+	p := createTestProxy(t, nil)
+	p.RequestHandler = func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		mu.Lock()
+		names = append(names, d.Req.Question[0].Name)
+		mu.Unlock()
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	}
+
+	err := p.Start(context.Background())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return p.Shutdown(context.Background())
+	})
+
+	var lines []string
+	for _, name := range []string{"a.example.", "b.example."} {
+		b, pErr := (&dns.Msg{}).SetQuestion(name, dns.TypeTXT).Pack()
+		require.NoError(t, pErr)
+
+		lines = append(lines, hex.EncodeToString(b))
+	}
+
+	path := filepath.Join(t.TempDir(), "queries.txt")
+	err = os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600)
+	require.NoError(t, err)
+
+	o := &Options{
+		Address:      p.Addr(proxy.ProtoUDP).String(),
+		Connections:  1,
+		ReplayFile:   path,
+		Timeout:      1,
+		QueriesCount: 5,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, 5, state.processed)
+	require.Equal(t, map[uint16]int{dns.TypeTXT: 5}, state.sentQTypes)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The file is replayed over again once it's exhausted.
+	require.Equal(t, []string{
+		"a.example.",
+		"b.example.",
+		"a.example.",
+		"b.example.",
+		"a.example.",
+	}, names)
+}
+
+func Test_runQType(t *testing.T) {
+	var qtypes sync.Map
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		qtypes.Store(d.Req.Question[0].Qtype, true)
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		QType:              "txt",
+		Timeout:            10,
+		QueriesCount:       5,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	_, ok := qtypes.Load(dns.TypeTXT)
+	require.True(t, ok)
+	_, ok = qtypes.Load(dns.TypeA)
+	require.False(t, ok)
+}
+
+func Test_runDuration(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        2,
+		Query:              "example.org",
+		Timeout:            10,
+		Rate:               20,
+		QueriesCount:       1000,
+		Duration:           time.Second,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+
+	require.Less(t, state.processed, o.QueriesCount)
+	require.Positive(t, state.processed)
+	require.Less(t, state.elapsed(), 2*time.Second)
+}
+
+func Test_runQueriesCount(t *testing.T) {
+	var reqCount atomic.Int32
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		reqCount.Add(1)
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	testCases := []struct {
+		name        string
+		count       int
+		connections int
+	}{{
+		name:        "one",
+		count:       1,
+		connections: 1,
+	}, {
+		name:        "one_many_connections",
+		count:       1,
+		connections: 8,
+	}, {
+		name:        "less_than_connections",
+		count:       3,
+		connections: 5,
+	}, {
+		name:        "not_divisible",
+		count:       10,
+		connections: 3,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reqCount.Store(0)
+
+			o := &Options{
+				Address:            serverAddress,
+				Connections:        tc.connections,
+				Query:              "example.org",
+				Timeout:            10,
+				QueriesCount:       tc.count,
+				InsecureSkipVerify: true,
+			}
+
+			state := runTest(t, o)
+
+			require.Equal(t, tc.count, state.processed)
+			require.Equal(t, tc.count, state.queriesSent)
+			require.Equal(t, int32(tc.count), reqCount.Load())
+		})
+	}
+}
+
+func Test_runUnlimited(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        2,
+		Query:              "example.org",
+		Timeout:            10,
+		Rate:               100,
+		QueriesCount:       0,
+		Duration:           500 * time.Millisecond,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+
+	require.Positive(t, state.processed)
+	require.Less(t, state.elapsed(), time.Second)
+}
+
+func Test_runOpenModel(t *testing.T) {
+	const delay = 200 * time.Millisecond
+
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		time.Sleep(delay)
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		Rate:               50,
+		OpenModel:          true,
+		QueriesCount:       20,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Zero(t, state.errors)
+	require.Zero(t, state.inFlight)
+	require.Greater(t, state.maxInFlight, 1)
+	require.GreaterOrEqual(t, state.latency.minimum(), delay)
+
+	// A single connection in the closed model would take at least 4s.
+	require.Less(t, state.elapsed(), 2*time.Second)
+}
+
+func Test_runOpenModel_notConcurrent(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Our own plain DNS-over-UDP client is used for multiple questions, and it
+	// can't be shared by the queries in flight.  Accept every message, since
+	// the default message acceptance function of the server responds to them
+	// with FORMERR right away, so that the queries don't overlap.
+	srv := &dns.Server{
+		PacketConn: pc,
+		MsgAcceptFunc: func(_ dns.Header) (action dns.MsgAcceptAction) {
+			return dns.MsgAccept
+		},
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			time.Sleep(50 * time.Millisecond)
+
+			resp := &dns.Msg{}
+			resp.SetReply(req)
+			_ = w.WriteMsg(resp)
+		}),
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+
+	o := &Options{
+		Address:      pc.LocalAddr().String(),
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      1,
+		Rate:         500,
+		OpenModel:    true,
+		QueriesCount: 50,
+		Questions:    2,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Zero(t, state.errors)
+	require.Greater(t, state.maxInFlight, 1)
+}
+
+func Test_runMaxOutstanding(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		time.Sleep(200 * time.Millisecond)
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		Rate:               50,
+		OpenModel:          true,
+		MaxOutstanding:     3,
+		QueriesCount:       20,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+
+	require.Zero(t, state.errors)
+	require.LessOrEqual(t, state.maxInFlight, o.MaxOutstanding)
+	require.Positive(t, state.skipped)
+	require.Equal(t, o.QueriesCount, state.processed+state.skipped)
+}
+
+func Test_runTruncated(t *testing.T) {
+	addr := startTruncatingServer(t)
+
+	testCases := []struct {
+		name           string
+		lateWait       time.Duration
+		openModel      bool
+		sharedUpstream bool
+	}{{
+		name:           "per_query_client",
+		lateWait:       0,
+		openModel:      false,
+		sharedUpstream: false,
+	}, {
+		name:           "own_client",
+		lateWait:       time.Second,
+		openModel:      false,
+		sharedUpstream: false,
+	}, {
+		name:           "shared_upstream",
+		lateWait:       0,
+		openModel:      false,
+		sharedUpstream: true,
+	}, {
+		name:           "open_model",
+		lateWait:       0,
+		openModel:      true,
+		sharedUpstream: false,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := &Options{
+				Address:        addr,
+				Connections:    1,
+				Query:          "example.org",
+				Timeout:        1,
+				QueriesCount:   5,
+				LateWait:       tc.lateWait,
+				OpenModel:      tc.openModel,
+				SharedUpstream: tc.sharedUpstream,
+			}
+			if tc.openModel {
+				o.Rate = 100
+			}
+
+			state := runTest(t, o)
+
+			require.Equal(t, o.QueriesCount, state.processed)
+			require.Zero(t, state.errors)
+			require.Equal(t, o.QueriesCount, state.truncated)
+		})
+	}
+}
+
+func Test_runTCPRatio(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	require.NoError(t, err)
+
+	var tcpQueries atomic.Int64
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+			tcpQueries.Add(1)
+		}
+
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+
+		_ = w.WriteMsg(resp)
+	})
+
+	for _, srv := range []*dns.Server{
+		{PacketConn: pc, Handler: handler},
+		{Listener: l, Handler: handler},
+	} {
+		go func() { _ = srv.ActivateAndServe() }()
+		testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+	}
+
+	o := &Options{
+		Address:      pc.LocalAddr().String(),
+		Connections:  2,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: 40,
+		TCPRatio:     0.5,
+		Seed:         1,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Zero(t, state.errors)
+
+	udp, tcp := state.statsUDP.latency.count(), state.statsTCP.latency.count()
+	require.Equal(t, o.QueriesCount, udp+tcp)
+	require.Equal(t, int64(tcp), tcpQueries.Load())
+	require.Positive(t, udp)
+	require.Positive(t, tcp)
+
+	o.Address = "tls://" + o.Address
+	_, err = run(context.Background(), o, nil)
+	require.Error(t, err)
+}
+
+func Test_runDoHMethod(t *testing.T) {
+	type request struct {
+		method string
+		proto  int
+	}
+
+	reqs := make(chan request, 100)
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		reqs <- request{method: d.HTTPRequest.Method, proto: d.HTTPRequest.ProtoMajor}
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	testCases := []struct {
+		name        string
+		method      string
+		httpVersion string
+		want        request
+	}{{
+		name:        "get",
+		method:      http.MethodGet,
+		httpVersion: "",
+		want:        request{method: http.MethodGet, proto: 2},
+	}, {
+		name:        "get_http1",
+		method:      http.MethodGet,
+		httpVersion: "1.1",
+		want:        request{method: http.MethodGet, proto: 1},
+	}, {
+		name:        "get_http2",
+		method:      http.MethodGet,
+		httpVersion: "2",
+		want:        request{method: http.MethodGet, proto: 2},
+	}, {
+		name:        "post",
+		method:      http.MethodPost,
+		httpVersion: "",
+		want:        request{method: http.MethodPost, proto: 2},
+	}, {
+		name:        "post_http1",
+		method:      http.MethodPost,
+		httpVersion: "1.1",
+		want:        request{method: http.MethodPost, proto: 1},
+	}, {
+		name:        "post_http2",
+		method:      http.MethodPost,
+		httpVersion: "2",
+		want:        request{method: http.MethodPost, proto: 2},
+	}, {
+		name:        "post_lowercase",
+		method:      "post",
+		httpVersion: "",
+		want:        request{method: http.MethodPost, proto: 2},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := &Options{
+				Address:            serverAddress,
+				Connections:        1,
+				Query:              "example.org",
+				Timeout:            10,
+				QueriesCount:       5,
+				DoHMethod:          tc.method,
+				HTTPVersion:        tc.httpVersion,
+				InsecureSkipVerify: true,
+			}
+
+			state := runTest(t, o)
+			require.Equal(t, o.QueriesCount, state.processed)
+
+			// The options may be reused for other runs, so they must not be
+			// changed.
+			require.Equal(t, tc.method, o.DoHMethod)
+
+			for range o.QueriesCount {
+				require.Equal(t, tc.want, <-reqs)
+			}
+		})
+	}
+}
+
+func Test_runRcodes(t *testing.T) {
+	var reqCount atomic.Int32
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		if reqCount.Add(1)%4 == 0 {
+			resp.Rcode = dns.RcodeServerFailure
+		}
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       8,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, map[int]int{dns.RcodeSuccess: 6, dns.RcodeServerFailure: 2}, state.rcodes)
+	require.Equal(t, "NOERROR: 6, SERVFAIL: 2", state.rcodesBreakdown())
+
+	// None of the responses have answers.
+	require.Equal(t, 6, state.noData)
+}
+
+func Test_runQuestions(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// The default message acceptance function of the server responds to the
+	// queries with more than one question with FORMERR.
+	srv := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			resp := &dns.Msg{}
+			resp.SetReply(req)
+			_ = w.WriteMsg(resp)
+		}),
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+
+	o := &Options{
+		Address:      pc.LocalAddr().String(),
+		Connections:  1,
+		Query:        "example.org",
+		QType:        "ANY",
+		Timeout:      1,
+		QueriesCount: 10,
+		Questions:    2,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, map[int]int{dns.RcodeFormatError: o.QueriesCount}, state.rcodes)
+
+	o.Questions = 1
+	state = runTest(t, o)
+	require.Equal(t, map[int]int{dns.RcodeSuccess: o.QueriesCount}, state.rcodes)
+}
+
+func Test_runUniqueNames(t *testing.T) {
+	var mu sync.Mutex
+	names := map[string]int{}
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		mu.Lock()
+		names[d.Req.Question[0].Name]++
+		mu.Unlock()
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        2,
+		Query:              "{random}.example.org",
+		Timeout:            10,
+		QueriesCount:       30,
+		UniqueNames:        3,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, names, o.UniqueNames)
+	for name, n := range names {
+		require.Equal(t, o.QueriesCount/o.UniqueNames, n, name)
+	}
+}
+
+func Test_runPlaceholders(t *testing.T) {
+	var mu sync.Mutex
+	names := map[string]int{}
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		mu.Lock()
+		names[d.Req.Question[0].Name]++
+		mu.Unlock()
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        2,
+		Query:              "{seq}.w{worker}.example.org",
+		Timeout:            10,
+		QueriesCount:       20,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Every query has its own sequence number.
+	require.Len(t, names, o.QueriesCount)
+	for i := 1; i <= o.QueriesCount; i++ {
+		_, ok0 := names[fmt.Sprintf("%d.w0.example.org.", i)]
+		_, ok1 := names[fmt.Sprintf("%d.w1.example.org.", i)]
+		require.True(t, ok0 != ok1, i)
+	}
+}
+
+func Test_runMismatchedResponses(t *testing.T) {
+	var reqCount atomic.Int32
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		if reqCount.Add(1)%2 == 0 {
+			resp.Question[0].Name = "spoofed.example."
+		}
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       10,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount/2, state.processed)
+	require.Equal(t, o.QueriesCount/2, state.errors)
+	require.Equal(t, o.QueriesCount/2, state.errorCategories[errCategoryMismatched])
+}
+
+func TestRandomizeCase(t *testing.T) {
+	const name = "www.example.org-1"
+
+	rng := newRand(1)
+
+	changed := false
+	for range 10 {
+		res := randomizeCase(rng, name)
+		require.True(t, strings.EqualFold(name, res))
+
+		changed = changed || res != name
+	}
+
+	require.True(t, changed)
+}
+
+func TestCheckErrorRate(t *testing.T) {
+	results := []*Result{{
+		Address: "1.1.1.1",
+		state:   &runState{processed: 99, errors: 1},
+	}, {
+		Address: "8.8.8.8",
+		state:   &runState{processed: 90, errors: 10},
+	}}
+
+	require.NoError(t, CheckErrorRate(&Options{}, results...))
+
+	threshold := 5.0
+	err := CheckErrorRate(&Options{FailOver: &threshold}, results...)
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), results[0].Address)
+	require.Contains(t, err.Error(), results[1].Address)
+
+	threshold = 10
+	require.NoError(t, CheckErrorRate(&Options{FailOver: &threshold}, results...))
+}
+
+func BenchmarkExpandHostname(b *testing.B) {
+	options := &Options{RandomizeCase: true}
+
+	const hostname = "{random}.example.org"
+
+	b.Run("shared", func(b *testing.B) {
+		var mu sync.Mutex
+		names := &nameParams{rng: newRand(1)}
+
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				mu.Lock()
+				_ = expandHostname(options, names, hostname)
+				mu.Unlock()
+			}
+		})
+	})
+
+	b.Run("per_worker", func(b *testing.B) {
+		var seed atomic.Int64
+
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			names := &nameParams{rng: newRand(seed.Add(1))}
+			for pb.Next() {
+				_ = expandHostname(options, names, hostname)
+			}
+		})
+	})
+}
+
+func Test_runWarmup(t *testing.T) {
+	var reqCount atomic.Int32
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		reqCount.Add(1)
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        2,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       5,
+		Warmup:             3,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, o.QueriesCount, state.latency.count())
+	require.Equal(t, int32(o.QueriesCount+o.Connections*o.Warmup), reqCount.Load())
+
+	// The connections have been established during the warmup.
+	require.Zero(t, state.latencyFirst.count())
+}
+
+func Test_runFreshConnection(t *testing.T) {
+	var clientAddrs sync.Map
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		clientAddrs.Store(d.Addr, true)
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       5,
+		FreshConnection:    true,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, o.QueriesCount, state.latencyFirst.count())
+
+	n := 0
+	clientAddrs.Range(func(_, _ any) (cont bool) {
+		n++
+
+		return true
+	})
+	require.Equal(t, o.QueriesCount, n)
+}
+
+func TestReportPeriodically(t *testing.T) {
+	state := &runState{
+		startTime:      time.Now(),
+		processed:      42,
+		errors:         1,
+		reportInterval: 10 * time.Millisecond,
+	}
+
+	done := make(chan bool)
+	go reportPeriodically(state, state.reportInterval, done)
+	defer close(done)
+
+	require.Eventually(t, func() (ok bool) {
+		state.m.Lock()
+		defer state.m.Unlock()
+
+		return state.lastPrintedProcessed == 42 && state.lastPrintedErrors == 1
+	}, time.Second, state.reportInterval)
+}
+
+func Test_runTLSResumption(t *testing.T) {
+	tlsConfig, _ := createServerTLSConfig(t, "example.org")
+	p := createTestProxy(t, tlsConfig)
+	p.RequestHandler = func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	}
+
+	err := p.Start(context.Background())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return p.Shutdown(context.Background())
+	})
+
+	dotAddr := fmt.Sprintf("tls://%s", p.Addr(proxy.ProtoTLS))
+	dohAddr := fmt.Sprintf("https://%s/dns-query", p.Addr(proxy.ProtoHTTPS))
+
+	testCases := []struct {
+		name       string
+		address    string
+		resumption string
+		wantResume int
+	}{{
+		name:       "dot_on",
+		address:    dotAddr,
+		resumption: tlsResumptionOn,
+		wantResume: 4,
+	}, {
+		name:       "dot_off",
+		address:    dotAddr,
+		resumption: tlsResumptionOff,
+		wantResume: 0,
+	}, {
+		name:       "doh_on",
+		address:    dohAddr,
+		resumption: tlsResumptionOn,
+		wantResume: 4,
+	}, {
+		name:       "doh_off",
+		address:    dohAddr,
+		resumption: tlsResumptionOff,
+		wantResume: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := &Options{
+				Address:            tc.address,
+				Connections:        1,
+				Query:              "example.org",
+				Timeout:            10,
+				QueriesCount:       5,
+				FreshConnection:    true,
+				TLSResumption:      tc.resumption,
+				InsecureSkipVerify: true,
+			}
+
+			state := runTest(t, o)
+
+			require.Equal(t, o.QueriesCount, state.processed)
+			require.Equal(t, o.QueriesCount, state.tlsHandshakes)
+			require.Equal(t, tc.wantResume, state.tlsResumed)
+		})
+	}
+}
+
+func Test_runQUIC0RTT(t *testing.T) {
+	tlsConfig, _ := createServerTLSConfig(t, "example.org")
+	p := createTestProxy(t, tlsConfig)
+	p.RequestHandler = func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	}
+
+	err := p.Start(context.Background())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return p.Shutdown(context.Background())
+	})
+
+	o := &Options{
+		Address:            fmt.Sprintf("quic://%s", p.Addr(proxy.ProtoQUIC)),
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       5,
+		FreshConnection:    true,
+		QUIC0RTT:           true,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, o.QueriesCount, state.quic0RTT+state.quic1RTT)
+
+	// The first connection has no session to resume.
+	require.Equal(t, o.QueriesCount-1, state.quic0RTT)
+	require.Zero(t, state.quic0RTTRejected)
+}
+
+func Test_runLocalAddress(t *testing.T) {
+	p := createTestProxy(t, nil)
+
+	var clientAddrs sync.Map
+	p.RequestHandler = func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		clientAddrs.Store(d.Addr.Addr(), true)
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	}
+
+	err := p.Start(context.Background())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return p.Shutdown(context.Background())
+	})
+
+	o := &Options{
+		Address:      p.Addr(proxy.ProtoUDP).String(),
+		Connections:  1,
+		Query:        "example.org",
+		Timeout:      1,
+		QueriesCount: 3,
+		LocalAddress: "127.0.0.1",
+	}
+
+	state := runTest(t, o)
+
+	require.Equal(t, o.QueriesCount, state.processed)
+	_, ok := clientAddrs.Load(netip.MustParseAddr("127.0.0.1"))
+	require.True(t, ok)
+}
+
+func Test_runCSVOutput(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	csvPath := filepath.Join(t.TempDir(), "queries.csv")
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        2,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       10,
+		InsecureSkipVerify: true,
+		CSVOutput:          csvPath,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	f, err := os.Open(csvPath)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, f.Close)
+
+	records, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, o.QueriesCount+1)
+	require.Equal(t, "query_name", records[0][2])
+	require.Equal(t, "example.org", records[1][2])
+	require.Equal(t, "A", records[1][3])
+	require.Equal(t, "NOERROR", records[1][4])
+	require.Empty(t, records[1][6])
+}
+
+func Test_runTimeseriesCSV(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	csvPath := filepath.Join(t.TempDir(), "timeseries.csv")
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        2,
+		Query:              "example.org",
+		Timeout:            10,
+		Rate:               20,
+		QueriesCount:       30,
+		InsecureSkipVerify: true,
+		TimeseriesCSV:      csvPath,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	f, err := os.Open(csvPath)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, f.Close)
+
+	records, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+
+	// The test takes about 1.5s, so there is at least a row for the first
+	// second and the final one for the rest.
+	require.Greater(t, len(records), 2)
+	require.Equal(t, "queries_this_second", records[0][1])
+
+	total := 0
+	for _, rec := range records[1:] {
+		n, aErr := strconv.Atoi(rec[1])
+		require.NoError(t, aErr)
+		require.Equal(t, "0", rec[2])
+
+		total += n
+	}
+
+	require.Equal(t, o.QueriesCount, total)
+}
+
+func Test_queryLogFields(t *testing.T) {
+	q := query{qtype: dns.TypeAAAA}
+
+	s := queryLogFields(q, "example.org", 2, 15*time.Millisecond)
+	require.Equal(t, "name=example.org. qtype=AAAA worker=2 elapsed=15ms", s)
+
+	q.ecs = netip.MustParsePrefix("1.2.3.0/24")
+	s = queryLogFields(q, "example.org.", 0, time.Second)
+	require.Equal(t, "name=example.org. qtype=AAAA worker=0 elapsed=1s subnet=1.2.3.0/24", s)
+}
+
+func Test_runJSONLOutput(t *testing.T) {
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	jsonlPath := filepath.Join(t.TempDir(), "report.jsonl")
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       250,
+		Quiet:              true,
+		InsecureSkipVerify: true,
+		JSONLOutput:        jsonlPath,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	b, err := os.ReadFile(jsonlPath)
+	require.NoError(t, err)
+
+	// The intermediate results are reported every 100 queries even in the
+	// quiet mode.
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	for i, line := range lines {
+		rec := &intermediateRecord{}
+		err = json.Unmarshal([]byte(line), rec)
+		require.NoError(t, err)
+
+		require.Equal(t, (i+1)*printEveryNRecords, rec.Processed)
+		require.Zero(t, rec.Errors)
+		require.Positive(t, rec.QPS)
+		require.False(t, rec.Time.IsZero())
+	}
+}
+
+func Test_newQueryMsg(t *testing.T) {
+	q := query{hostname: "example.org", qtype: dns.TypeA, qclass: dns.ClassINET}
+
+	m := newQueryMsg(&Options{}, q, q.hostname)
+	require.Nil(t, m.IsEdns0())
+	require.Equal(t, "example.org.", m.Question[0].Name)
+	require.True(t, m.RecursionDesired)
+
+	m = newQueryMsg(&Options{NoRD: true}, q, q.hostname)
+	require.False(t, m.RecursionDesired)
+	require.False(t, m.CheckingDisabled)
+
+	m = newQueryMsg(&Options{CD: true, DNSSEC: true}, q, q.hostname)
+	require.True(t, m.CheckingDisabled)
+	require.True(t, m.IsEdns0().Do())
+
+	m = newQueryMsg(&Options{UDPSize: 1232}, q, q.hostname)
+	opt := m.IsEdns0()
+	require.NotNil(t, opt)
+	require.Equal(t, uint16(1232), opt.UDPSize())
+	require.False(t, opt.Do())
+
+	m = newQueryMsg(&Options{DNSSEC: true}, q, q.hostname)
+	opt = m.IsEdns0()
+	require.NotNil(t, opt)
+	require.Equal(t, uint16(dns.DefaultMsgSize), opt.UDPSize())
+	require.True(t, opt.Do())
+
+	m = newQueryMsg(&Options{Questions: 3}, q, q.hostname)
+	require.Len(t, m.Question, 3)
+	require.Equal(t, m.Question[0], m.Question[2])
+
+	m = newQueryMsg(&Options{NoRandomID: true, QueryID: 42}, q, q.hostname)
+	require.Equal(t, uint16(42), m.Id)
+
+	m = newQueryMsg(&Options{UDPSize: 1232, DNSSEC: true}, q, q.hostname)
+	require.Len(t, m.Extra, 1)
+	require.Equal(t, uint16(1232), m.IsEdns0().UDPSize())
+
+	q.ecs = netip.MustParsePrefix("1.2.3.0/24")
+	m = newQueryMsg(&Options{DNSSEC: true, Padding: 128}, q, q.hostname)
+	require.Len(t, m.Extra, 1)
+	opt = m.IsEdns0()
+	require.True(t, opt.Do())
+	require.Len(t, opt.Option, 2)
+	require.IsType(t, &dns.EDNS0_SUBNET{}, opt.Option[0])
+	require.IsType(t, &dns.EDNS0_PADDING{}, opt.Option[1])
+
+	b, err := m.Pack()
+	require.NoError(t, err)
+	require.Zero(t, len(b)%128)
+
+	m = newQueryMsg(&Options{UDPSize: 1232, EDNSVersion: 1, EDNSFlags: 0x4000}, q, q.hostname)
+	require.Len(t, m.Extra, 1)
+	opt = m.IsEdns0()
+	require.Equal(t, uint16(1232), opt.UDPSize())
+	require.Equal(t, uint8(1), opt.Version())
+	require.Equal(t, uint16(0x4000), opt.Z())
+	require.False(t, opt.Do())
+
+	q.ecs = netip.Prefix{}
+	q.qclass = dns.ClassCHAOS
+	m = newQueryMsg(&Options{}, q, q.hostname)
+	require.Equal(t, uint16(dns.ClassCHAOS), m.Question[0].Qclass)
+}
+
+// runTest runs the test configured by o and returns its final state.
+func runTest(t *testing.T, o *Options) (state *runState) {
+	t.Helper()
+
+	state, err := run(context.Background(), o, nil)
+	require.NoError(t, err)
+
+	return state
+}
+
+// startTestServer starts a test DNS-over-HTTPS server that processes requests
+// with the specified handler and returns its address.
+func startTestServer(t *testing.T, handler proxy.RequestHandler) (serverAddress string) {
+	t.Helper()
+
+	tlsConfig, _ := createServerTLSConfig(t, "example.org")
+	p := createTestProxy(t, tlsConfig)
+	p.RequestHandler = handler
+
+	err := p.Start(context.Background())
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, func() (err error) {
+		return p.Shutdown(context.Background())
+	})
+
+	return fmt.Sprintf("https://%s/dns-query", p.Addr(proxy.ProtoHTTPS))
+}
+
+// createTestProxy creates a test DNS proxy that listens to all protocols.
+func createTestProxy(t *testing.T, tlsConfig *tls.Config) (p *proxy.Proxy) {
+	listenIP := "127.0.0.1"
+	cfg := &proxy.Config{}
+
+	if tlsConfig != nil {
+		cfg.TLSListenAddr = []*net.TCPAddr{
+			{Port: 0, IP: net.ParseIP(listenIP)},
+		}
+		cfg.HTTPSListenAddr = []*net.TCPAddr{
+			{Port: 0, IP: net.ParseIP(listenIP)},
+		}
+		cfg.QUICListenAddr = []*net.UDPAddr{
+			{Port: 0, IP: net.ParseIP(listenIP)},
+		}
+		cfg.TLSConfig = tlsConfig
+	} else {
+		cfg.UDPListenAddr = []*net.UDPAddr{
+			{Port: 0, IP: net.ParseIP(listenIP)},
+		}
+		cfg.TCPListenAddr = []*net.TCPAddr{
+			{Port: 0, IP: net.ParseIP(listenIP)},
+		}
+	}
+
+	// Setting a local upstream, it won't be used anyway since RequestHandler
+	// will handler all requests.
+	cfg.UpstreamConfig = &proxy.UpstreamConfig{}
+	dnsUpstream, err := upstream.AddressToUpstream(
+		"127.0.0.1:12312",
+		&upstream.Options{},
+	)
+	require.NoError(t, err)
+	cfg.UpstreamConfig.Upstreams = append(cfg.UpstreamConfig.Upstreams, dnsUpstream)
+
+	p, err = proxy.New(cfg)
+	require.NoError(t, err)
+
+	return p
+}
+
+// createServerTLSConfig creates a TLS configuration to be used by the server.
+func createServerTLSConfig(
+	t *testing.T,
+	tlsServerName string,
+) (tlsConfig *tls.Config, certPem []byte) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	require.NoError(t, err)
+
+	notBefore := time.Now()
+	notAfter := notBefore.Add(5 * 365 * time.Hour * 24)
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"AdGuard Tests"},
+		},
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+
+		KeyUsage: x509.KeyUsageKeyEncipherment |
+			x509.KeyUsageDigitalSignature |
+			x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	template.DNSNames = append(template.DNSNames, tlsServerName)
+
+	derBytes, err := x509.CreateCertificate(
+		rand.Reader,
+		&template,
+		&template,
+		publicKey(privateKey),
+		privateKey,
+	)
+	require.NoError(t, err)
+
+	certPem = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	require.NoError(t, err)
+
+	tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, ServerName: tlsServerName}
+
+	return tlsConfig, certPem
+}
+
+// publicKey returns a public key extracted from the specified private key.
+func publicKey(priv any) (pk any) {
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,52 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultPortPlain is the default port of the plain DNS servers.
+const defaultPortPlain = 53
+
+// newBootstrapResolver returns the resolver querying the plain DNS servers from
+// the comma-separated list of IP addresses with optional ports in servers, e.g.
+// "8.8.8.8,1.1.1.1:53".  The servers are used in turn so that the retries of
+// the resolver go to the next one.  It returns nil if servers is empty.
+func newBootstrapResolver(servers string) (r *net.Resolver, err error) {
+	if servers == "" {
+		return nil, nil
+	}
+
+	var addrs []netip.AddrPort
+	for _, s := range strings.Split(servers, ",") {
+		s = strings.TrimSpace(s)
+
+		addr, pErr := netip.ParseAddrPort(s)
+		if pErr != nil {
+			ip, ipErr := netip.ParseAddr(strings.Trim(s, "[]"))
+			if ipErr != nil {
+				return nil, fmt.Errorf("bootstrap server %q must be an ip address with an optional port", s)
+			}
+
+			addr = netip.AddrPortFrom(ip, defaultPortPlain)
+		}
+
+		addrs = append(addrs, addr)
+	}
+
+	var next atomic.Uint64
+	dialer := &net.Dialer{}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (conn net.Conn, err error) {
+			addr := addrs[(next.Add(1)-1)%uint64(len(addrs))]
+
+			return dialer.DialContext(ctx, network, addr.String())
+		},
+	}, nil
+}
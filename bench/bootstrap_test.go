@@ -0,0 +1,99 @@
+package bench
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBootstrapResolver(t *testing.T) {
+	r, err := newBootstrapResolver("")
+	require.NoError(t, err)
+	assert.Nil(t, r)
+
+	r, err = newBootstrapResolver("8.8.8.8, 1.1.1.1:5353,[2001:db8::1]")
+	require.NoError(t, err)
+	assert.NotNil(t, r)
+
+	_, err = newBootstrapResolver("dns.google")
+	assert.Error(t, err)
+}
+
+// startUDPServer starts a plain DNS-over-UDP server with handler and returns
+// its address.
+func startUDPServer(t *testing.T, handler dns.HandlerFunc) (addr netip.AddrPort) {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &dns.Server{PacketConn: pc, Handler: handler}
+	go func() { _ = srv.ActivateAndServe() }()
+	testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+
+	return pc.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func Test_runBootstrap(t *testing.T) {
+	addr := startUDPServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		_ = w.WriteMsg(resp)
+	})
+
+	// The bootstrap server resolves the hostname of the server to its
+	// address.
+	var lookups atomic.Int64
+	bootstrap := startUDPServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		lookups.Add(1)
+
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		if q := req.Question[0]; q.Qtype == dns.TypeA {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   addr.Addr().AsSlice(),
+			})
+		}
+		_ = w.WriteMsg(resp)
+	})
+
+	testCases := []struct {
+		name     string
+		lateWait time.Duration
+	}{{
+		name:     "dnsproxy",
+		lateWait: 0,
+	}, {
+		name:     "own_client",
+		lateWait: time.Second,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lookups.Store(0)
+
+			o := &Options{
+				Address:      net.JoinHostPort("dns.test", strconv.Itoa(int(addr.Port()))),
+				Bootstrap:    bootstrap.String(),
+				Connections:  1,
+				Query:        "example.org",
+				Timeout:      1,
+				QueriesCount: 5,
+				LateWait:     tc.lateWait,
+			}
+
+			state := runTest(t, o)
+			require.Equal(t, o.QueriesCount, state.processed)
+			require.Positive(t, lookups.Load())
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// RunComparison runs the same test against options.Address and
+// options.AddressB simultaneously and returns the results of both tests.  Every
+// value received from snapshots prints the results of both tests so far, see
+// [Run].
+func RunComparison(
+	ctx context.Context,
+	options *Options,
+	snapshots <-chan os.Signal,
+) (resA, resB *Result, err error) {
+	if options.hasOutputFiles() {
+		return nil, nil, errors.Error("--address-b can't be used with --json-output, --prometheus-output, --jsonl-output, --csv or --timeseries-csv")
+	}
+
+	if options.StatsD != "" {
+		// The metrics of both tests would be mixed up.
+		return nil, nil, errors.Error("--address-b can't be used with --statsd")
+	}
+
+	// The intermediate results of the tests would be indistinguishable.
+	optionsA := *options
+	optionsA.Quiet = true
+
+	// Use the same random names and choices in both tests.
+	if optionsA.Seed == 0 {
+		optionsA.Seed = time.Now().UnixNano()
+	}
+
+	// The process-wide settings are applied by the first test.
+	optionsB := optionsA
+	optionsB.Address = options.AddressB
+	optionsB.CPUAffinity = ""
+
+	log.Info(
+		"Comparing %s and %s using the random seed %d",
+		options.Address,
+		options.AddressB,
+		optionsA.Seed,
+	)
+
+	var stateA, stateB *runState
+	var errA, errB error
+
+	var snapshotsA, snapshotsB <-chan os.Signal
+	if snapshots != nil {
+		done := make(chan struct{})
+		defer close(done)
+
+		snapshotsA, snapshotsB = fanOutSnapshots(snapshots, done)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+
+		stateA, errA = run(ctx, &optionsA, snapshotsA)
+	}()
+	go func() {
+		defer wg.Done()
+
+		stateB, errB = run(ctx, &optionsB, snapshotsB)
+	}()
+	wg.Wait()
+
+	err = errors.Join(errA, errB)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newResult(&optionsA, stateA), newResult(&optionsB, stateB), nil
+}
+
+// PrintComparison logs the results of the tests against two servers side by
+// side.
+func PrintComparison(resA, resB *Result) {
+	addrA, addrB, stateA, stateB := resA.Address, resB.Address, resA.state, resB.state
+
+	processedA, errsA := stateA.counts()
+	processedB, errsB := stateB.counts()
+
+	rows := [][3]any{
+		{"Elapsed", stateA.elapsed(), stateB.elapsed()},
+		{"Average QPS", fmt.Sprintf("%f", stateA.qpsTotal()), fmt.Sprintf("%f", stateB.qpsTotal())},
+		{"Processed queries", processedA, processedB},
+		{"Errors count", errsA, errsB},
+		{"Latency min", stateA.latency.minimum(), stateB.latency.minimum()},
+		{"Latency average", stateA.latency.average(), stateB.latency.average()},
+		{"Latency p50", stateA.latency.percentile(50), stateB.latency.percentile(50)},
+		{"Latency p90", stateA.latency.percentile(90), stateB.latency.percentile(90)},
+		{"Latency p99", stateA.latency.percentile(99), stateB.latency.percentile(99)},
+		{"Latency max", stateA.latency.maximum(), stateB.latency.maximum()},
+	}
+
+	b := &strings.Builder{}
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "\tA: %s\tB: %s\n", addrA, addrB)
+	for _, r := range rows {
+		_, _ = fmt.Fprintf(w, "%s:\t%v\t%v\n", r[0], r[1], r[2])
+	}
+	_ = w.Flush()
+
+	log.Info("The comparison results are:\n%s", strings.TrimSuffix(b.String(), "\n"))
+}
@@ -0,0 +1,88 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunComparison(t *testing.T) {
+	newHandler := func(count *atomic.Int32) (h proxy.RequestHandler) {
+		return func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+			count.Add(1)
+
+			resp := &dns.Msg{}
+			resp.SetReply(d.Req)
+			d.Res = resp
+
+			return nil
+		}
+	}
+
+	var countA, countB atomic.Int32
+	o := &Options{
+		Address:            startTestServer(t, newHandler(&countA)),
+		AddressB:           startTestServer(t, newHandler(&countB)),
+		Connections:        2,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       10,
+		InsecureSkipVerify: true,
+	}
+
+	resA, resB, err := RunComparison(context.Background(), o, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, o.QueriesCount, resA.Processed)
+	require.Equal(t, o.QueriesCount, resB.Processed)
+	require.Equal(t, int32(o.QueriesCount), countA.Load())
+	require.Equal(t, int32(o.QueriesCount), countB.Load())
+
+	PrintComparison(resA, resB)
+}
+
+func TestRunComparison_snapshots(t *testing.T) {
+	handler := func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	}
+
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	snapshots := make(chan os.Signal, 1)
+	snapshots <- syscall.SIGHUP
+
+	o := &Options{
+		Address:            startTestServer(t, handler),
+		AddressB:           startTestServer(t, handler),
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       5,
+		Delay:              50 * time.Millisecond,
+		InsecureSkipVerify: true,
+	}
+
+	resA, resB, err := RunComparison(context.Background(), o, snapshots)
+	require.NoError(t, err)
+
+	// A single signal prints the snapshots of both tests.
+	require.Equal(t, o.QueriesCount, resA.Processed)
+	require.Equal(t, o.QueriesCount, resB.Processed)
+	require.Equal(t, 2, strings.Count(out.String(), "Snapshot after"))
+}
@@ -0,0 +1,528 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// runConnections runs the connections of the test described by options and
+// returns once all of them have finished.  progress is begun once the warmup
+// is finished.
+func runConnections(ctx context.Context, options *Options, state *runState, progress *progressBar) {
+	var wg sync.WaitGroup
+	if options.OpenModel {
+		options.logProgress(
+			"Starting the test and sending %d queries per second over %d connections",
+			options.Rate,
+			options.Connections,
+		)
+
+		wg.Add(1)
+		go func() {
+			runOpenModel(ctx, options, state, state.workerRand(options.Connections))
+			wg.Done()
+		}()
+	} else {
+		options.logProgress(
+			"Starting the test and running %d connections in parallel",
+			options.Connections,
+		)
+
+		for i := 0; i < options.Connections; i++ {
+			wg.Add(1)
+			go func() {
+				runConnection(ctx, options, state, i, state.workerRand(i))
+				wg.Done()
+			}()
+		}
+	}
+
+	if options.Warmup > 0 {
+		state.warmupWG.Wait()
+		options.logProgress("Finished the warmup")
+		state.startMeasurement(options.Duration)
+		close(state.warmupFinished)
+	}
+
+	progress.begin()
+
+	wg.Wait()
+
+	progress.stop()
+
+	options.logProgress("Finished running all connections")
+}
+
+// runConnection sends queries over a single connection until the test is
+// finished or ctx is cancelled.  workerID is the index of the connection.
+func runConnection(
+	ctx context.Context,
+	options *Options,
+	state *runState,
+	workerID int,
+	rng *rand.Rand,
+) {
+	c := newConnection(options, state, workerID, rng)
+	defer c.close()
+
+	if options.Warmup > 0 {
+		c.warmUp(ctx)
+	}
+
+	for !state.deadlineExceeded() && !isCancelled(ctx) {
+		q, ok := state.nextQuery()
+		if !ok || !c.send(ctx, q) {
+			break
+		}
+
+		pause(ctx, state, options.Delay)
+	}
+}
+
+// connection is a single connection of the test that sends the queries one
+// after another.  It isn't safe for concurrent use.
+type connection struct {
+	// options are the options of the test.
+	options *Options
+
+	// state is the state of the test.
+	state *runState
+
+	// targetOptions are the options of the servers the queries are sent to,
+	// see [connectionOptions].
+	targetOptions []*Options
+
+	// upstreams are the upstreams of the servers from targetOptions.  They
+	// are re-created on errors.
+	upstreams []upstream.Upstream
+
+	// isNew is true for the upstreams no query has been answered over yet, so
+	// that the next response includes the time to establish the connection.
+	isNew []bool
+
+	// tcp are the plain DNS-over-TCP upstreams of the servers from
+	// targetOptions used to retry the truncated responses and to send the
+	// queries over TCP.  They are created on the first use.
+	tcp []upstream.Upstream
+
+	// names are the parameters of the queried names.
+	names *nameParams
+
+	// jar keeps the DNS cookies of the worker, it's nil unless they're
+	// enabled.
+	jar *cookieJar
+
+	// bo is nil unless the backoff is enabled.
+	bo *backoff
+
+	// rng picks the transport of the queries.
+	rng *rand.Rand
+
+	// lastResponse is the time of the last response over the upstream.
+	lastResponse time.Time
+
+	// keepalive is the idle timeout the server has advertised for the
+	// upstream in the EDNS0 TCP keepalive option.
+	keepalive time.Duration
+
+	// workerID is the index of the connection.
+	workerID int
+}
+
+// newConnection creates a new *connection with workerID for the test.  It
+// has an upstream per server if the queries are split between multiple
+// servers.
+func newConnection(options *Options, state *runState, workerID int, rng *rand.Rand) (c *connection) {
+	targetOptions := connectionOptions(options, state)
+	upstreams := make([]upstream.Upstream, len(targetOptions))
+	isNew := make([]bool, len(targetOptions))
+	for i, o := range targetOptions {
+		upstreams[i] = createUpstream(o, state)
+		isNew[i] = true
+	}
+
+	c = &connection{
+		options:       options,
+		state:         state,
+		targetOptions: targetOptions,
+		upstreams:     upstreams,
+		isNew:         isNew,
+		tcp:           make([]upstream.Upstream, len(targetOptions)),
+		names:         state.nameParams(rng, workerID),
+		bo:            newBackoff(options.BackoffMax),
+		rng:           rng,
+		workerID:      workerID,
+	}
+
+	if options.Cookies {
+		c.jar = newCookieJar()
+	}
+
+	return c
+}
+
+// close closes the upstreams of c.
+func (c *connection) close() {
+	for _, u := range c.tcp {
+		if u != nil {
+			log.OnCloserError(u, log.DEBUG)
+		}
+	}
+
+	for _, u := range c.upstreams {
+		log.OnCloserError(u, log.DEBUG)
+	}
+}
+
+// warmUp sends the warmup queries and waits for other connections to finish
+// theirs.
+func (c *connection) warmUp(ctx context.Context) {
+	// The warmup is only possible with a single server.
+	c.upstreams[0] = warmupConnection(ctx, c.options, c.state, c.upstreams[0], c.names)
+	c.isNew[0] = false
+
+	// Wait for other connections to finish the warmup.
+	c.state.warmupWG.Done()
+	<-c.state.warmupFinished
+}
+
+// send sends the query q and records its result.  It returns false if the
+// query has been abandoned due to the cancellation of ctx.
+func (c *connection) send(ctx context.Context, q query) (ok bool) {
+	domainName := expandHostname(c.options, c.names, q.hostname)
+
+	log.Debug("Querying %s", domainName)
+
+	q.cookie = c.jar.cookie()
+	m := newQueryMsg(c.options, q, domainName)
+
+	// Make sure we don't run faster than the pre-defined rate limit.  The
+	// latency is measured from the time the query should have been sent to
+	// account for the time it has been delayed by the previous ones.
+	start := c.state.rate.Take()
+
+	// Check if the server closes the connection after the idle timeout it
+	// has advertised.
+	idleExpired := c.keepalive > 0 && time.Since(c.lastResponse) > c.keepalive
+
+	q.overTCP = c.state.tcpRatio > 0 && c.rng.Float64() < c.state.tcpRatio
+	conn := c.upstreamFor(q)
+
+	resp, retried, err := c.exchange(ctx, conn, q, m, domainName)
+	elapsed := time.Since(start)
+
+	if err != nil && isCancelled(ctx) {
+		// The query has been abandoned due to the interruption, so it's
+		// neither answered nor failed.
+		log.Debug("Query %s has been cancelled", domainName)
+
+		return false
+	}
+
+	if err == nil {
+		err = validateResponse(m, resp)
+	}
+
+	if err == nil {
+		c.jar.update(resp)
+	}
+
+	if !q.overTCP {
+		// The first responses are only tracked for the main upstream.
+		if err == nil && c.isNew[q.target] && !retried {
+			c.state.addFirstResponse(elapsed)
+		}
+		c.isNew[q.target] = false
+	}
+
+	c.state.addBytes(m, resp)
+	err = recordResult(c.state, q, domainName, resp, err, start, elapsed, c.workerID)
+	if idleExpired {
+		c.state.incIdleExpired(err != nil)
+	}
+
+	c.handleResult(ctx, conn, q, resp, err)
+
+	return true
+}
+
+// upstreamFor returns the pointer to the upstream q is sent over, so that it's
+// re-created on errors.
+func (c *connection) upstreamFor(q query) (conn *upstream.Upstream) {
+	if !q.overTCP {
+		return &c.upstreams[q.target]
+	}
+
+	c.createTCP(q.target)
+
+	return &c.tcp[q.target]
+}
+
+// createTCP creates the plain DNS-over-TCP upstream of the server with index
+// target unless it's already created and returns it.
+func (c *connection) createTCP(target int) (u upstream.Upstream) {
+	if c.tcp[target] == nil {
+		c.tcp[target] = newPlainTCPUpstream(c.targetOptions[target], c.state)
+	}
+
+	return c.tcp[target]
+}
+
+// exchange sends m for domainName over conn, retries it over a new connection
+// on errors, and retries the truncated responses over TCP.  retried is true if
+// the query has been retried after an error.
+func (c *connection) exchange(
+	ctx context.Context,
+	conn *upstream.Upstream,
+	q query,
+	m *dns.Msg,
+	domainName string,
+) (resp *dns.Msg, retried bool, err error) {
+	qOptions := c.targetOptions[q.target]
+	timeout := c.options.queryTimeout()
+
+	resp, err = exchangeTimeout(ctx, *conn, m, timeout)
+	for attempt := 0; shouldRetry(ctx, err) && attempt < c.options.Retries; attempt++ {
+		log.Debug("Retrying query %s after error: %v", domainName, err)
+
+		// Retry over a new connection since the current one may be broken.
+		log.OnCloserError(*conn, log.DEBUG)
+		*conn = createQueryUpstream(qOptions, c.state, q.overTCP)
+		retried = true
+
+		c.state.rate.Take()
+		resp, err = exchangeTimeout(ctx, *conn, m, timeout)
+	}
+
+	if retried && err == nil {
+		c.state.incRetried()
+	}
+
+	if err == nil && !q.overTCP {
+		resp, err = retryTruncated(ctx, qOptions, c.state, m, resp, func() (u upstream.Upstream) {
+			return c.createTCP(q.target)
+		})
+	}
+
+	return resp, retried, err
+}
+
+// handleResult backs off and re-creates the upstream conn the query q has been
+// sent over if it has failed with err.  Otherwise, it remembers the idle
+// timeout advertised in resp.
+func (c *connection) handleResult(
+	ctx context.Context,
+	conn *upstream.Upstream,
+	q query,
+	resp *dns.Msg,
+	err error,
+) {
+	if err != nil {
+		backoffConnection(ctx, c.state, c.bo)
+	} else {
+		c.bo.reset()
+	}
+
+	if c.options.TCPKeepalive != nil && err == nil && resp != nil {
+		c.keepalive, _ = responseTCPKeepalive(resp)
+		c.lastResponse = time.Now()
+	}
+
+	if err != nil || c.options.FreshConnection {
+		// We should re-create the upstream in this case.  In the fresh
+		// connection mode, every query is sent over a new connection.
+		log.OnCloserError(*conn, log.DEBUG)
+		*conn = createQueryUpstream(c.targetOptions[q.target], c.state, q.overTCP)
+		if !q.overTCP {
+			c.isNew[q.target] = true
+		}
+		c.keepalive = 0
+	}
+}
+
+// backoffConnection sleeps for the next delay of bo before the upstream is
+// re-created after an error.  The sleep is cut short by the test deadline or
+// the cancellation of ctx.
+func backoffConnection(ctx context.Context, state *runState, bo *backoff) {
+	d := bo.failure()
+	if d == 0 {
+		return
+	}
+
+	log.Debug("Backing off for %s before reconnecting", d)
+
+	pause(ctx, state, d)
+}
+
+// pause sleeps for d.  The sleep is cut short by the test deadline or the
+// cancellation of ctx.
+func pause(ctx context.Context, state *runState, d time.Duration) {
+	if !state.deadline.IsZero() {
+		d = min(d, time.Until(state.deadline))
+	}
+
+	if d <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// shouldRetry returns true if the query that has failed with err should be
+// retried, i.e. it's neither a late response nor the test is interrupted.
+func shouldRetry(ctx context.Context, err error) (ok bool) {
+	return err != nil && !errors.Is(err, errLateResponse) && !isCancelled(ctx)
+}
+
+// recordResult records the outcome of the query q for domainName sent at start
+// and answered in elapsed in state.  It returns err unless it's a late
+// response, i.e. if the upstream should be re-created.
+func recordResult(
+	state *runState,
+	q query,
+	domainName string,
+	resp *dns.Msg,
+	err error,
+	start time.Time,
+	elapsed time.Duration,
+	workerID int,
+) (res error) {
+	if state.nameResults != nil {
+		state.addNameResult(q.hostname, resp, err, elapsed)
+	}
+
+	if state.queryLog != nil {
+		state.queryLog.write(&queryRecord{
+			time:     start,
+			resp:     resp,
+			err:      err,
+			hostname: domainName,
+			latency:  elapsed,
+			workerID: workerID,
+			qtype:    q.qtype,
+		})
+	}
+
+	if errors.Is(err, errLateResponse) {
+		// Don't format the fields of every query unless they're logged.
+		if log.GetLevel() >= log.DEBUG {
+			log.Debug("Query answered late: %s", queryLogFields(q, domainName, workerID, elapsed))
+		}
+
+		_ = state.incLate(elapsed)
+
+		return nil
+	}
+
+	if state.tcpRatio > 0 {
+		state.addTransportResult(q.overTCP, elapsed, err != nil)
+	}
+
+	if len(state.targets) > 0 {
+		state.addTargetResult(q.target, elapsed, err != nil)
+	}
+
+	if state.trendWindow > 0 {
+		state.addWindowResult(start, elapsed, err != nil)
+	}
+
+	state.timeseries.add(elapsed, err != nil)
+
+	if err != nil {
+		_ = state.incErrors(workerID, err)
+		if log.GetLevel() >= log.DEBUG {
+			log.Debug(
+				"Query failed: %s category=%q error=%q",
+				queryLogFields(q, domainName, workerID, elapsed),
+				classifyError(err),
+				err,
+			)
+		}
+
+		return err
+	}
+
+	if log.GetLevel() >= log.DEBUG {
+		log.Debug(
+			"Query processed: %s rcode=%s answers=%d",
+			queryLogFields(q, domainName, workerID, elapsed),
+			rcodeToString(resp.Rcode),
+			len(resp.Answer),
+		)
+	}
+
+	if state.cdAB {
+		state.addCDLatency(q.checkingDisabled, elapsed)
+	}
+
+	state.countExtendedErrors(resp)
+	state.countResponsePadding(resp)
+	state.countTCPKeepalive(resp)
+	state.countServerCookie(resp)
+	state.countNSID(resp)
+	state.checkAnswer(resp)
+	state.slowest.add(domainName, q.qtype, resp.Rcode, elapsed)
+	_ = state.incResponse(workerID, resp, elapsed)
+
+	return nil
+}
+
+// queryLogFields returns the space-separated key-value pairs describing the
+// query for the debug log.  The client subnet is only included if it's sent.
+func queryLogFields(q query, domainName string, workerID int, elapsed time.Duration) (s string) {
+	s = fmt.Sprintf(
+		"name=%s qtype=%s worker=%d elapsed=%s",
+		dns.Fqdn(domainName),
+		dns.Type(q.qtype),
+		workerID,
+		elapsed,
+	)
+
+	if q.ecs.IsValid() {
+		s += " subnet=" + q.ecs.String()
+	}
+
+	return s
+}
+
+// warmupConnection sends options.Warmup queries using u without recording any
+// statistics.  It returns the upstream to be used for the test since it may be
+// re-created on errors.
+func warmupConnection(
+	ctx context.Context,
+	options *Options,
+	state *runState,
+	u upstream.Upstream,
+	names *nameParams,
+) (res upstream.Upstream) {
+	for i := 0; i < options.Warmup && !isCancelled(ctx); i++ {
+		q := state.warmupQuery(i)
+		m := newQueryMsg(options, q, expandHostname(options, names, q.hostname))
+
+		state.rate.Take()
+
+		_, err := exchangeTimeout(ctx, u, m, options.queryTimeout())
+		if err != nil {
+			log.Debug("warmup error occurred: %v", err)
+
+			log.OnCloserError(u, log.DEBUG)
+			u = createUpstream(options, state)
+		}
+	}
+
+	return u
+}
@@ -0,0 +1,73 @@
+package bench
+
+import (
+	"bytes"
+	"crypto/rand"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// clientCookieLen is the length of the client cookie (RFC 7873).
+const clientCookieLen = 8
+
+// cookieJar keeps the DNS cookies (RFC 7873) of a connection.  A nil
+// *cookieJar means that no cookies are sent.
+type cookieJar struct {
+	// mu protects server.  client is never changed.
+	mu *sync.Mutex
+
+	// client is the client cookie.
+	client []byte
+
+	// server is the last server cookie received for client.  It's empty
+	// until the server responds with a cookie.
+	server []byte
+}
+
+// newCookieJar returns a new *cookieJar with a random client cookie.
+func newCookieJar() (j *cookieJar) {
+	client := make([]byte, clientCookieLen)
+
+	// crypto/rand.Read never returns an error on supported platforms.
+	_, _ = rand.Read(client)
+
+	return &cookieJar{
+		mu:     &sync.Mutex{},
+		client: client,
+	}
+}
+
+// cookie returns the client cookie followed by the last known server cookie to
+// send with the next query.  It's safe for use on a nil *cookieJar.
+func (j *cookieJar) cookie() (c []byte) {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	c = make([]byte, 0, len(j.client)+len(j.server))
+	c = append(c, j.client...)
+
+	return append(c, j.server...)
+}
+
+// update remembers the server cookie from resp if it's issued for the client
+// cookie of j.  It's safe for use on a nil *cookieJar.
+func (j *cookieJar) update(resp *dns.Msg) {
+	if j == nil {
+		return
+	}
+
+	client, server, ok := responseCookie(resp)
+	if !ok || len(server) == 0 || !bytes.Equal(client, j.client) {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.server = server
+}
@@ -0,0 +1,34 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieJar(t *testing.T) {
+	var nilJar *cookieJar
+	assert.Nil(t, nilJar.cookie())
+
+	j := newCookieJar()
+	require.Len(t, j.cookie(), clientCookieLen)
+
+	server := []byte("serverck")
+
+	resp := &dns.Msg{}
+	addCookie(resp, append([]byte("otherclt"), server...))
+	j.update(resp)
+	assert.Len(t, j.cookie(), clientCookieLen)
+
+	resp = &dns.Msg{}
+	addCookie(resp, append(j.cookie(), server...))
+	j.update(resp)
+	assert.Equal(t, append(j.client, server...), j.cookie())
+
+	client, gotServer, ok := responseCookie(resp)
+	require.True(t, ok)
+	assert.Equal(t, j.client, client)
+	assert.Equal(t, server, gotServer)
+}
@@ -0,0 +1,137 @@
+package bench
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// queryRecord is the outcome of a single query.
+type queryRecord struct {
+	// time is the time when the query has been sent.
+	time time.Time
+
+	// resp is the response to the query.  It's nil if the query failed.
+	resp *dns.Msg
+
+	// err is the error that occurred, if any.
+	err error
+
+	// hostname is the queried domain name.
+	hostname string
+
+	// latency is the time it took to receive the response.
+	latency time.Duration
+
+	// workerID is the index of the connection that sent the query.
+	workerID int
+
+	// qtype is the type of the query.
+	qtype uint16
+}
+
+// csvQueryLog writes the outcome of every query to a CSV file.  It is safe for
+// concurrent use.
+type csvQueryLog struct {
+	// file is the underlying CSV file.
+	file *os.File
+
+	// w writes the records to file.
+	w *csv.Writer
+
+	// mu protects w and closed.
+	mu sync.Mutex
+
+	// closed is true if the log has been closed.
+	closed bool
+}
+
+// newCSVQueryLog creates the CSV file at path and writes the header to it.
+func newCSVQueryLog(path string) (l *csvQueryLog, err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	l = &csvQueryLog{
+		file: f,
+		w:    csv.NewWriter(f),
+	}
+
+	err = l.w.Write([]string{
+		"timestamp",
+		"worker_id",
+		"query_name",
+		"qtype",
+		"rcode",
+		"latency_ms",
+		"error",
+	})
+	if err != nil {
+		_ = f.Close()
+
+		return nil, fmt.Errorf("writing header: %w", err)
+	}
+
+	return l, nil
+}
+
+// write writes a single query record.  Records written after the log has been
+// closed are ignored.
+func (l *csvQueryLog) write(rec *queryRecord) {
+	rcode, errStr := "", ""
+	if rec.resp != nil {
+		rcode = rcodeToString(rec.resp.Rcode)
+	}
+
+	if rec.err != nil {
+		errStr = rec.err.Error()
+	}
+
+	row := []string{
+		rec.time.UTC().Format(time.RFC3339Nano),
+		strconv.Itoa(rec.workerID),
+		rec.hostname,
+		dns.Type(rec.qtype).String(),
+		rcode,
+		strconv.FormatFloat(milliseconds(rec.latency), 'f', 3, 64),
+		errStr,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	// The error is returned by Flush or Close, so ignore it here.
+	_ = l.w.Write(row)
+}
+
+// Close implements the [io.Closer] interface for *csvQueryLog.
+func (l *csvQueryLog) Close() (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+
+	l.closed = true
+	l.w.Flush()
+	err = l.w.Error()
+	if err != nil {
+		_ = l.file.Close()
+
+		return fmt.Errorf("flushing: %w", err)
+	}
+
+	return l.file.Close()
+}
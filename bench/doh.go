@@ -0,0 +1,246 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+)
+
+// dohMIMEType is the MIME type of the DNS-over-HTTPS messages (RFC 8484).
+const dohMIMEType = "application/dns-message"
+
+// isDoHAddress returns true if addr is an address of a DNS-over-HTTPS server.
+func isDoHAddress(addr string) (ok bool) {
+	return strings.HasPrefix(addr, "https://") || strings.HasPrefix(addr, "h3://")
+}
+
+// dohUpstream is a DNS-over-HTTPS client that allows choosing the HTTP method
+// and version.  The dnsproxy upstream only uses GET and always allows HTTP/2.
+type dohUpstream struct {
+	// client is the HTTP client to send the requests with.
+	client *http.Client
+
+	// url is the URL of the server with the "https" scheme.
+	url *url.URL
+
+	// method is the HTTP method of the requests, either GET or POST.
+	method string
+
+	// closeTransport closes the connections of the client.
+	closeTransport func()
+}
+
+// type check
+var (
+	_ upstream.Upstream = (*dohUpstream)(nil)
+	_ contextExchanger  = (*dohUpstream)(nil)
+)
+
+// newDoHUpstream creates a new *dohUpstream for a DNS-over-HTTPS address.
+// method is either GET or POST.  httpVersion is the value of --http-version, an
+// empty string means that HTTP/2 or HTTP/1.1 is negotiated.  connectTimeout
+// bounds establishing a connection including the TLS handshake, it's added to
+// timeout since the request may need a new connection, zero means no separate
+// timeout.  tlsConf is cloned and its server name is set to the hostname from
+// addr.  ipVersion is the value of --ip-version.  resolver resolves the
+// hostname, nil means the system one.
+func newDoHUpstream(
+	addr string,
+	method string,
+	timeout time.Duration,
+	connectTimeout time.Duration,
+	tlsConf *tls.Config,
+	httpVersion string,
+	ipVersion string,
+	resolver *net.Resolver,
+) (u *dohUpstream, err error) {
+	reqURL, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing address: %w", err)
+	}
+
+	if reqURL.Scheme == "h3" {
+		reqURL.Scheme = "https"
+		httpVersion = "3"
+	}
+
+	tlsConf = tlsConf.Clone()
+	tlsConf.ServerName = reqURL.Hostname()
+
+	u = &dohUpstream{
+		url:    reqURL,
+		method: method,
+	}
+
+	var transport http.RoundTripper
+	switch httpVersion {
+	case "1.1":
+		t := &http.Transport{
+			DialContext:         newDialContext(ipVersion, connectTimeout, resolver),
+			TLSClientConfig:     tlsConf,
+			TLSHandshakeTimeout: connectTimeout,
+			// A non-nil empty map disables HTTP/2.
+			TLSNextProto: map[string]func(string, *tls.Conn) http.RoundTripper{},
+		}
+		transport, u.closeTransport = t, t.CloseIdleConnections
+	case "2":
+		dialer := &net.Dialer{Timeout: connectTimeout, Resolver: resolver}
+		t := &http2.Transport{
+			TLSClientConfig: tlsConf,
+			DialTLSContext: func(
+				ctx context.Context,
+				network string,
+				addr string,
+				conf *tls.Config,
+			) (conn net.Conn, err error) {
+				tlsDialer := &tls.Dialer{NetDialer: dialer, Config: conf}
+				conn, err = tlsDialer.DialContext(ctx, ipNetwork(network, ipVersion), addr)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %w", errConnect, err)
+				}
+
+				return conn, nil
+			},
+		}
+		transport, u.closeTransport = t, t.CloseIdleConnections
+	case "3":
+		t := &http3.RoundTripper{
+			TLSClientConfig: tlsConf,
+			Dial:            newDialQUIC(ipVersion, connectTimeout, resolver),
+		}
+		transport, u.closeTransport = t, func() { _ = t.Close() }
+	default:
+		t := &http.Transport{
+			DialContext:         newDialContext(ipVersion, connectTimeout, resolver),
+			TLSClientConfig:     tlsConf,
+			TLSHandshakeTimeout: connectTimeout,
+			ForceAttemptHTTP2:   true,
+		}
+		transport, u.closeTransport = t, t.CloseIdleConnections
+	}
+
+	u.client = &http.Client{
+		Transport: transport,
+		Timeout:   timeout + connectTimeout,
+	}
+
+	return u, nil
+}
+
+// Exchange implements the [upstream.Upstream] interface for *dohUpstream.
+func (u *dohUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	return u.ExchangeContext(context.Background(), req)
+}
+
+// ExchangeContext implements the [contextExchanger] interface for
+// *dohUpstream.
+func (u *dohUpstream) ExchangeContext(
+	ctx context.Context,
+	req *dns.Msg,
+) (resp *dns.Msg, err error) {
+	id := req.Id
+	if u.method == http.MethodGet {
+		// Use zero ID for GET requests to make them cacheable as RFC 8484
+		// recommends.
+		req = req.Copy()
+		req.Id = 0
+	}
+
+	b, err := req.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("packing query: %w", err)
+	}
+
+	httpReq, err := u.newRequest(ctx, b)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	httpResp, err := u.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", httpResp.StatusCode)
+	}
+
+	resp = &dns.Msg{}
+	err = resp.Unpack(body)
+	if err != nil {
+		return nil, fmt.Errorf("unpacking response: %w", err)
+	}
+
+	if resp.Id != req.Id {
+		return nil, dns.ErrId
+	}
+
+	resp.Id = id
+
+	return resp, nil
+}
+
+// newRequest creates an HTTP request with the packed DNS query b.
+func (u *dohUpstream) newRequest(
+	ctx context.Context,
+	b []byte,
+) (httpReq *http.Request, err error) {
+	if u.method == http.MethodPost {
+		httpReq, err = http.NewRequestWithContext(
+			ctx,
+			http.MethodPost,
+			u.url.String(),
+			bytes.NewReader(b),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		httpReq.Header.Set("Content-Type", dohMIMEType)
+	} else {
+		reqURL := *u.url
+		q := reqURL.Query()
+		q.Set("dns", base64.RawURLEncoding.EncodeToString(b))
+		reqURL.RawQuery = q.Encode()
+
+		httpReq, err = http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	httpReq.Header.Set("Accept", dohMIMEType)
+
+	return httpReq, nil
+}
+
+// Address implements the [upstream.Upstream] interface for *dohUpstream.
+func (u *dohUpstream) Address() (addr string) {
+	return u.url.String()
+}
+
+// Close implements the [upstream.Upstream] interface for *dohUpstream.
+func (u *dohUpstream) Close() (err error) {
+	u.closeTransport()
+
+	return nil
+}
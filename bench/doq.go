@@ -0,0 +1,277 @@
+package bench
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// defaultPortDoQ is the default port of the DNS-over-QUIC servers.
+const defaultPortDoQ = "853"
+
+// nextProtoDoQ is the ALPN token of DNS-over-QUIC, see RFC 9250.
+const nextProtoDoQ = "doq"
+
+// isDoQAddress returns true if addr is an address of a DNS-over-QUIC server.
+func isDoQAddress(addr string) (ok bool) {
+	return strings.HasPrefix(addr, "quic://")
+}
+
+// doqUpstream is a DNS-over-QUIC client with a single connection that sends the
+// first query of every new connection in the 0-RTT data when it has a session
+// to resume.  Unlike the dnsproxy upstream, it shares the session cache between
+// the connections and tells whether the queries have used 0-RTT.
+type doqUpstream struct {
+	// conn is the connection to the server.  It's created on the first
+	// exchange and re-created after errors.
+	conn quic.EarlyConnection
+
+	// dial dials the QUIC connections.
+	dial func(
+		ctx context.Context,
+		addr string,
+		tlsConf *tls.Config,
+		conf *quic.Config,
+	) (conn quic.EarlyConnection, err error)
+
+	// count0RTT is called for every query that got a response.  zeroRTT is
+	// true if the query was sent in the 0-RTT data accepted by the server,
+	// rejected is true if the server rejected the 0-RTT data and the query was
+	// sent once again after the handshake.
+	count0RTT func(zeroRTT, rejected bool)
+
+	// tlsConf is the TLS configuration of the connection.
+	tlsConf *tls.Config
+
+	// quicConf is the QUIC configuration of the connection.
+	quicConf *quic.Config
+
+	// addr is the server address in the host:port form.
+	addr string
+
+	// timeout is the query timeout.
+	timeout time.Duration
+
+	// connectTimeout is the timeout of establishing a connection.
+	connectTimeout time.Duration
+}
+
+// type check
+var (
+	_ upstream.Upstream = (*doqUpstream)(nil)
+	_ contextExchanger  = (*doqUpstream)(nil)
+)
+
+// newDoQUpstream creates a new *doqUpstream for a DNS-over-QUIC address.
+// connectTimeout of zero means that timeout is used.  tlsConf is cloned and its
+// server name is set to the hostname from addr.  tokens is the QUIC token store
+// shared between the connections.  count0RTT counts the queries that got a
+// response, see [doqUpstream.count0RTT].  ipVersion is the value of --ip-version.
+// resolver resolves the hostname, nil means the system one.
+func newDoQUpstream(
+	addr string,
+	timeout time.Duration,
+	connectTimeout time.Duration,
+	tlsConf *tls.Config,
+	tokens quic.TokenStore,
+	count0RTT func(zeroRTT, rejected bool),
+	ipVersion string,
+	resolver *net.Resolver,
+) (u *doqUpstream, err error) {
+	addrURL, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing address: %w", err)
+	}
+
+	port := addrURL.Port()
+	if port == "" {
+		port = defaultPortDoQ
+	}
+
+	tlsConf = tlsConf.Clone()
+	tlsConf.ServerName = addrURL.Hostname()
+	tlsConf.NextProtos = []string{nextProtoDoQ}
+
+	return &doqUpstream{
+		dial:           newDialQUIC(ipVersion, connectTimeout, resolver),
+		count0RTT:      count0RTT,
+		tlsConf:        tlsConf,
+		quicConf:       &quic.Config{TokenStore: tokens},
+		addr:           net.JoinHostPort(addrURL.Hostname(), port),
+		timeout:        timeout,
+		connectTimeout: cmp.Or(connectTimeout, timeout),
+	}, nil
+}
+
+// Exchange implements the [upstream.Upstream] interface for *doqUpstream.
+func (u *doqUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	return u.ExchangeContext(context.Background(), req)
+}
+
+// ExchangeContext implements the [contextExchanger] interface for
+// *doqUpstream.
+func (u *doqUpstream) ExchangeContext(
+	ctx context.Context,
+	req *dns.Msg,
+) (resp *dns.Msg, err error) {
+	if u.conn == nil {
+		dialCtx, cancel := context.WithTimeout(ctx, u.connectTimeout)
+		u.conn, err = u.dial(dialCtx, u.addr, u.tlsConf, u.quicConf)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s: %w", u.addr, err)
+		}
+	}
+
+	defer func() {
+		if err != nil {
+			// The connection is likely broken, so establish a new one on the
+			// next exchange.
+			_ = u.Close()
+		}
+	}()
+
+	// The queries sent before the handshake is complete go in the 0-RTT data.
+	early := !isHandshakeComplete(u.conn)
+
+	resp, err = exchangeQUIC(ctx, u.conn, req, u.timeout)
+	if early && errors.Is(err, quic.Err0RTTRejected) {
+		var conn quic.Connection
+		conn, err = u.conn.NextConnection(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("completing handshake: %w", err)
+		}
+
+		// The streams of the rejected connection can't be used anymore, so
+		// send the next queries over the one that has completed the handshake.
+		// It's always the same connection in quic-go, so it's an
+		// EarlyConnection as well.
+		next, ok := conn.(quic.EarlyConnection)
+		if !ok {
+			return nil, fmt.Errorf("unexpected connection type %T", conn)
+		}
+
+		u.conn = next
+
+		resp, err = exchangeQUIC(ctx, u.conn, req, u.timeout)
+		if err == nil {
+			u.count0RTT(false, true)
+		}
+
+		return resp, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	if early {
+		// Used0RTT is only known once the handshake is complete, which should
+		// be the case right after the response is received.
+		select {
+		case <-u.conn.HandshakeComplete():
+		case <-ctx.Done():
+		}
+	}
+
+	u.count0RTT(early && u.conn.ConnectionState().Used0RTT, false)
+
+	return resp, nil
+}
+
+// isHandshakeComplete returns true if the handshake of conn is complete.
+func isHandshakeComplete(conn quic.EarlyConnection) (ok bool) {
+	select {
+	case <-conn.HandshakeComplete():
+		return true
+	default:
+		return false
+	}
+}
+
+// exchangeQUIC sends req over a new stream of conn and reads the response.  The
+// exchange is abandoned after timeout or when ctx is done.
+func exchangeQUIC(
+	ctx context.Context,
+	conn quic.Connection,
+	req *dns.Msg,
+	timeout time.Duration,
+) (resp *dns.Msg, err error) {
+	deadline := earliestDeadline(ctx, time.Now().Add(timeout))
+	streamCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	stream, err := conn.OpenStreamSync(streamCtx)
+	if err != nil {
+		return nil, fmt.Errorf("opening stream: %w", err)
+	}
+
+	_ = stream.SetDeadline(deadline)
+
+	// The message ID must be zero in DNS-over-QUIC, see RFC 9250.
+	id := req.Id
+	req.Id = 0
+	buf, err := req.Pack()
+	req.Id = id
+	if err != nil {
+		return nil, fmt.Errorf("packing query: %w", err)
+	}
+
+	_, err = stream.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(buf))), buf...))
+	if err != nil {
+		return nil, fmt.Errorf("writing query: %w", err)
+	}
+
+	// Close the write direction of the stream to tell the server that the
+	// query is complete.
+	_ = stream.Close()
+
+	var length [2]byte
+	_, err = io.ReadFull(stream, length[:])
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	buf = make([]byte, binary.BigEndian.Uint16(length[:]))
+	_, err = io.ReadFull(stream, buf)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	resp = &dns.Msg{}
+	err = resp.Unpack(buf)
+	if err != nil {
+		return nil, fmt.Errorf("unpacking response: %w", err)
+	}
+
+	resp.Id = id
+
+	return resp, nil
+}
+
+// Address implements the [upstream.Upstream] interface for *doqUpstream.
+func (u *doqUpstream) Address() (addr string) {
+	return "quic://" + u.addr
+}
+
+// Close implements the [upstream.Upstream] interface for *doqUpstream.
+func (u *doqUpstream) Close() (err error) {
+	if u.conn == nil {
+		return nil
+	}
+
+	err = u.conn.CloseWithError(0, "")
+	u.conn = nil
+
+	return err
+}
@@ -0,0 +1,148 @@
+package bench
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startDoQServer starts a DNS-over-QUIC server accepting 0-RTT that answers
+// every query with an empty response.  Starting from the second connection, it
+// uses other session ticket keys, so the client can't resume the session of
+// the first one and its 0-RTT data is rejected.  conns is the number of the
+// connections accepted.
+func startDoQServer(t *testing.T) (addr string, conns *atomic.Int32) {
+	t.Helper()
+
+	tlsConf, _ := createServerTLSConfig(t, "example.org")
+	tlsConf.NextProtos = []string{nextProtoDoQ}
+
+	conns = &atomic.Int32{}
+	rotated := tlsConf.Clone()
+	rotated.SetSessionTicketKeys([][32]byte{{1}})
+	tlsConf.SetSessionTicketKeys([][32]byte{{2}})
+	tlsConf.GetConfigForClient = func(_ *tls.ClientHelloInfo) (conf *tls.Config, err error) {
+		if conns.Add(1) > 1 {
+			return rotated, nil
+		}
+
+		return nil, nil
+	}
+
+	l, err := quic.ListenAddrEarly("127.0.0.1:0", tlsConf, &quic.Config{Allow0RTT: true})
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, l.Close)
+
+	go func() {
+		for {
+			conn, aErr := l.Accept(context.Background())
+			if aErr != nil {
+				return
+			}
+
+			go serveDoQConn(conn)
+		}
+	}()
+
+	return "quic://" + l.Addr().String(), conns
+}
+
+// serveDoQConn answers the queries sent over the streams of conn until it's
+// closed.
+func serveDoQConn(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer func() { _ = stream.Close() }()
+
+			var length [2]byte
+			_, rErr := io.ReadFull(stream, length[:])
+			if rErr != nil {
+				return
+			}
+
+			buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+			_, rErr = io.ReadFull(stream, buf)
+			if rErr != nil {
+				return
+			}
+
+			req := &dns.Msg{}
+			if req.Unpack(buf) != nil {
+				return
+			}
+
+			resp := &dns.Msg{}
+			resp.SetReply(req)
+			buf, _ = resp.Pack()
+			_, _ = stream.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(buf))), buf...))
+		}()
+	}
+}
+
+func TestDoQUpstream_rejected0RTT(t *testing.T) {
+	addr, conns := startDoQServer(t)
+
+	var mu sync.Mutex
+	var zeroRTT, rejected, total int
+	count := func(z, r bool) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		total++
+		if z {
+			zeroRTT++
+		}
+		if r {
+			rejected++
+		}
+	}
+
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+
+	u, err := newDoQUpstream(addr, 5*time.Second, 0, tlsConf, quic.NewLRUTokenStore(1, 10), count, "", nil)
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, u.Close)
+
+	req := (&dns.Msg{}).SetQuestion("example.org.", dns.TypeA)
+
+	// Get the session ticket over the first connection.
+	_, err = u.ExchangeContext(context.Background(), req)
+	require.NoError(t, err)
+	require.NoError(t, u.Close())
+
+	// The 0-RTT data of the second connection is rejected, and the queries
+	// that follow must use the connection that has completed the handshake.
+	for range 3 {
+		_, err = u.ExchangeContext(context.Background(), req)
+		require.NoError(t, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, 4, total)
+	assert.Equal(t, 1, rejected)
+	assert.Zero(t, zeroRTT)
+
+	// No connection is re-dialed after the rejection.
+	assert.Equal(t, int32(2), conns.Load())
+}
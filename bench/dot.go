@@ -0,0 +1,174 @@
+package bench
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+)
+
+// defaultPortDoT is the default port of the DNS-over-TLS servers.
+const defaultPortDoT = "853"
+
+// isDoTAddress returns true if addr is an address of a DNS-over-TLS server.
+func isDoTAddress(addr string) (ok bool) {
+	return strings.HasPrefix(addr, "tls://")
+}
+
+// dotUpstream is a DNS-over-TLS client with a single connection that allows
+// controlling the TLS configuration, e.g. the session cache, unlike the dnsproxy
+// upstream.
+type dotUpstream struct {
+	// conn is the connection to the server.  It's created on the first
+	// exchange and re-created after errors.
+	conn *dns.Conn
+
+	// tlsConf is the TLS configuration of the connection.
+	tlsConf *tls.Config
+
+	// addr is the server address in the host:port form.
+	addr string
+
+	// network is the network to dial the connections over, e.g. "tcp4".
+	network string
+
+	// resolver resolves the hostname of the server.  nil means the system
+	// resolver.
+	resolver *net.Resolver
+
+	// timeout is the query timeout.
+	timeout time.Duration
+
+	// connectTimeout is the timeout of establishing a connection including
+	// the TLS handshake.
+	connectTimeout time.Duration
+}
+
+// type check
+var (
+	_ upstream.Upstream = (*dotUpstream)(nil)
+	_ contextExchanger  = (*dotUpstream)(nil)
+)
+
+// newDoTUpstream creates a new *dotUpstream for a DNS-over-TLS address.
+// connectTimeout of zero means that timeout is used.  tlsConf is cloned and its
+// server name is set to the hostname from addr.  ipVersion is the value of
+// --ip-version.  resolver resolves the hostname, nil means the system one.
+func newDoTUpstream(
+	addr string,
+	timeout time.Duration,
+	connectTimeout time.Duration,
+	tlsConf *tls.Config,
+	ipVersion string,
+	resolver *net.Resolver,
+) (u *dotUpstream, err error) {
+	addrURL, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing address: %w", err)
+	}
+
+	port := addrURL.Port()
+	if port == "" {
+		port = defaultPortDoT
+	}
+
+	tlsConf = tlsConf.Clone()
+	tlsConf.ServerName = addrURL.Hostname()
+
+	return &dotUpstream{
+		tlsConf:        tlsConf,
+		addr:           net.JoinHostPort(addrURL.Hostname(), port),
+		network:        ipNetwork("tcp", ipVersion),
+		resolver:       resolver,
+		timeout:        timeout,
+		connectTimeout: cmp.Or(connectTimeout, timeout),
+	}, nil
+}
+
+// Exchange implements the [upstream.Upstream] interface for *dotUpstream.
+func (u *dotUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	return u.ExchangeContext(context.Background(), req)
+}
+
+// ExchangeContext implements the [contextExchanger] interface for
+// *dotUpstream.
+func (u *dotUpstream) ExchangeContext(
+	ctx context.Context,
+	req *dns.Msg,
+) (resp *dns.Msg, err error) {
+	if u.conn == nil {
+		dialer := &tls.Dialer{
+			NetDialer: &net.Dialer{Timeout: u.connectTimeout, Resolver: u.resolver},
+			Config:    u.tlsConf,
+		}
+
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, u.network, u.addr)
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s: %w: %w", u.addr, errConnect, err)
+		}
+
+		u.conn = &dns.Conn{Conn: conn}
+	}
+
+	defer func() {
+		if err != nil {
+			// The connection is likely broken, so establish a new one on the
+			// next exchange.
+			_ = u.Close()
+		}
+	}()
+
+	return exchangeConn(ctx, u.conn, req, u.timeout)
+}
+
+// exchangeConn sends req over the stream connection conn and reads the
+// response.  The exchange is abandoned after timeout or when ctx is done.
+func exchangeConn(
+	ctx context.Context,
+	conn *dns.Conn,
+	req *dns.Msg,
+	timeout time.Duration,
+) (resp *dns.Msg, err error) {
+	_ = conn.SetDeadline(earliestDeadline(ctx, time.Now().Add(timeout)))
+
+	err = conn.WriteMsg(req)
+	if err != nil {
+		return nil, fmt.Errorf("writing query: %w", err)
+	}
+
+	resp, err = conn.ReadMsg()
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+
+	if resp.Id != req.Id {
+		return nil, dns.ErrId
+	}
+
+	return resp, nil
+}
+
+// Address implements the [upstream.Upstream] interface for *dotUpstream.
+func (u *dotUpstream) Address() (addr string) {
+	return "tls://" + u.addr
+}
+
+// Close implements the [upstream.Upstream] interface for *dotUpstream.
+func (u *dotUpstream) Close() (err error) {
+	if u.conn == nil {
+		return nil
+	}
+
+	err = u.conn.Close()
+	u.conn = nil
+
+	return err
+}
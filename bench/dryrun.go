@@ -0,0 +1,46 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// dryRun sends a single query to check the address, the query, and the
+// connectivity without generating any load.  It prints the response and records
+// it in state.  It returns an error if the query has failed.
+func dryRun(ctx context.Context, options *Options, state *runState) (err error) {
+	u := createUpstream(options, state)
+	defer log.OnCloserError(u, log.DEBUG)
+
+	// The first query is always available, since a non-positive queries count
+	// means unlimited.
+	q, _ := state.nextQuery()
+	domainName := expandHostname(options, state.nameParams(state.workerRand(0), 0), q.hostname)
+	if options.Cookies {
+		q.cookie = newCookieJar().cookie()
+	}
+
+	m := newQueryMsg(options, q, domainName)
+
+	log.Info("Sending a single query to %s:\n%s", options.Address, m)
+
+	start := time.Now()
+	resp, err := exchangeTimeout(ctx, u, m, options.queryTimeout())
+	if err == nil {
+		resp, err = retryTruncated(ctx, options, state, m, resp, nil)
+	}
+	elapsed := time.Since(start)
+	if err != nil {
+		return fmt.Errorf("dry run query for %s: %w", domainName, err)
+	}
+
+	log.Info("Received the response in %s:\n%s", elapsed, resp)
+
+	state.addBytes(m, resp)
+	_ = state.incResponse(0, resp, elapsed)
+
+	return nil
+}
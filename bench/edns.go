@@ -0,0 +1,197 @@
+package bench
+
+import (
+	"encoding/hex"
+	"net/netip"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// paddingOptionHeaderLen is the length of the EDNS0 option code and length
+// fields.
+const paddingOptionHeaderLen = 4
+
+// ednsFlagDO is the DNSSEC OK bit of the flags field of the OPT record.
+const ednsFlagDO uint16 = 1 << 15
+
+// ensureOPT returns the OPT record of m adding one if there is none.
+func ensureOPT(m *dns.Msg) (opt *dns.OPT) {
+	opt = m.IsEdns0()
+	if opt == nil {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		opt = m.IsEdns0()
+	}
+
+	return opt
+}
+
+// setEDNSHeader sets the EDNS version and the 16-bit flags field of the OPT
+// record of m to version and flags.  It adds an OPT record to m if there is
+// none.  The DO bit already set in m is kept.
+func setEDNSHeader(m *dns.Msg, version uint8, flags uint16) {
+	opt := ensureOPT(m)
+	opt.SetVersion(version)
+	opt.SetZ(flags)
+
+	if flags&ednsFlagDO != 0 {
+		opt.SetDo()
+	}
+}
+
+// addECS adds an EDNS0 Client Subnet option (RFC 7871) with subnet to m.  It
+// adds an OPT record to m if there is none.  subnet must be valid and masked.
+func addECS(m *dns.Msg, subnet netip.Prefix) {
+	opt := ensureOPT(m)
+
+	family := uint16(1)
+	if subnet.Addr().Is6() {
+		family = 2
+	}
+
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: uint8(subnet.Bits()),
+		Address:       subnet.Addr().AsSlice(),
+	})
+}
+
+// addCookie adds an EDNS0 cookie option (RFC 7873) with cookie, which is the
+// client cookie optionally followed by the server cookie, to m.  It adds an OPT
+// record to m if there is none.
+func addCookie(m *dns.Msg, cookie []byte) {
+	opt := ensureOPT(m)
+
+	opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{
+		Code:   dns.EDNS0COOKIE,
+		Cookie: hex.EncodeToString(cookie),
+	})
+}
+
+// responseCookie returns the client and server cookies from the EDNS0 cookie
+// option of resp and true if resp has a valid one.
+func responseCookie(resp *dns.Msg) (client, server []byte, ok bool) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return nil, nil, false
+	}
+
+	for _, o := range opt.Option {
+		c, isCookie := o.(*dns.EDNS0_COOKIE)
+		if !isCookie {
+			continue
+		}
+
+		b, err := hex.DecodeString(c.Cookie)
+		if err != nil || len(b) < clientCookieLen {
+			return nil, nil, false
+		}
+
+		return b[:clientCookieLen], b[clientCookieLen:], true
+	}
+
+	return nil, nil, false
+}
+
+// addNSID adds an empty EDNS0 NSID option (RFC 5001) to m to request the name
+// server identifier.  It adds an OPT record to m if there is none.
+func addNSID(m *dns.Msg) {
+	opt := ensureOPT(m)
+
+	opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+}
+
+// responseNSID returns the name server identifier from the EDNS0 NSID option of
+// resp or an empty string if there is none.  The identifier is returned as is
+// if it's printable and hex-encoded otherwise.
+func responseNSID(resp *dns.Msg) (nsid string) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+
+	for _, o := range opt.Option {
+		n, isNSID := o.(*dns.EDNS0_NSID)
+		if !isNSID {
+			continue
+		}
+
+		b, err := hex.DecodeString(n.Nsid)
+		if err != nil {
+			return n.Nsid
+		}
+
+		for _, c := range b {
+			if c < ' ' || c > '~' {
+				return n.Nsid
+			}
+		}
+
+		return string(b)
+	}
+
+	return ""
+}
+
+// tcpKeepaliveUnit is the unit of the timeout in the EDNS0 TCP keepalive
+// option.
+const tcpKeepaliveUnit = 100 * time.Millisecond
+
+// addTCPKeepalive adds an EDNS0 TCP keepalive option (RFC 7828) with timeout to
+// m.  The option has no timeout if it's zero.  It adds an OPT record to m if
+// there is none.
+func addTCPKeepalive(m *dns.Msg, timeout time.Duration) {
+	opt := ensureOPT(m)
+
+	opt.Option = append(opt.Option, &dns.EDNS0_TCP_KEEPALIVE{
+		Code:    dns.EDNS0TCPKEEPALIVE,
+		Timeout: uint16(timeout / tcpKeepaliveUnit),
+	})
+}
+
+// responseTCPKeepalive returns the idle timeout from the EDNS0 TCP keepalive
+// option of resp and true if resp has the option.
+func responseTCPKeepalive(resp *dns.Msg) (timeout time.Duration, ok bool) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return 0, false
+	}
+
+	for _, o := range opt.Option {
+		if k, isKeepalive := o.(*dns.EDNS0_TCP_KEEPALIVE); isKeepalive {
+			return time.Duration(k.Timeout) * tcpKeepaliveUnit, true
+		}
+	}
+
+	return 0, false
+}
+
+// padMsg adds an EDNS0 padding option (RFC 7830) to m so that its wire length
+// is a multiple of blockSize.  It adds an OPT record to m if there is none.
+// It must be called after all other options are added.
+func padMsg(m *dns.Msg, blockSize int) {
+	opt := ensureOPT(m)
+
+	msgLen := m.Len() + paddingOptionHeaderLen
+	padLen := (blockSize - msgLen%blockSize) % blockSize
+
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, padLen)})
+}
+
+// responsePadding returns the length of the EDNS0 padding in resp and true if
+// resp has the padding option.
+func responsePadding(resp *dns.Msg) (padLen int, ok bool) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return 0, false
+	}
+
+	for _, o := range opt.Option {
+		if p, isPadding := o.(*dns.EDNS0_PADDING); isPadding {
+			return len(p.Padding), true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,117 @@
+package bench
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPadMsg(t *testing.T) {
+	testCases := []struct {
+		name      string
+		hostname  string
+		blockSize int
+	}{{
+		name:      "short",
+		hostname:  "example.org",
+		blockSize: 128,
+	}, {
+		name:      "long",
+		hostname:  "very-long-subdomain-name-for-padding.example.org",
+		blockSize: 64,
+	}, {
+		name:      "small_block",
+		hostname:  "example.org",
+		blockSize: 7,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &dns.Msg{}
+			m.SetQuestion(dns.Fqdn(tc.hostname), dns.TypeA)
+
+			padMsg(m, tc.blockSize)
+
+			b, err := m.Pack()
+			require.NoError(t, err)
+			assert.Zero(t, len(b)%tc.blockSize)
+
+			_, ok := responsePadding(m)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestAddECS(t *testing.T) {
+	testCases := []struct {
+		name       string
+		subnet     netip.Prefix
+		wantFamily uint16
+	}{{
+		name:       "ipv4",
+		subnet:     netip.MustParsePrefix("1.2.3.0/24"),
+		wantFamily: 1,
+	}, {
+		name:       "ipv6",
+		subnet:     netip.MustParsePrefix("2001:db8::/56"),
+		wantFamily: 2,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &dns.Msg{}
+			m.SetQuestion("example.org.", dns.TypeA)
+
+			addECS(m, tc.subnet)
+			padMsg(m, 128)
+
+			b, err := m.Pack()
+			require.NoError(t, err)
+
+			unpacked := &dns.Msg{}
+			require.NoError(t, unpacked.Unpack(b))
+
+			opt := unpacked.IsEdns0()
+			require.NotNil(t, opt)
+			require.Len(t, opt.Option, 2)
+
+			subnet, ok := opt.Option[0].(*dns.EDNS0_SUBNET)
+			require.True(t, ok)
+			assert.Equal(t, tc.wantFamily, subnet.Family)
+			assert.Equal(t, uint8(tc.subnet.Bits()), subnet.SourceNetmask)
+
+			addr, ok := netip.AddrFromSlice(subnet.Address)
+			require.True(t, ok)
+			assert.Equal(t, tc.subnet.Addr(), addr.Unmap())
+		})
+	}
+}
+
+func TestSetEDNSHeader(t *testing.T) {
+	m := &dns.Msg{}
+	m.SetQuestion("example.org.", dns.TypeA)
+	m.SetEdns0(1232, true)
+
+	setEDNSHeader(m, 1, 0x4000)
+
+	opt := m.IsEdns0()
+	require.NotNil(t, opt)
+	assert.Equal(t, uint8(1), opt.Version())
+	assert.Equal(t, uint16(0x4000), opt.Z())
+	assert.True(t, opt.Do())
+	assert.Equal(t, uint16(1232), opt.UDPSize())
+
+	m = &dns.Msg{}
+	m.SetQuestion("example.org.", dns.TypeA)
+
+	setEDNSHeader(m, 0, ednsFlagDO)
+
+	opt = m.IsEdns0()
+	require.NotNil(t, opt)
+	assert.Zero(t, opt.Version())
+	assert.Zero(t, opt.Z())
+	assert.True(t, opt.Do())
+}
@@ -0,0 +1,118 @@
+package bench
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// errConnect is wrapped by the errors of establishing a connection to the
+// server, including the TLS handshake.
+const errConnect errors.Error = "connection failed"
+
+// errMismatchedResponse is wrapped by the errors of the responses that don't
+// match the queries.
+const errMismatchedResponse errors.Error = "mismatched response"
+
+// errorCategory is the category of a query error.
+type errorCategory string
+
+// Error categories.
+const (
+	errCategoryTimeout           errorCategory = "timeout"
+	errCategoryConnectTimeout    errorCategory = "connect timeout"
+	errCategoryConnectionRefused errorCategory = "connection refused"
+	errCategoryConnectionReset   errorCategory = "connection reset"
+	errCategoryTLS               errorCategory = "tls"
+	errCategoryMismatched        errorCategory = "mismatched"
+	errCategoryProtocol          errorCategory = "protocol"
+	errCategoryOther             errorCategory = "other"
+)
+
+// errorCategories is the list of all error categories in the order they're
+// printed.
+var errorCategories = []errorCategory{
+	errCategoryTimeout,
+	errCategoryConnectTimeout,
+	errCategoryConnectionRefused,
+	errCategoryConnectionReset,
+	errCategoryTLS,
+	errCategoryMismatched,
+	errCategoryProtocol,
+	errCategoryOther,
+}
+
+// classifyError returns the category of the error returned by the upstream.
+func classifyError(err error) (c errorCategory) {
+	switch {
+	case
+		errors.Is(err, errConnect) && isTimeoutError(err),
+		// The HTTP transport doesn't expose the TLS handshake timeout error.
+		strings.Contains(err.Error(), "TLS handshake timeout"):
+		return errCategoryConnectTimeout
+	case isTimeoutError(err):
+		return errCategoryTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errCategoryConnectionRefused
+	case
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF),
+		errors.Is(err, net.ErrClosed):
+		return errCategoryConnectionReset
+	case isTLSError(err):
+		return errCategoryTLS
+	case errors.Is(err, errMismatchedResponse), errors.Is(err, dns.ErrId):
+		return errCategoryMismatched
+	case isProtocolError(err):
+		return errCategoryProtocol
+	default:
+		return errCategoryOther
+	}
+}
+
+// isTimeoutError returns true if err is caused by a timeout.
+func isTimeoutError(err error) (ok bool) {
+	var netErr net.Error
+
+	return errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isTLSError returns true if err is caused by the TLS handshake or the
+// certificate verification.
+func isTLSError(err error) (ok bool) {
+	var (
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+
+	return errors.As(err, &recordErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr) ||
+		// Some TLS errors are not exported, e.g. the QUIC handshake ones.
+		strings.Contains(err.Error(), "tls: ")
+}
+
+// isProtocolError returns true if err is caused by a malformed DNS response.
+func isProtocolError(err error) (ok bool) {
+	var dnsErr *dns.Error
+
+	return errors.As(err, &dnsErr)
+}
@@ -0,0 +1,68 @@
+package bench
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		name string
+		want errorCategory
+	}{{
+		err:  fmt.Errorf("reading: %w", os.ErrDeadlineExceeded),
+		name: "timeout",
+		want: errCategoryTimeout,
+	}, {
+		err:  fmt.Errorf("dialing: %w: %w", errConnect, os.ErrDeadlineExceeded),
+		name: "connect_timeout",
+		want: errCategoryConnectTimeout,
+	}, {
+		err:  errors.Error("net/http: TLS handshake timeout"),
+		name: "https_handshake_timeout",
+		want: errCategoryConnectTimeout,
+	}, {
+		err:  &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)},
+		name: "refused",
+		want: errCategoryConnectionRefused,
+	}, {
+		err:  &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)},
+		name: "reset",
+		want: errCategoryConnectionReset,
+	}, {
+		err:  fmt.Errorf("handshake: %w", tls.AlertError(40)),
+		name: "tls_alert",
+		want: errCategoryTLS,
+	}, {
+		err:  fmt.Errorf("exchanging: %w", dns.ErrId),
+		name: "mismatched_id",
+		want: errCategoryMismatched,
+	}, {
+		err:  fmt.Errorf("validating: %w", errMismatchedResponse),
+		name: "mismatched_question",
+		want: errCategoryMismatched,
+	}, {
+		err:  fmt.Errorf("reading: %w", dns.ErrShortRead),
+		name: "protocol",
+		want: errCategoryProtocol,
+	}, {
+		err:  errors.Error("something else"),
+		name: "other",
+		want: errCategoryOther,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, classifyError(tc.err))
+		})
+	}
+}
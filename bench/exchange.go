@@ -0,0 +1,108 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+)
+
+// contextExchanger is an upstream that is able to abandon the query when the
+// context is done.
+type contextExchanger interface {
+	// ExchangeContext sends req and returns the response.  It must return an
+	// error once the deadline of ctx is exceeded.
+	ExchangeContext(ctx context.Context, req *dns.Msg) (resp *dns.Msg, err error)
+}
+
+// exchangeTimeout sends req to u and makes sure it returns after timeout or
+// once ctx is cancelled even if the upstream itself hangs.  The upstreams that
+// don't implement contextExchanger are abandoned in a separate goroutine, which
+// finishes once their own transport timeout fires or they're closed.
+func exchangeTimeout(
+	ctx context.Context,
+	u upstream.Upstream,
+	req *dns.Msg,
+	timeout time.Duration,
+) (resp *dns.Msg, err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if ce, ok := u.(contextExchanger); ok {
+		return ce.ExchangeContext(ctx, req)
+	}
+
+	type result struct {
+		resp *dns.Msg
+		err  error
+	}
+
+	// Use a buffered channel so that the goroutine doesn't leak when the
+	// result is abandoned.
+	ch := make(chan result, 1)
+	go func() {
+		r, rErr := u.Exchange(req)
+		ch <- result{resp: r, err: rErr}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("exchanging with %s: %w", u.Address(), ctx.Err())
+	}
+}
+
+// validateResponse returns an error wrapping errMismatchedResponse if resp
+// isn't a response to req, e.g. a spoofed or a misrouted one.  The question
+// section of resp is allowed to be empty or shorter than the one of req, since
+// some servers don't echo it in the error responses.
+func validateResponse(req, resp *dns.Msg) (err error) {
+	if resp.Id != req.Id {
+		return fmt.Errorf("%w: id %d instead of %d", errMismatchedResponse, resp.Id, req.Id)
+	}
+
+	if len(resp.Question) > len(req.Question) {
+		return fmt.Errorf("%w: %d questions", errMismatchedResponse, len(resp.Question))
+	}
+
+	for i, respQ := range resp.Question {
+		reqQ := req.Question[i]
+
+		// Compare the names case-insensitively since the case is randomized
+		// with --randomize-case.
+		if !strings.EqualFold(respQ.Name, reqQ.Name) ||
+			respQ.Qtype != reqQ.Qtype ||
+			respQ.Qclass != reqQ.Qclass {
+			return fmt.Errorf("%w: question %s", errMismatchedResponse, &respQ)
+		}
+	}
+
+	return nil
+}
+
+// isCancelled returns true if ctx is cancelled or its deadline has passed.
+// Unlike ctx.Err(), it doesn't depend on the timer of the context, which may
+// fire after the network deadline set from it.
+func isCancelled(ctx context.Context) (ok bool) {
+	if ctx.Err() != nil {
+		return true
+	}
+
+	deadline, ok := ctx.Deadline()
+
+	return ok && !time.Now().Before(deadline)
+}
+
+// earliestDeadline returns the deadline of ctx if it's earlier than d and d
+// otherwise.
+func earliestDeadline(ctx context.Context, d time.Time) (res time.Time) {
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(d) {
+		return ctxDeadline
+	}
+
+	return d
+}
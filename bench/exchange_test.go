@@ -0,0 +1,40 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateResponse(t *testing.T) {
+	req := &dns.Msg{}
+	req.SetQuestion("example.org.", dns.TypeA)
+
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+	assert.NoError(t, validateResponse(req, resp))
+
+	resp.Question[0].Name = "EXAMPLE.org."
+	assert.NoError(t, validateResponse(req, resp))
+
+	// Some servers don't echo the question in the error responses.
+	resp.Question = nil
+	assert.NoError(t, validateResponse(req, resp))
+
+	resp.SetReply(req)
+	resp.Id++
+	assert.ErrorIs(t, validateResponse(req, resp), errMismatchedResponse)
+
+	resp.SetReply(req)
+	resp.Question[0].Name = "example.net."
+	assert.ErrorIs(t, validateResponse(req, resp), errMismatchedResponse)
+
+	resp.SetReply(req)
+	resp.Question[0].Qtype = dns.TypeAAAA
+	assert.ErrorIs(t, validateResponse(req, resp), errMismatchedResponse)
+
+	resp.SetReply(req)
+	resp.Question = append(resp.Question, resp.Question[0])
+	assert.ErrorIs(t, validateResponse(req, resp), errMismatchedResponse)
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package bench
+
+import "os"
+
+// openFDs returns the number of the file descriptors opened by the process or
+// -1 if it's unknown.
+func openFDs() (n int) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+
+	// Don't count the descriptor of the directory being read.
+	return len(entries) - 1
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package bench
+
+// openFDs is not supported on this platform and always returns -1.
+func openFDs() (n int) {
+	return -1
+}
@@ -0,0 +1,106 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// RunFleet runs the same test against every address from options.AddressFile
+// one after another and returns the results of their tests.  See [Run] for
+// snapshots.
+func RunFleet(ctx context.Context, options *Options, snapshots <-chan os.Signal) (results []*Result, err error) {
+	if options.hasOutputFiles() {
+		return nil, errors.Error("--address-file can't be used with --json-output, --prometheus-output, --jsonl-output, --csv or --timeseries-csv")
+	}
+
+	if options.Address != "" || options.AddressB != "" || options.Split != "" {
+		return nil, errors.Error("--address-file can't be used with --address, --address-b or --split")
+	}
+
+	addrs, err := readHostnames(options.AddressFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading addresses from %s: %w", options.AddressFile, err)
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found in %s", options.AddressFile)
+	}
+
+	// Validate all the addresses before running any test.
+	for i := 0; i < len(addrs) && !options.NoValidateAddress; i++ {
+		err = validateAddress(addrs[i])
+		if err != nil {
+			return nil, fmt.Errorf("server address %s is invalid: %w", addrs[i], err)
+		}
+	}
+
+	// The intermediate results of the tests would be hard to tell apart.
+	fleetOptions := *options
+	fleetOptions.AddressFile = ""
+	fleetOptions.Quiet = true
+
+	// Use the same random names and choices in all tests.
+	if fleetOptions.Seed == 0 {
+		fleetOptions.Seed = time.Now().UnixNano()
+	}
+
+	for i, addr := range addrs {
+		log.Info("Testing %s (%d of %d)", addr, i+1, len(addrs))
+
+		o := fleetOptions
+		o.Address = addr
+		if i > 0 {
+			// The process-wide settings are applied by the first test.
+			o.CPUAffinity = ""
+		}
+
+		var state *runState
+		state, err = run(ctx, &o, snapshots)
+		if err != nil {
+			return nil, fmt.Errorf("testing %s: %w", addr, err)
+		}
+
+		results = append(results, newResult(&o, state))
+
+		if ctx.Err() != nil {
+			// Don't test the rest of the servers after an interruption.
+			break
+		}
+	}
+
+	return results, nil
+}
+
+// PrintFleet logs the summary of the tests against multiple servers, one per
+// line.
+func PrintFleet(results []*Result) {
+	b := &strings.Builder{}
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "Address\tProcessed\tErrors\tQPS\tAverage\tp50\tp90\tp99")
+	for _, res := range results {
+		s := res.state
+		processed, errs := s.counts()
+		_, _ = fmt.Fprintf(
+			w,
+			"%s\t%d\t%d\t%.2f\t%s\t%s\t%s\t%s\n",
+			res.Address,
+			processed,
+			errs,
+			s.qpsTotal(),
+			s.latency.average(),
+			s.latency.percentile(50),
+			s.latency.percentile(90),
+			s.latency.percentile(99),
+		)
+	}
+	_ = w.Flush()
+
+	log.Info("The results per server are:\n%s", strings.TrimSuffix(b.String(), "\n"))
+}
@@ -0,0 +1,58 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunFleet(t *testing.T) {
+	const serversNum = 3
+
+	counts := make([]atomic.Int32, serversNum)
+	addrs := make([]string, serversNum)
+	for i := range addrs {
+		addrs[i] = startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+			counts[i].Add(1)
+
+			resp := &dns.Msg{}
+			resp.SetReply(d.Req)
+			d.Res = resp
+
+			return nil
+		})
+	}
+
+	filePath := filepath.Join(t.TempDir(), "addresses.txt")
+	content := "# resolvers\n" + strings.Join(addrs, "\n") + "\n"
+	err := os.WriteFile(filePath, []byte(content), 0o600)
+	require.NoError(t, err)
+
+	o := &Options{
+		AddressFile:        filePath,
+		Connections:        2,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       10,
+		InsecureSkipVerify: true,
+	}
+
+	results, err := RunFleet(context.Background(), o, nil)
+	require.NoError(t, err)
+	require.Len(t, results, serversNum)
+
+	for i, res := range results {
+		require.Equal(t, addrs[i], res.Address)
+		require.Equal(t, o.QueriesCount, res.Processed)
+		require.Equal(t, int32(o.QueriesCount), counts[i].Load())
+	}
+
+	PrintFleet(results)
+}
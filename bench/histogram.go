@@ -0,0 +1,66 @@
+package bench
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/stringutil"
+)
+
+// histogramWidth is the width of the longest bar of the latency histogram.
+const histogramWidth = 40
+
+// parseLatencyBuckets parses a comma-separated list of the upper bounds of the
+// latency histogram buckets in milliseconds, e.g. "1,2.5,10".  The result is
+// sorted and doesn't contain duplicates.
+func parseLatencyBuckets(s string) (bounds []time.Duration, err error) {
+	for _, str := range stringutil.SplitTrimmed(s, ",") {
+		var ms float64
+		ms, err = strconv.ParseFloat(str, 64)
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("invalid bucket bound %q", str)
+		}
+
+		bounds = append(bounds, time.Duration(ms*float64(time.Millisecond)))
+	}
+
+	if len(bounds) == 0 {
+		return nil, fmt.Errorf("empty list of buckets %q", s)
+	}
+
+	slices.Sort(bounds)
+
+	return slices.Compact(bounds), nil
+}
+
+// formatHistogram returns a human-readable ASCII histogram of the latencies
+// with the bucket bounds and the counts returned by [latencyStats.histogram].
+func formatHistogram(bounds []time.Duration, counts []int) (s string) {
+	labels := make([]string, 0, len(counts))
+	for i, b := range bounds {
+		if i == 0 {
+			labels = append(labels, fmt.Sprintf("<= %s", b))
+		} else {
+			labels = append(labels, fmt.Sprintf("%s - %s", bounds[i-1], b))
+		}
+	}
+	labels = append(labels, fmt.Sprintf("> %s", bounds[len(bounds)-1]))
+
+	labelWidth := 0
+	for _, l := range labels {
+		labelWidth = max(labelWidth, len(l))
+	}
+
+	maxCount := max(slices.Max(counts), 1)
+
+	b := &strings.Builder{}
+	for i, n := range counts {
+		bar := strings.Repeat("#", n*histogramWidth/maxCount)
+		_, _ = fmt.Fprintf(b, "%-*s | %-*s %d\n", labelWidth, labels[i], histogramWidth, bar, n)
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,45 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLatencyBuckets(t *testing.T) {
+	bounds, err := parseLatencyBuckets("10, 0.5,1,10")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{
+		500 * time.Microsecond,
+		time.Millisecond,
+		10 * time.Millisecond,
+	}, bounds)
+
+	_, err = parseLatencyBuckets("1,-2")
+	assert.Error(t, err)
+
+	_, err = parseLatencyBuckets("1,foo")
+	assert.Error(t, err)
+
+	_, err = parseLatencyBuckets(" , ")
+	assert.Error(t, err)
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	l := &latencyStats{}
+	for _, ms := range []int{1, 2, 3, 5, 6, 100} {
+		l.add(time.Duration(ms) * time.Millisecond)
+	}
+
+	bounds := []time.Duration{2 * time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond}
+	counts := l.histogram(bounds)
+	assert.Equal(t, []int{2, 2, 1, 1}, counts)
+
+	lines := strings.Split(formatHistogram(bounds, counts), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "<= 2ms     | "+strings.Repeat("#", histogramWidth)+" 2", lines[0])
+	assert.True(t, strings.HasPrefix(lines[3], "> 10ms     | "+strings.Repeat("#", histogramWidth/2)+" "))
+}
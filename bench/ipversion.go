@@ -0,0 +1,161 @@
+package bench
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/quic-go/quic-go"
+)
+
+// Values of the --ip-version flag.
+const (
+	ipVersionAny = "any"
+	ipVersion4   = "4"
+	ipVersion6   = "6"
+)
+
+// ipNetwork returns network, e.g. "tcp" or "udp", restricted to the IP family
+// of ipVersion.
+func ipNetwork(network, ipVersion string) (res string) {
+	if ipVersion == ipVersion4 || ipVersion == ipVersion6 {
+		return network + ipVersion
+	}
+
+	return network
+}
+
+// familyResolver is an [upstream.Resolver] that only resolves the addresses of
+// a single IP family.
+type familyResolver struct {
+	// resolver resolves the addresses.  nil means the system resolver.
+	resolver *net.Resolver
+
+	// network is either "ip4" or "ip6".
+	network string
+}
+
+// type check
+var _ upstream.Resolver = (*familyResolver)(nil)
+
+// LookupNetIP implements the [upstream.Resolver] interface for
+// *familyResolver.  It ignores network, since it's always the same.
+func (r *familyResolver) LookupNetIP(
+	ctx context.Context,
+	_ string,
+	host string,
+) (addrs []netip.Addr, err error) {
+	return r.resolver.LookupNetIP(ctx, r.network, host)
+}
+
+// newBootstrap returns the bootstrap resolver for the dnsproxy upstreams in
+// accordance with ipVersion using resolver, nil means the system one.  The
+// returned nil means the default resolver of dnsproxy.
+func newBootstrap(ipVersion string, resolver *net.Resolver) (r upstream.Resolver) {
+	if ipVersion == ipVersion4 || ipVersion == ipVersion6 {
+		return &familyResolver{resolver: resolver, network: ipNetwork("ip", ipVersion)}
+	}
+
+	if resolver != nil {
+		return resolver
+	}
+
+	return nil
+}
+
+// newDialContext returns a function dialing the connections of the IP family
+// of ipVersion for the HTTP transports.  timeout of zero means no timeout.
+// resolver resolves the hostnames, nil means the system one.
+func newDialContext(
+	ipVersion string,
+	timeout time.Duration,
+	resolver *net.Resolver,
+) (dial func(ctx context.Context, network, addr string) (conn net.Conn, err error)) {
+	dialer := &net.Dialer{Timeout: timeout, Resolver: resolver}
+
+	return func(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+		conn, err = dialer.DialContext(ctx, ipNetwork(network, ipVersion), addr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errConnect, err)
+		}
+
+		return conn, nil
+	}
+}
+
+// newDialQUIC returns a function dialing the QUIC connections of the IP family
+// of ipVersion for the HTTP/3 transport.  handshakeTimeout of zero means the
+// default handshake timeout of QUIC.  resolver resolves the hostnames, nil
+// means the system one.
+func newDialQUIC(
+	ipVersion string,
+	handshakeTimeout time.Duration,
+	resolver *net.Resolver,
+) (dial func(
+	ctx context.Context,
+	addr string,
+	tlsConf *tls.Config,
+	conf *quic.Config,
+) (conn quic.EarlyConnection, err error)) {
+	return func(
+		ctx context.Context,
+		addr string,
+		tlsConf *tls.Config,
+		conf *quic.Config,
+	) (conn quic.EarlyConnection, err error) {
+		udpAddr, err := resolveUDPAddr(ctx, resolver, ipVersion, addr)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s: %w", addr, err)
+		}
+
+		if handshakeTimeout > 0 {
+			if conf == nil {
+				conf = &quic.Config{}
+			} else {
+				conf = conf.Clone()
+			}
+
+			conf.HandshakeIdleTimeout = handshakeTimeout
+		}
+
+		// Dial the resolved address so that quic-go doesn't resolve it once
+		// again without the family restriction.
+		conn, err = quic.DialAddrEarly(ctx, udpAddr.String(), tlsConf, conf)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errConnect, err)
+		}
+
+		return conn, nil
+	}
+}
+
+// resolveUDPAddr resolves addr in the host:port form to a UDP address of the IP
+// family of ipVersion using resolver, nil means the system one.
+func resolveUDPAddr(
+	ctx context.Context,
+	resolver *net.Resolver,
+	ipVersion string,
+	addr string,
+) (udpAddr *net.UDPAddr, err error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := resolver.LookupPort(ctx, "udp", portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := resolver.LookupNetIP(ctx, ipNetwork("ip", ipVersion), host)
+	if err != nil {
+		return nil, err
+	}
+
+	// The resolver never returns an empty list without an error.
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(ips[0].Unmap(), uint16(port))), nil
+}
@@ -0,0 +1,75 @@
+package bench
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// intermediateRecord is a single line of the JSON lines output with the
+// intermediate results of the test.
+type intermediateRecord struct {
+	// Time is the time of the report.
+	Time time.Time `json:"timestamp"`
+
+	// Elapsed is the time since the start of the test.
+	Elapsed float64 `json:"elapsed_ms"`
+
+	// QPS is the number of queries per second since the previous report.
+	QPS float64 `json:"qps"`
+
+	// Processed is the number of successfully processed queries so far.
+	Processed int `json:"processed"`
+
+	// Errors is the number of failed queries so far.
+	Errors int `json:"errors"`
+}
+
+// jsonlReport writes the intermediate results of the test to a file one JSON
+// object per line, so that it can be tailed during the test.  A nil
+// *jsonlReport is a no-op.
+type jsonlReport struct {
+	// file is the underlying file.  It's not buffered, so every record is
+	// visible to the readers right away.
+	file *os.File
+
+	// enc writes the records to file.
+	enc *json.Encoder
+}
+
+// newJSONLReport creates the file at path for the intermediate results.
+func newJSONLReport(path string) (r *jsonlReport, err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	return &jsonlReport{
+		file: f,
+		enc:  json.NewEncoder(f),
+	}, nil
+}
+
+// write writes a single record.
+func (r *jsonlReport) write(rec *intermediateRecord) {
+	if r == nil {
+		return
+	}
+
+	err := r.enc.Encode(rec)
+	if err != nil {
+		log.Debug("writing intermediate results: %s", err)
+	}
+}
+
+// Close implements the [io.Closer] interface for *jsonlReport.
+func (r *jsonlReport) Close() (err error) {
+	if r == nil {
+		return nil
+	}
+
+	return r.file.Close()
+}
@@ -0,0 +1,219 @@
+package bench
+
+import (
+	"math"
+	"math/bits"
+	"slices"
+	"time"
+)
+
+// latencySubBucketBits is the number of the most significant bits of a latency
+// in nanoseconds that tell apart its bucket within a power of two.  The buckets
+// are 1/64 of their power of two wide, so the latencies are estimated with the
+// relative error of less than 1%.
+const latencySubBucketBits = 6
+
+// latencySubBuckets is the number of buckets per power of two.
+const latencySubBuckets = 1 << latencySubBucketBits
+
+// latencyStats accumulates query latencies and calculates their distribution.
+// It doesn't keep the latencies themselves but counts them in logarithmic
+// buckets, similar to HDR histograms, so the memory it uses doesn't depend on
+// the number of the latencies.  The percentiles and the histogram are estimated
+// from the buckets, while the other statistics are exact.  It is not safe for
+// concurrent use.
+type latencyStats struct {
+	// buckets is the number of the recorded latencies in every bucket starting
+	// from the one with index offset, see [latencyBucket].
+	buckets []int
+
+	// offset is the index of the first element of buckets.
+	offset int
+
+	// n is the number of the recorded latencies.
+	n int
+
+	// total is the sum of all recorded latencies.
+	total time.Duration
+
+	// mean is the running mean of the recorded latencies in nanoseconds.
+	mean float64
+
+	// m2 is the running sum of the squared differences of the recorded
+	// latencies from mean, see Welford's algorithm.
+	m2 float64
+
+	// jitterTotal is the sum of the absolute differences between the
+	// consecutively recorded latencies.
+	jitterTotal time.Duration
+
+	// last is the latency recorded the last.
+	last time.Duration
+
+	// lowest is the minimum recorded latency.
+	lowest time.Duration
+
+	// highest is the maximum recorded latency.
+	highest time.Duration
+}
+
+// add records a single query latency.
+func (l *latencyStats) add(d time.Duration) {
+	d = max(d, 0)
+
+	if l.n > 0 {
+		diff := d - l.last
+		l.jitterTotal += max(diff, -diff)
+		l.lowest, l.highest = min(l.lowest, d), max(l.highest, d)
+	} else {
+		l.lowest, l.highest = d, d
+	}
+
+	l.last = d
+	l.n++
+	l.total += d
+
+	delta := float64(d) - l.mean
+	l.mean += delta / float64(l.n)
+	l.m2 += delta * (float64(d) - l.mean)
+
+	l.addToBucket(latencyBucket(d))
+}
+
+// addToBucket increments the number of the latencies in the bucket with index
+// idx growing buckets if needed.
+func (l *latencyStats) addToBucket(idx int) {
+	switch {
+	case len(l.buckets) == 0:
+		l.buckets, l.offset = make([]int, 1), idx
+	case idx < l.offset:
+		l.buckets = slices.Insert(l.buckets, 0, make([]int, l.offset-idx)...)
+		l.offset = idx
+	case idx >= l.offset+len(l.buckets):
+		l.buckets = append(l.buckets, make([]int, idx-l.offset-len(l.buckets)+1)...)
+	}
+
+	l.buckets[idx-l.offset]++
+}
+
+// latencyBucket returns the index of the bucket of the latency d, which must
+// not be negative.  The latencies below latencySubBuckets nanoseconds have a
+// bucket each, and every next power of two is split into latencySubBuckets
+// buckets of equal width.
+func latencyBucket(d time.Duration) (idx int) {
+	v := uint64(d)
+	if v < latencySubBuckets {
+		return int(v)
+	}
+
+	shift := bits.Len64(v) - 1 - latencySubBucketBits
+
+	return (shift+1)*latencySubBuckets + int(v>>shift) - latencySubBuckets
+}
+
+// latencyBucketRange returns the lowest latency of the bucket with index idx
+// and its width.
+func latencyBucketRange(idx int) (lo, width time.Duration) {
+	if idx < latencySubBuckets {
+		return time.Duration(idx), 1
+	}
+
+	shift := idx/latencySubBuckets - 1
+	sub := idx%latencySubBuckets + latencySubBuckets
+
+	return time.Duration(sub) << shift, 1 << shift
+}
+
+// count returns the number of recorded latencies.
+func (l *latencyStats) count() (n int) {
+	return l.n
+}
+
+// average returns the average latency or zero if nothing has been recorded.
+func (l *latencyStats) average() (d time.Duration) {
+	if l.n == 0 {
+		return 0
+	}
+
+	return l.total / time.Duration(l.n)
+}
+
+// stdDev returns the population standard deviation of the recorded latencies
+// or zero if nothing has been recorded.
+func (l *latencyStats) stdDev() (d time.Duration) {
+	if l.n == 0 {
+		return 0
+	}
+
+	return time.Duration(math.Sqrt(l.m2 / float64(l.n)))
+}
+
+// jitter returns the mean absolute difference between the consecutively
+// recorded latencies or zero if less than two latencies have been recorded.
+func (l *latencyStats) jitter() (d time.Duration) {
+	if l.n < 2 {
+		return 0
+	}
+
+	return l.jitterTotal / time.Duration(l.n-1)
+}
+
+// percentile returns the latency below which p percent of the recorded
+// latencies fall using the nearest-rank method.  p must be in the [0, 100]
+// range.  The latency is the middle of the bucket of the latency with that
+// rank within the recorded minimum and maximum.  It returns zero if nothing
+// has been recorded.
+func (l *latencyStats) percentile(p float64) (d time.Duration) {
+	if l.n == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(l.n)))
+	rank = max(rank, 1)
+	rank = min(rank, l.n)
+
+	for i, n := range l.buckets {
+		rank -= n
+		if rank <= 0 {
+			lo, width := latencyBucketRange(i + l.offset)
+
+			return min(max(lo+width/2, l.lowest), l.highest)
+		}
+	}
+
+	return l.highest
+}
+
+// minimum returns the minimum recorded latency or zero if nothing has been
+// recorded.
+func (l *latencyStats) minimum() (d time.Duration) {
+	return l.lowest
+}
+
+// maximum returns the maximum recorded latency or zero if nothing has been
+// recorded.
+func (l *latencyStats) maximum() (d time.Duration) {
+	return l.highest
+}
+
+// histogram returns the number of recorded latencies in every bucket.  bounds
+// are the sorted upper bounds of the buckets, the i-th bucket contains the
+// latencies greater than bounds[i-1] and less than or equal to bounds[i].  The
+// last element of counts is the number of latencies greater than all bounds.
+// The latencies are counted by the lowest latency of their logarithmic
+// buckets, so the ones less than 1% greater than a bound may be counted in the
+// bucket of that bound.
+func (l *latencyStats) histogram(bounds []time.Duration) (counts []int) {
+	counts = make([]int, len(bounds)+1)
+	for i, n := range l.buckets {
+		if n == 0 {
+			continue
+		}
+
+		lo, _ := latencyBucketRange(i + l.offset)
+		j, _ := slices.BinarySearch(bounds, max(lo, l.lowest))
+		counts[j] += n
+	}
+
+	return counts
+}
@@ -0,0 +1,88 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// latencyEpsilon is the maximum relative error of the latency percentiles.
+const latencyEpsilon = 1.0 / latencySubBuckets
+
+func TestLatencyStats(t *testing.T) {
+	l := &latencyStats{}
+	assert.Zero(t, l.average())
+	assert.Zero(t, l.percentile(50))
+	assert.Zero(t, l.minimum())
+
+	for i := 10; i >= 1; i-- {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+
+	assert.Equal(t, 10, l.count())
+	assert.Equal(t, 5500*time.Microsecond, l.average())
+	assert.InEpsilon(t, 5*time.Millisecond, l.percentile(50), latencyEpsilon)
+	assert.InEpsilon(t, 9*time.Millisecond, l.percentile(90), latencyEpsilon)
+	assert.Equal(t, 10*time.Millisecond, l.percentile(99))
+	assert.Equal(t, 1*time.Millisecond, l.minimum())
+	assert.Equal(t, 10*time.Millisecond, l.maximum())
+	assert.Equal(t, 1*time.Millisecond, l.jitter())
+	assert.InDelta(t, 2872*time.Microsecond, l.stdDev(), float64(time.Microsecond))
+}
+
+func TestLatencyStats_jitter(t *testing.T) {
+	l := &latencyStats{}
+	assert.Zero(t, l.stdDev())
+	assert.Zero(t, l.jitter())
+
+	for _, ms := range []int{10, 20, 10, 20} {
+		l.add(time.Duration(ms) * time.Millisecond)
+	}
+
+	assert.Equal(t, 20*time.Millisecond, l.maximum())
+	assert.Equal(t, 10*time.Millisecond, l.jitter())
+	assert.Equal(t, 5*time.Millisecond, l.stdDev())
+}
+
+func TestLatencyBucket(t *testing.T) {
+	prev := -1
+	for _, d := range []time.Duration{
+		0,
+		1,
+		latencySubBuckets - 1,
+		latencySubBuckets,
+		time.Microsecond,
+		time.Millisecond,
+		1500 * time.Microsecond,
+		time.Second,
+		time.Hour,
+	} {
+		idx := latencyBucket(d)
+		require.Greater(t, idx, prev)
+		prev = idx
+
+		lo, width := latencyBucketRange(idx)
+		assert.LessOrEqual(t, lo, d)
+		assert.Greater(t, lo+width, d)
+		assert.LessOrEqual(t, float64(width), max(float64(d)/latencySubBuckets, 1))
+	}
+}
+
+func TestLatencyStats_percentile(t *testing.T) {
+	l := &latencyStats{}
+	for i := range 100_000 {
+		l.add(time.Duration(i+1) * time.Microsecond)
+	}
+
+	// The latencies themselves aren't kept.
+	assert.Less(t, len(l.buckets), l.count()/50)
+
+	assert.Equal(t, time.Microsecond, l.minimum())
+	assert.Equal(t, 100*time.Millisecond, l.maximum())
+	assert.InEpsilon(t, 50*time.Millisecond, l.percentile(50), latencyEpsilon)
+	assert.InEpsilon(t, 99*time.Millisecond, l.percentile(99), latencyEpsilon)
+	assert.InEpsilon(t, 50*time.Millisecond, l.average(), latencyEpsilon)
+	assert.InEpsilon(t, 28868*time.Microsecond, l.stdDev(), latencyEpsilon)
+}
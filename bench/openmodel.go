@@ -0,0 +1,230 @@
+package bench
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// runOpenModel sends queries at the rate limit until the test is finished
+// without waiting for the previous queries to be answered.  The queries in
+// flight are distributed over options.Connections upstreams, which are shared
+// by them unless every query uses a new connection.  Our own clients with a
+// single connection aren't safe for concurrent use, so every query in flight
+// uses a new one of them.  The latency is measured
+// from the time the query was scheduled to be sent so that a slow server
+// doesn't hide its own delays.  rng is used to generate the random names.  If
+// options.MaxOutstanding queries are in flight, the scheduled queries are
+// skipped so that an unresponsive server doesn't exhaust the memory.  The
+// queries in flight are abandoned once ctx is cancelled.
+func runOpenModel(ctx context.Context, options *Options, state *runState, rng *rand.Rand) {
+	om := newOpenModel(options, state)
+	defer om.close()
+
+	if options.Warmup > 0 {
+		om.warmUp(ctx)
+	}
+
+	names := state.nameParams(rng, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; !state.deadlineExceeded() && !isCancelled(ctx); i++ {
+		q, ok := state.nextQuery()
+		if !ok {
+			break
+		}
+
+		workerID := i % len(om.upstreams)
+
+		names.workerID = workerID
+		domainName := expandHostname(options, names, q.hostname)
+
+		log.Debug("Querying %s", domainName)
+
+		q.cookie = om.jars[workerID].cookie()
+		m := newQueryMsg(options, q, domainName)
+
+		// Take returns the time the query is scheduled for, which is earlier
+		// than now if the dispatcher has fallen behind.
+		start := state.rate.Take()
+
+		if !om.acquire(domainName) {
+			continue
+		}
+
+		state.incInFlight()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			om.exchange(ctx, q, m, domainName, start, workerID)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// openModel is the state of the connections shared by the queries in flight of
+// the open model test, see [runOpenModel].
+type openModel struct {
+	// options are the options of the test.
+	options *Options
+
+	// state is the state of the test.
+	state *runState
+
+	// upstreams are shared by the queries in flight unless perQuery is true.
+	upstreams []upstream.Upstream
+
+	// jars keep the DNS cookies of every upstream, they're nil unless the
+	// cookies are enabled.
+	jars []*cookieJar
+
+	// outstanding limits the number of queries in flight, it's nil if there
+	// is no limit.
+	outstanding chan struct{}
+
+	// perQuery is true if every query in flight uses a new upstream.
+	perQuery bool
+}
+
+// newOpenModel creates a new *openModel with options.Connections upstreams.
+func newOpenModel(options *Options, state *runState) (om *openModel) {
+	om = &openModel{
+		options:   options,
+		state:     state,
+		upstreams: make([]upstream.Upstream, options.Connections),
+		jars:      make([]*cookieJar, options.Connections),
+		// Sharing the upstream would make the queries in flight take each
+		// other's responses.
+		perQuery: options.FreshConnection || !isConcurrentUpstream(options),
+	}
+
+	for i := range om.upstreams {
+		om.upstreams[i] = createUpstream(options, state)
+		if options.Cookies {
+			om.jars[i] = newCookieJar()
+		}
+	}
+
+	if options.MaxOutstanding > 0 {
+		om.outstanding = make(chan struct{}, options.MaxOutstanding)
+	}
+
+	return om
+}
+
+// close closes the upstreams of om.
+func (om *openModel) close() {
+	for _, u := range om.upstreams {
+		log.OnCloserError(u, log.DEBUG)
+	}
+}
+
+// warmUp sends the warmup queries over every upstream in parallel and waits
+// for all of them to finish.
+func (om *openModel) warmUp(ctx context.Context) {
+	for i, u := range om.upstreams {
+		go func() {
+			names := om.state.nameParams(om.state.workerRand(i), i)
+			om.upstreams[i] = warmupConnection(ctx, om.options, om.state, u, names)
+			om.state.warmupWG.Done()
+		}()
+	}
+
+	<-om.state.warmupFinished
+}
+
+// acquire reserves a place for the query for domainName among the queries in
+// flight.  It returns false if the query should be skipped since there are too
+// many of them.  The place must be released with [openModel.release].
+func (om *openModel) acquire(domainName string) (ok bool) {
+	if om.outstanding == nil {
+		return true
+	}
+
+	select {
+	case om.outstanding <- struct{}{}:
+		return true
+	default:
+		log.Debug("Skipping query %s, too many queries in flight", domainName)
+		om.state.incSkipped()
+
+		return false
+	}
+}
+
+// release frees the place of a query reserved with [openModel.acquire].
+func (om *openModel) release() {
+	if om.outstanding != nil {
+		<-om.outstanding
+	}
+}
+
+// exchange sends the query q for domainName scheduled for start over the
+// upstream with workerID and records its result.
+func (om *openModel) exchange(
+	ctx context.Context,
+	q query,
+	m *dns.Msg,
+	domainName string,
+	start time.Time,
+	workerID int,
+) {
+	options, state := om.options, om.state
+
+	defer state.decInFlight()
+	defer om.release()
+
+	u, jar := om.upstreams[workerID], om.jars[workerID]
+	if om.perQuery {
+		u = createUpstream(options, state)
+		defer log.OnCloserError(u, log.DEBUG)
+	}
+
+	resp, err := exchangeTimeout(ctx, u, m, options.queryTimeout())
+
+	retried := false
+	for attempt := 0; shouldRetry(ctx, err) && attempt < options.Retries; attempt++ {
+		log.Debug("Retrying query %s after error: %v", domainName, err)
+
+		retried = true
+		state.rate.Take()
+		resp, err = exchangeTimeout(ctx, u, m, options.queryTimeout())
+	}
+
+	if retried && err == nil {
+		state.incRetried()
+	}
+
+	if err == nil {
+		resp, err = retryTruncated(ctx, options, state, m, resp, nil)
+	}
+
+	elapsed := time.Since(start)
+
+	if err != nil && isCancelled(ctx) {
+		// The query has been abandoned due to the interruption.
+		log.Debug("Query %s has been cancelled", domainName)
+
+		return
+	}
+
+	if err == nil {
+		err = validateResponse(m, resp)
+	}
+
+	if err == nil {
+		jar.update(resp)
+	}
+
+	// The upstreams are shared by the queries in flight, so don't re-create
+	// them on errors.  The dnsproxy upstreams reconnect by themselves.
+	state.addBytes(m, resp)
+	_ = recordResult(state, q, domainName, resp, err, start, elapsed, workerID)
+}
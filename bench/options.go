@@ -0,0 +1,712 @@
+package bench
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Options are the options of the test.  The struct tags describe their
+// command-line flags.
+type Options struct {
+	// Address of the server you want to bench.
+	Address string `short:"a" long:"address" description:"Address of the DNS server you're trying to test. Note, that for encrypted DNS it should include the protocol (tls://, https://, quic://, h3://), use unix:///path/to/socket for plain DNS over a UNIX socket" optional:"false"`
+
+	// AddressB is the address of the second server to run the same test
+	// against simultaneously.
+	AddressB string `long:"address-b" description:"Address of the second DNS server to run the same test against simultaneously and compare the results with"`
+
+	// AddressFile is the path to the file with the addresses of the DNS
+	// servers to run the same test against one after another.
+	AddressFile string `long:"address-file" description:"Path to the file with the addresses of the DNS servers to run the same test against one after another, one per line. Lines starting with # are ignored"`
+
+	// Split is the comma-separated list of the server addresses with their
+	// weights in the ADDR=WEIGHT form to split the queries between.
+	Split string `long:"split" description:"Comma-separated list of the DNS server addresses with their weights to split the queries of a single test between, e.g. 'tls://dns.adguard-dns.com=70,8.8.8.8=30'. Can't be used with --address"`
+
+	// NoValidateAddress disables the validation of the server addresses
+	// before the test.  If an address turns out to be invalid, every query
+	// to it fails.
+	NoValidateAddress bool `long:"no-validate-address" description:"Don't validate the server addresses before the test in case the validation is too strict, every query to an invalid address fails" optional:"yes" optional-value:"true"`
+
+	// Connections is the number of connections you would like to open
+	// simultaneously.
+	Connections int `short:"p" long:"parallel" description:"The number of connections you would like to open simultaneously" default:"1"`
+
+	// FindMaxQPS makes the program search for the number of connections
+	// giving the highest QPS instead of running a single test.
+	FindMaxQPS bool `long:"find-max-qps" description:"Find the number of connections giving the highest QPS by running the test for --duration (5s by default) starting with --parallel connections and doubling them until the QPS stops improving or the error rate exceeds --fail-over (1% by default)" optional:"yes" optional-value:"true"`
+
+	// Query is the host name you would like to resolve during the bench.
+	Query string `short:"q" long:"query" description:"The host name you would like to resolve. {random} will be replaced with a random string, {seq} with the sequence number of the query, {worker} with the index of the connection, and {timestamp} with the current Unix time. If not set, example.org is queried"`
+
+	// RandomizeCase enables the DNS 0x20 encoding, i.e. random case of every
+	// letter of the queried domain name.
+	RandomizeCase bool `long:"randomize-case" description:"Randomize the case of every letter of the queried domain name (DNS 0x20 encoding)" optional:"yes" optional-value:"true"`
+
+	// UniqueNames is the number of the random labels that replace {random}
+	// in turn.  Zero means that a new random label is used for every query.
+	UniqueNames int `long:"unique-names" description:"Replace {random} with one of this many pre-generated random labels in turn to control the cache hit ratio, e.g. 1 for cache hits only. 0 means a new random label for every query" default:"0"`
+
+	// Seed is the seed of the random number generator used for the random
+	// names, the case randomization, and picking the query types and the
+	// hostnames.  Zero means a seed based on the current time.
+	Seed int64 `long:"seed" description:"Seed of the random number generator to generate the same random names and choices across runs. If 0, a seed based on the current time is used" default:"0"`
+
+	// QType is the type of the DNS queries.
+	QType string `short:"T" long:"qtype" description:"The type of the DNS queries, e.g. A, AAAA, MX, TXT" default:"A"`
+
+	// QTypes is a comma-separated list of query types that are randomly picked
+	// for every query.  It takes precedence over QType.
+	QTypes string `long:"qtypes" description:"Comma-separated list of query types to randomly pick from for every query, e.g. A,AAAA,HTTPS. Overrides --qtype"`
+
+	// QClass is the class of the DNS queries.
+	QClass string `long:"class" description:"The class of the DNS queries, e.g. IN, CH, HS" default:"IN"`
+
+	// QueriesPath is the path to the file with domain names to query.  If any
+	// of the lines has a weight, the domain names are picked randomly
+	// proportionally to their weights.
+	QueriesPath string `short:"f" long:"file" description:"The path to the file with domain names to query, one per line. A line may have a weight to pick the domain names randomly proportionally to, e.g. \"example.org 100\". Lines starting with # are ignored"`
+
+	// ReplayFile is the path to the file with the DNS queries to send as is
+	// instead of synthesizing them.
+	ReplayFile string `long:"replay-file" description:"The path to a pcap file with DNS queries sent over UDP to port 53 or a text file with one hex-encoded DNS message per line to send instead of synthesizing queries. Only the message IDs are changed. The file is sent over again until --count is reached"`
+
+	// Amplify enables the mode when the lines of the queries file are treated
+	// as "hostname count" pairs of the captured traffic and the distribution
+	// of hostnames is scaled to QueriesCount.
+	Amplify bool `long:"amplify" description:"Treat the lines of the queries file as \"hostname count\" and scale the observed distribution to the overall number of queries" optional:"yes" optional-value:"true"`
+
+	// OncePerName makes every name of the queries file queried exactly once
+	// instead of sending QueriesCount queries, and the outcome of every query
+	// reported.
+	OncePerName bool `long:"once-per-name" description:"Query every name of the queries file exactly once instead of --count queries and report the result for every name, e.g. to check that all of them resolve" optional:"yes" optional-value:"true"`
+
+	// Timeout is timeout for a query.
+	Timeout int `short:"t" long:"timeout" description:"Query timeout in seconds" default:"10"`
+
+	// ConnectTimeout is the timeout of establishing a connection including
+	// the TLS handshake.  Zero means that the connections are established
+	// within the query timeout.
+	ConnectTimeout time.Duration `long:"connect-timeout" description:"Timeout of establishing a connection including the TLS handshake, e.g. 2s, so that slow handshakes are counted as connect timeouts rather than query ones (DoT, DoH, and UNIX sockets only). If not set, connections are established within --timeout"`
+
+	// Rate sets the rate limit for queries that are sent to the address.
+	Rate int `short:"r" long:"rate-limit" description:"Rate limit (per second). The queries delayed by slow responses are sent as soon as possible, and their latency is measured from the time they should have been sent to account for the delay. The ones that are more than 1s behind the schedule are skipped rather than sent in a burst" default:"0"`
+
+	// Jitter is the maximum random deviation of the intervals between the
+	// queries from the ones set by Rate, in percent.
+	Jitter float64 `long:"jitter" description:"Randomly deviate every interval between the queries by up to this percentage of the interval set by --rate-limit in either direction, e.g. 20, so that the load is bursty rather than perfectly uniform. The average rate stays the same" default:"0"`
+
+	// OpenModel enables the open load model, i.e. queries are sent at the rate
+	// limit regardless of whether the previous queries have been answered.
+	OpenModel bool `long:"open-model" description:"Send queries at --rate-limit without waiting for the previous ones to be answered. Connections are shared by the queries in flight unless the client for the address is not safe for concurrent use" optional:"yes" optional-value:"true"`
+
+	// MaxOutstanding is the maximum number of queries in flight in the open
+	// model.  The queries scheduled when it's reached are skipped.  Zero
+	// means no limit.
+	MaxOutstanding int `long:"max-outstanding" description:"The maximum number of queries in flight with --open-model. The queries scheduled while it's reached are skipped. If 0, there is no limit" default:"0"`
+
+	// RampDuration is the duration over which the rate limit linearly
+	// increases from RampStartRate to Rate.
+	RampDuration time.Duration `long:"ramp-duration" description:"Linearly increase the rate limit from --ramp-start-rate to --rate-limit over this duration, e.g. 1m"`
+
+	// RampStartRate is the initial rate limit of the ramp-up.
+	RampStartRate int `long:"ramp-start-rate" description:"The initial rate limit (per second) of the ramp-up" default:"1"`
+
+	// QueriesCount is the overall number of queries we should send.  Zero or
+	// a negative value means that the queries are sent until the test is
+	// interrupted or Duration elapses.
+	QueriesCount int `short:"c" long:"count" description:"The overall number of queries we should send. 0 means unlimited until interrupted or --duration elapses" default:"10000"`
+
+	// Warmup is the number of queries every connection sends before the
+	// measurement starts.  These queries don't affect the test results.
+	Warmup int `long:"warmup" description:"The number of queries every connection sends before the measurement starts" default:"0"`
+
+	// WarmupFile is the path to the file with the domain names to query once
+	// before the measurement starts.  These queries don't affect the test
+	// results.
+	WarmupFile string `long:"warmup-file" description:"Path to the file with the domain names to query once before the measurement starts to get them cached by the server, one per line. These queries don't affect the test results. Lines starting with # are ignored"`
+
+	// DryRun makes the test send a single query and print the response
+	// instead of running the benchmark.
+	DryRun bool `long:"dry-run" description:"Send a single query and print the response to check the address, the query, and the connectivity without generating load" optional:"yes" optional-value:"true"`
+
+	// Retries is the number of times a failed query is retried before it's
+	// counted as an error.
+	Retries int `long:"retries" description:"The number of times a failed query is retried over a new connection before counting it as an error" default:"0"`
+
+	// MaxErrors is the number of errors above which the test is aborted.
+	// Zero means no limit.
+	MaxErrors int `long:"max-errors" description:"Abort the test and print the partial results once the number of errors exceeds this threshold. If 0, there is no limit" default:"0"`
+
+	// TLSResumption controls the TLS session resumption.  If empty, every
+	// connection keeps its own session cache.
+	TLSResumption string `long:"tls-resumption" description:"Control the TLS session resumption for DNS-over-TLS and DNS-over-HTTPS: on shares the session cache between all connections, off makes every connection perform a full handshake" choice:"on" choice:"off"`
+
+	// QUIC0RTT enables 0-RTT for DNS-over-QUIC and counts the queries that
+	// have used it.
+	QUIC0RTT bool `long:"quic-0rtt" description:"Enable 0-RTT for DNS-over-QUIC: the connections share the TLS session cache, so the first query of a new connection is sent in the 0-RTT data, and report how many queries used 0-RTT vs 1-RTT. Use with --fresh-connection to measure it for every query" optional:"yes" optional-value:"true"`
+
+	// SharedUpstream makes all connections share a single upstream that
+	// multiplexes their queries.
+	SharedUpstream bool `long:"shared-upstream" description:"Share a single upstream between all --parallel connections, so that their queries are multiplexed over its connections, e.g. for DoH over HTTP/2 or DoQ, to measure how many concurrent queries the server handles per connection" optional:"yes" optional-value:"true"`
+
+	// FreshConnection makes every query use a new connection, so that the
+	// latency includes the cost of establishing it, e.g. the TLS handshake.
+	FreshConnection bool `long:"fresh-connection" description:"Open a new connection for every query to measure the cold connection latency including the handshake" optional:"yes" optional-value:"true"`
+
+	// BackoffMax is the maximum delay before re-creating the upstream after
+	// the consecutive errors of a connection.  The delay doubles after every
+	// error and is reset on success.  Zero disables the backoff.
+	BackoffMax time.Duration `long:"backoff-max" description:"Wait before reconnecting after the consecutive errors of a connection doubling the delay up to this maximum, e.g. 5s. The delay is reset on the first success"`
+
+	// Delay is the pause every connection makes after each query.  Unlike
+	// Rate, it doesn't depend on the number of connections.  If both are set,
+	// the delay is added to the wait for the rate limiter.
+	Delay time.Duration `long:"delay" description:"Pause every connection for this long after each query, e.g. 100ms. Unlike --rate-limit, it doesn't depend on the number of connections, if both are set, the delay applies in addition to the rate limit"`
+
+	// TCPRatio is the fraction of the queries to a plain DNS address that
+	// should be sent over TCP instead of UDP.  The statistics of both
+	// transports are reported separately.
+	TCPRatio float64 `long:"tcp-ratio" description:"Fraction of the queries to a plain DNS address to send over TCP instead of UDP and compare the transports, e.g. 0.3"`
+
+	// Duration is the maximum duration of the test.  If both QueriesCount and
+	// Duration are set, the test stops when either of the limits is reached.
+	Duration time.Duration `short:"d" long:"duration" description:"The duration of the test, e.g. 30s or 5m. The test stops when either this or --count is reached"`
+
+	// MaxRuntime is the wall-clock time after which the test is aborted
+	// regardless of the remaining queries.  Zero means no limit.
+	MaxRuntime time.Duration `long:"max-runtime" description:"Abort the test and print the partial results once it has run for this long, e.g. 10m, regardless of the remaining queries and the queries in flight. It's a safety net for hung servers, use --duration to limit the test normally"`
+
+	// InsecureSkipVerify controls whether godnsbench validates server certificate or
+	// allows connections with servers with self-signed certs.
+	InsecureSkipVerify bool `long:"insecure" description:"Do not validate the server certificate" optional:"yes" optional-value:"true"`
+
+	// DoHMethod is the HTTP method of the DNS-over-HTTPS queries.
+	DoHMethod string `long:"doh-method" description:"The HTTP method of the DNS-over-HTTPS queries: GET or POST" default:"GET"`
+
+	// HTTPVersion is the HTTP version of the DNS-over-HTTPS queries.  If
+	// empty, it's negotiated with the server.
+	HTTPVersion string `long:"http-version" description:"Force the HTTP version of the DNS-over-HTTPS queries: 1.1, 2 or 3"`
+
+	// IPVersion restricts the IP family the hostname of the server address is
+	// resolved to: 4, 6 or any.
+	IPVersion string `long:"ip-version" description:"Resolve the hostname of the server address to IPv4 or IPv6 addresses only" choice:"4" choice:"6" choice:"any" default:"any"`
+
+	// Bootstrap is the comma-separated list of the IP addresses of the plain
+	// DNS servers that resolve the hostname of the server address instead of
+	// the system resolver.
+	Bootstrap string `long:"bootstrap" description:"Comma-separated list of the IP addresses of the plain DNS servers to resolve the hostname of the server address with instead of the system resolver, e.g. 8.8.8.8,1.1.1.1:53"`
+
+	// LocalAddress is the local IP address the outgoing connections should be
+	// bound to.  It's only supported for plain DNS-over-UDP.
+	LocalAddress string `long:"local-address" description:"Local IP address to bind the outgoing connections to (plain UDP only)"`
+
+	// CPUAffinity is a comma-separated list of CPU cores the benchmark should
+	// be pinned to.
+	CPUAffinity string `long:"cpu-affinity" description:"Comma-separated list of CPU cores and ranges of them to pin the benchmark to, e.g. 0,1,4-7"`
+
+	// CDAB enables the mode when every hostname is queried twice: with the CD
+	// bit unset and set, so that the cost of DNSSEC validation could be
+	// measured.
+	CDAB bool `long:"cd-ab" description:"Alternate the CD bit per query and compare latencies of validated and unvalidated queries" optional:"yes" optional-value:"true"`
+
+	// CD makes all queries have the CD bit set, so that the resolver doesn't
+	// validate the responses.
+	CD bool `long:"cd" description:"Set the checking disabled (CD) bit in the queries to disable the DNSSEC validation on the resolver" optional:"yes" optional-value:"true"`
+
+	// LateWait is how long to keep waiting for a response after the query
+	// timeout.  Responses that arrive during this period are counted as late.
+	// It's only supported for plain DNS-over-UDP.
+	LateWait time.Duration `long:"late-wait" description:"Keep waiting for a response for this long after the timeout and count it as late (plain UDP only), e.g. 500ms"`
+
+	// NoRandomID makes all queries use the same message ID specified in
+	// QueryID instead of a random one.
+	NoRandomID bool `long:"no-random-id" description:"Use the fixed message ID from --query-id instead of a random one" optional:"yes" optional-value:"true"`
+
+	// NoRD makes the queries have the RD bit unset, e.g. to test
+	// authoritative servers.
+	NoRD bool `long:"no-rd" description:"Send the queries with the recursion desired (RD) bit unset, e.g. to test authoritative servers" optional:"yes" optional-value:"true"`
+
+	// QueryID is the message ID of the queries if NoRandomID is set.
+	QueryID uint16 `long:"query-id" description:"The message ID to use with --no-random-id" default:"0"`
+
+	// Questions is the number of the identical questions in every query.
+	// Zero is the same as one.
+	Questions int `long:"questions" description:"The number of identical questions in every query to test the handling of multi-question messages, most servers respond with FORMERR" default:"1"`
+
+	// UDPSize is the UDP payload size advertised in the EDNS0 OPT record.
+	// Zero means that no OPT record is added.
+	UDPSize uint16 `long:"udp-size" description:"Add an EDNS0 OPT record with this UDP payload size. If 0, no OPT record is added" default:"0"`
+
+	// DNSSEC sets the DO bit in the EDNS0 OPT record of the queries.  If
+	// UDPSize is not set, the UDP payload size is 4096.
+	DNSSEC bool `long:"dnssec" description:"Set the DNSSEC OK (DO) bit in the queries. The UDP payload size is taken from --udp-size or 4096 by default" optional:"yes" optional-value:"true"`
+
+	// EDNSVersion is the EDNS version of the OPT record of the queries.
+	EDNSVersion uint8 `long:"edns-version" description:"Add an EDNS0 OPT record with this EDNS version to the queries, e.g. 1 to check that the server responds with BADVERS to the versions it doesn't support" default:"0"`
+
+	// EDNSFlags is the 16-bit flags field of the OPT record of the queries.
+	// The DO bit is also set by DNSSEC.
+	EDNSFlags uint16 `long:"edns-flags" description:"Add an EDNS0 OPT record with this 16-bit flags field to the queries, e.g. 0x4000 to set the first reserved bit. The most significant bit is the DNSSEC OK (DO) bit" default:"0" base:"0"`
+
+	// ECS is the client subnet in the CIDR notation to send in the EDNS0
+	// Client Subnet option.
+	ECS string `long:"ecs" description:"Add an EDNS0 Client Subnet option with the specified subnet to the queries, e.g. 1.2.3.0/24"`
+
+	// Padding is the block size the queries should be padded to using the
+	// EDNS0 padding option (RFC 7830).  Zero disables padding, the flag
+	// without a value uses the block size recommended by RFC 8467.
+	Padding int `long:"padding" description:"Pad queries to a multiple of N bytes set as --padding=N using EDNS0 padding (RFC 7830). If set without a value, the block size of 128 bytes recommended by RFC 8467 is used" default:"0" optional:"yes" optional-value:"128"`
+
+	// PayloadRandomSize is the range of the wire sizes of the queries in the
+	// MIN-MAX form.  Every query is padded to a random size from it.
+	PayloadRandomSize string `long:"payload-random-size" description:"Pad every query to a random wire size from the range in bytes, e.g. 64-512, using EDNS0 padding (RFC 7830) to stress the buffer handling of the server, and report the distribution of the sizes sent. The queries that are longer than the chosen size aren't truncated"`
+
+	// TCPKeepalive is the timeout to send in the EDNS0 TCP keepalive option
+	// (RFC 7828).  The option isn't sent if it's nil, the flag without a
+	// value sends the option without a timeout as the clients should.
+	TCPKeepalive *time.Duration `long:"tcp-keepalive" description:"Send the EDNS0 TCP keepalive option (RFC 7828) and report the idle timeouts advertised by the server and the queries sent after them. If set without a value, the option has no timeout as the clients should send it, --tcp-keepalive=D sends the timeout D, e.g. 10s, to check how the server handles it" optional:"yes" optional-value:"0s"`
+
+	// Cookies enables sending the DNS cookies (RFC 7873) and reusing the server
+	// cookies from the responses on the same connection.
+	Cookies bool `long:"cookies" description:"Send DNS cookies (RFC 7873) and reuse the server cookie from the responses on the same connection" optional:"yes" optional-value:"true"`
+
+	// NSID enables requesting the name server identifier (RFC 5001) and
+	// reporting the distribution of the identifiers seen in the responses.
+	NSID bool `long:"nsid" description:"Request the name server identifier (RFC 5001) and report the responses per identifier to see how the load is spread across the backends" optional:"yes" optional-value:"true"`
+
+	// ExpectIP is a comma-separated list of IP addresses the A and AAAA
+	// records of every response are expected to contain.
+	ExpectIP string `long:"expect-ip" description:"Comma-separated list of IP addresses expected in the A and AAAA records of every response. Other responses are counted as wrong answers"`
+
+	// LatencyBuckets is a comma-separated list of the upper bounds of the
+	// latency histogram buckets in milliseconds.
+	LatencyBuckets string `long:"latency-buckets" description:"Comma-separated list of the upper bounds of the latency histogram buckets in milliseconds" default:"1,2,5,10,20,50,100,200,500,1000"`
+
+	// Slowest is the number of the slowest queries to print in the end.  Zero
+	// disables it.
+	Slowest int `long:"slowest" description:"The number of the slowest successfully processed queries to print with their names, types, and response codes in the end"`
+
+	// Log settings
+	// --
+
+	// ReportInterval is the interval of printing the intermediate results.
+	ReportInterval time.Duration `long:"report-interval" description:"Print the intermediate results at this interval, e.g. 10s, instead of every 100 queries"`
+
+	// SelfMetrics is the interval of logging the resource usage of the
+	// benchmark itself.  Zero disables it.
+	SelfMetrics time.Duration `long:"self-metrics" description:"Log the number of goroutines, the memory usage, and the open file descriptors of dnsbench itself every this interval, 5s if set without a value, and print their peak in the end, to make sure the client isn't the bottleneck" optional:"yes" optional-value:"5s"`
+
+	// TrendWindow is the length of the time windows the results are bucketed
+	// into to print the latency trend across the test, e.g. while the cache
+	// of the resolver is warming up.
+	TrendWindow time.Duration `long:"trend-window" description:"Bucket the results into time windows of this length, e.g. 10s, and print the latency in every window and whether it has improved from the first one to the last one, e.g. as the cache of the resolver warms up"`
+
+	// Verbose defines whether we should write the DEBUG-level log or not.
+	Verbose bool `short:"v" long:"verbose" description:"Verbose output (optional)" optional:"yes" optional-value:"true"`
+
+	// Quiet defines whether only the final results should be printed.  It's
+	// ignored if Verbose is set.
+	Quiet bool `short:"Q" long:"quiet" description:"Only print the final results, ignored with --verbose (optional)" optional:"yes" optional-value:"true"`
+
+	// LogOutput is the optional path to the log file.
+	LogOutput string `short:"o" long:"output" description:"Path to the log file. If not set, write to stdout."`
+
+	// JSONOutput is the optional path to the file the test results should be
+	// written to in the JSON format.
+	JSONOutput string `long:"json-output" description:"Path to the file to write the test results to in the JSON format."`
+
+	// Baseline is the optional path to the file with the results of a
+	// previous test written with JSONOutput to compare the results with.
+	Baseline string `long:"baseline" description:"Path to the file with the results of a previous test written with --json-output to print the difference from"`
+
+	// CSVOutput is the optional path to the file the outcome of every query
+	// should be written to in the CSV format.
+	CSVOutput string `long:"csv" description:"Path to the file to write the outcome of every query to in the CSV format. Note, that it grows large on long runs."`
+
+	// TimeseriesCSV is the optional path to the file the number of queries,
+	// errors, and the latency percentiles of every second should be written to
+	// in the CSV format.
+	TimeseriesCSV string `long:"timeseries-csv" description:"Path to the file to write a row per second of the test to in the CSV format: the timestamp, the number of queries and errors, and the p50 and p99 latency in milliseconds within that second, e.g. to plot how the server degraded over the test."`
+
+	// JSONLOutput is the optional path to the file the intermediate results
+	// should be written to one JSON object per line.
+	JSONLOutput string `long:"jsonl-output" description:"Path to the file to write the intermediate results to one JSON object per line at every report, so that it can be tailed during the test."`
+
+	// PrometheusOutput is the optional path to the file the test results
+	// should be written to in the Prometheus text exposition format.
+	PrometheusOutput string `long:"prometheus-output" description:"Path to the file to atomically write the test results to in the Prometheus text format."`
+
+	// StatsD is the optional address of the StatsD server the metrics should
+	// be sent to during the test.
+	StatsD string `long:"statsd" description:"Address (host:port) of the StatsD server to send the number of queries and errors, the QPS, and the average latency to every second over UDP"`
+
+	// StatsDPrefix is the prefix of the names of the metrics sent to StatsD.
+	StatsDPrefix string `long:"statsd-prefix" description:"Prefix of the names of the metrics sent to --statsd" default:"dnsbench"`
+
+	// FailOver is the percentage of failed queries above which the program
+	// exits with a non-zero code.  If nil, the exit code doesn't depend on
+	// the errors.
+	FailOver *float64 `long:"fail-over" description:"Exit with a non-zero code if the percentage of failed queries exceeds this threshold, e.g. 1.5"`
+}
+
+// String implements fmt.Stringer interface for Options.
+func (o *Options) String() (s string) {
+	b, _ := json.MarshalIndent(o, "", "    ")
+	return string(b)
+}
+
+// hasOutputFiles returns true if any of the files the results of a single test
+// are written to is set.
+func (o *Options) hasOutputFiles() (ok bool) {
+	return o.JSONOutput != "" ||
+		o.PrometheusOutput != "" ||
+		o.CSVOutput != "" ||
+		o.TimeseriesCSV != "" ||
+		o.JSONLOutput != ""
+}
+
+// isQuiet returns true if the progress of the test shouldn't be printed.
+func (o *Options) isQuiet() (ok bool) {
+	return o.Quiet && !o.Verbose
+}
+
+// queryTimeout returns the time after which a query is abandoned and counted
+// as an error.  It includes ConnectTimeout since the query may need to
+// establish a new connection first.
+func (o *Options) queryTimeout() (timeout time.Duration) {
+	return time.Duration(o.Timeout)*time.Second + o.LateWait + o.ConnectTimeout
+}
+
+// logProgress prints an INFO-level message about the progress of the test
+// unless the quiet mode is enabled.
+func (o *Options) logProgress(format string, args ...any) {
+	if !o.isQuiet() {
+		log.Info(format, args...)
+	}
+}
+
+// checkAddress validates the server address addr unless it's disabled in
+// options and warns if its port looks like the one of another protocol.
+func checkAddress(options *Options, addr string) (err error) {
+	if !options.NoValidateAddress {
+		err = validateAddress(addr)
+		if err != nil {
+			return fmt.Errorf("server address %s is invalid: %w", addr, err)
+		}
+	}
+
+	if hint := encryptedPortHint(addr); hint != "" {
+		log.Info("Warning: %s", hint)
+	}
+
+	return nil
+}
+
+// dohMethod returns the HTTP method of the DNS-over-HTTPS queries in upper
+// case, GET by default.
+func (o *Options) dohMethod() (method string) {
+	return cmp.Or(strings.ToUpper(o.DoHMethod), http.MethodGet)
+}
+
+// validate returns an error if the options are invalid or can't be used
+// together.  It doesn't check the values that must be parsed first, such as
+// the query types, and doesn't read any files.
+func (o *Options) validate() (err error) {
+	err = o.validateTransport()
+	if err != nil {
+		return err
+	}
+
+	err = o.validateQueries()
+	if err != nil {
+		return err
+	}
+
+	err = o.validateTCP()
+	if err != nil {
+		return err
+	}
+
+	err = o.validateEncryption()
+	if err != nil {
+		return err
+	}
+
+	err = o.validateConnections()
+	if err != nil {
+		return err
+	}
+
+	err = o.validateLimits()
+	if err != nil {
+		return err
+	}
+
+	err = o.validatePacing()
+	if err != nil {
+		return err
+	}
+
+	return o.validateQueryFiles()
+}
+
+// validateTransport validates the server address and the options of the
+// connections to it.
+func (o *Options) validateTransport() (err error) {
+	if o.Split == "" {
+		// The addresses of the split are validated when parsing it.
+		err = checkAddress(o, o.Address)
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.LateWait > 0 && !isPlainUDPAddress(o.Address) {
+		return errors.Error("--late-wait is only supported for plain DNS-over-UDP addresses")
+	}
+
+	if o.ConnectTimeout < 0 {
+		return fmt.Errorf("invalid connect timeout %s", o.ConnectTimeout)
+	}
+
+	// The upstreams of the library use a single timeout for both.
+	hasConnect := isDoTAddress(o.Address) || isDoHAddress(o.Address) || isUnixAddress(o.Address)
+	if o.ConnectTimeout > 0 && !hasConnect {
+		return errors.Error("--connect-timeout is only supported for DoT, DoH, and unix:// addresses")
+	}
+
+	if o.LocalAddress != "" {
+		// dnsproxy doesn't allow customizing the dialer, so binding to a local
+		// address is only possible with our own plain DNS-over-UDP client.
+		if !isPlainUDPAddress(o.Address) {
+			return errors.Error("--local-address is only supported for plain DNS-over-UDP addresses")
+		}
+
+		_, err = netip.ParseAddr(o.LocalAddress)
+		if err != nil {
+			return fmt.Errorf("local address %s is invalid: %w", o.LocalAddress, err)
+		}
+	}
+
+	return nil
+}
+
+// validateQueries validates the options of the synthesized queries.
+func (o *Options) validateQueries() (err error) {
+	if o.UniqueNames < 0 {
+		return fmt.Errorf("invalid number of unique names %d", o.UniqueNames)
+	}
+
+	if o.Questions < 0 {
+		return fmt.Errorf("invalid number of questions %d", o.Questions)
+	}
+
+	if o.Padding < 0 || o.Padding > dns.MaxMsgSize {
+		return fmt.Errorf("invalid padding block size %d", o.Padding)
+	}
+
+	if o.PayloadRandomSize != "" && (o.Padding > 0 || o.ReplayFile != "") {
+		return errors.Error("--payload-random-size can't be used with --padding or --replay-file")
+	}
+
+	if o.CD && o.CDAB {
+		return errors.Error("--cd can't be used with --cd-ab since it alternates the cd bit")
+	}
+
+	return nil
+}
+
+// validateTCP validates the options specific to the queries sent over TCP.
+func (o *Options) validateTCP() (err error) {
+	if k := o.TCPKeepalive; k != nil && (*k < 0 || *k > math.MaxUint16*tcpKeepaliveUnit) {
+		return fmt.Errorf("invalid tcp keepalive timeout %s", *k)
+	}
+
+	if o.TCPKeepalive != nil && o.TCPRatio > 0 {
+		// The idle timeouts of the UDP and TCP connections would be mixed up.
+		return errors.Error("--tcp-keepalive can't be used with --tcp-ratio")
+	}
+
+	if o.TCPRatio < 0 || o.TCPRatio > 1 {
+		return fmt.Errorf("invalid tcp ratio %f, must be from 0 to 1", o.TCPRatio)
+	}
+
+	if o.TCPRatio > 0 && (!isPlainUDPAddress(o.Address) || o.OpenModel) {
+		return errors.Error("--tcp-ratio is only supported for plain DNS addresses without --open-model")
+	}
+
+	return nil
+}
+
+// validateEncryption validates the options specific to the encrypted DNS
+// protocols.  It warns about the ones that are ignored for the address.
+func (o *Options) validateEncryption() (err error) {
+	method := o.dohMethod()
+	if method != http.MethodGet && method != http.MethodPost {
+		return fmt.Errorf("invalid DNS-over-HTTPS method %s", o.DoHMethod)
+	}
+
+	if !slices.Contains([]string{"", "1.1", "2", "3"}, o.HTTPVersion) {
+		return fmt.Errorf("invalid HTTP version %s", o.HTTPVersion)
+	}
+
+	isHTTP := method == http.MethodPost || o.HTTPVersion != ""
+	if o.Split == "" && !isDoHAddress(o.Address) && isHTTP {
+		log.Info("Warning: --doh-method and --http-version are ignored for non-DNS-over-HTTPS addresses")
+	}
+
+	isTLS := isDoTAddress(o.Address) || isDoHAddress(o.Address)
+	if o.TLSResumption != "" && !isTLS {
+		return errors.Error("--tls-resumption is only supported for DNS-over-TLS and DNS-over-HTTPS addresses")
+	}
+
+	if o.QUIC0RTT && !isDoQAddress(o.Address) {
+		return errors.Error("--quic-0rtt is only supported for DNS-over-QUIC addresses")
+	}
+
+	return nil
+}
+
+// validateConnections validates the options of how the connections are
+// created and shared between the queries.
+func (o *Options) validateConnections() (err error) {
+	if o.SharedUpstream {
+		if o.FreshConnection || o.OpenModel {
+			return errors.Error("--shared-upstream can't be used with --fresh-connection or --open-model")
+		}
+
+		if !isConcurrentUpstream(o) {
+			return errors.Error(
+				"--shared-upstream can't be used with unix:// addresses, --late-wait, --local-address, " +
+					"--questions, --quic-0rtt, or --tls-resumption and --connect-timeout for DNS-over-TLS",
+			)
+		}
+	}
+
+	if o.FreshConnection && o.Warmup > 0 {
+		return errors.Error("--fresh-connection can't be used with --warmup since the connections aren't reused")
+	}
+
+	if o.OpenModel {
+		if o.Rate <= 0 {
+			return errors.Error("--open-model requires a positive --rate-limit")
+		}
+
+		// Our plain DNS-over-UDP client can't be shared by the queries in
+		// flight.
+		if o.LateWait > 0 || o.LocalAddress != "" {
+			return errors.Error("--open-model can't be used with --late-wait or --local-address")
+		}
+	}
+
+	if o.MaxOutstanding < 0 {
+		return fmt.Errorf("invalid maximum number of queries in flight %d", o.MaxOutstanding)
+	}
+
+	if o.MaxOutstanding > 0 && !o.OpenModel {
+		return errors.Error("--max-outstanding requires --open-model")
+	}
+
+	return nil
+}
+
+// validateLimits validates the options that stop the test or fail it and the
+// ones of the collected statistics.
+func (o *Options) validateLimits() (err error) {
+	if o.FailOver != nil && (*o.FailOver < 0 || *o.FailOver > 100) {
+		return fmt.Errorf("invalid error rate threshold %f, must be from 0 to 100", *o.FailOver)
+	}
+
+	if o.MaxRuntime < 0 {
+		return fmt.Errorf("invalid maximum runtime %s", o.MaxRuntime)
+	}
+
+	if o.MaxErrors < 0 {
+		return fmt.Errorf("invalid maximum number of errors %d", o.MaxErrors)
+	}
+
+	if o.TrendWindow < 0 {
+		return fmt.Errorf("invalid trend window %s", o.TrendWindow)
+	}
+
+	if o.Slowest < 0 {
+		return fmt.Errorf("invalid number of slowest queries %d", o.Slowest)
+	}
+
+	if o.SelfMetrics < 0 {
+		return fmt.Errorf("invalid self metrics interval %s", o.SelfMetrics)
+	}
+
+	return nil
+}
+
+// validatePacing validates the options of how fast the queries are sent.
+func (o *Options) validatePacing() (err error) {
+	if o.BackoffMax < 0 {
+		return fmt.Errorf("invalid maximum backoff %s", o.BackoffMax)
+	}
+
+	if o.BackoffMax > 0 && o.OpenModel {
+		return errors.Error("--backoff-max can't be used with --open-model since the connections are shared")
+	}
+
+	if o.Delay < 0 {
+		return fmt.Errorf("invalid delay %s", o.Delay)
+	}
+
+	if o.Delay > 0 && o.OpenModel {
+		return errors.Error("--delay can't be used with --open-model since the connections are shared")
+	}
+
+	if o.RampDuration > 0 && (o.Rate <= 0 || o.RampStartRate <= 0) {
+		return errors.Error("--ramp-duration requires positive --rate-limit and --ramp-start-rate")
+	}
+
+	if o.Jitter < 0 || o.Jitter > 100 {
+		return fmt.Errorf("invalid jitter %f, must be from 0 to 100", o.Jitter)
+	}
+
+	if o.Jitter > 0 && o.Rate <= 0 {
+		return errors.Error("--jitter requires a positive --rate-limit")
+	}
+
+	return nil
+}
+
+// validateQueryFiles validates the options of the files the queries are read
+// from.
+func (o *Options) validateQueryFiles() (err error) {
+	if o.ReplayFile != "" && (o.QueriesPath != "" || o.Amplify || o.CDAB) {
+		return errors.Error("--replay-file can't be used with --file, --amplify or --cd-ab")
+	}
+
+	if o.WarmupFile != "" && o.DryRun {
+		return errors.Error("--warmup-file can't be used with --dry-run")
+	}
+
+	if o.OncePerName && (o.QueriesPath == "" || o.Amplify || o.CDAB) {
+		return errors.Error("--once-per-name requires --file and can't be used with --amplify or --cd-ab")
+	}
+
+	if o.Amplify && o.QueriesPath == "" {
+		return errors.Error("--amplify requires the queries file")
+	}
+
+	if o.Amplify && o.QueriesCount <= 0 {
+		return errors.Error("--amplify requires a positive --count")
+	}
+
+	return nil
+}
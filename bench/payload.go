@@ -0,0 +1,93 @@
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// sizeRange is the range of the wire sizes of the queries in bytes.
+type sizeRange struct {
+	// min is the smallest size.
+	min int
+
+	// max is the largest size.
+	max int
+}
+
+// parseSizeRange parses the range of the query sizes in bytes in the MIN-MAX
+// form, e.g. "64-512".
+func parseSizeRange(s string) (r *sizeRange, err error) {
+	minStr, maxStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("range %q must be in the MIN-MAX form", s)
+	}
+
+	r = &sizeRange{}
+	r.min, err = strconv.Atoi(strings.TrimSpace(minStr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum size %q", minStr)
+	}
+
+	r.max, err = strconv.Atoi(strings.TrimSpace(maxStr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid maximum size %q", maxStr)
+	}
+
+	if r.min <= 0 || r.max < r.min || r.max > dns.MaxMsgSize {
+		return nil, fmt.Errorf("sizes must be from 1 to %d and minimum must not exceed maximum", dns.MaxMsgSize)
+	}
+
+	return r, nil
+}
+
+// pick returns a random size from r.
+func (r *sizeRange) pick(rng *rand.Rand) (size int) {
+	return r.min + rng.Intn(r.max-r.min+1)
+}
+
+// padMsgToSize adds an EDNS0 padding option (RFC 7830) to m so that its wire
+// length is size.  If m is too long for that, the padding is empty and m is
+// longer.  It adds an OPT record to m if there is none.  It must be called
+// after all other options are added.
+func padMsgToSize(m *dns.Msg, size int) {
+	opt := ensureOPT(m)
+
+	padLen := max(size-m.Len()-paddingOptionHeaderLen, 0)
+
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{Padding: make([]byte, padLen)})
+}
+
+// sentSizesSummary returns a human-readable distribution of the wire sizes of
+// the queries sent.
+func (r *runState) sentSizesSummary() (s string) {
+	sizes := slices.Clone(r.sentSizes)
+	if len(sizes) == 0 {
+		return "no queries sent"
+	}
+
+	slices.Sort(sizes)
+
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+
+	percentile := func(p int) (size int) {
+		return sizes[(len(sizes)-1)*p/100]
+	}
+
+	return fmt.Sprintf(
+		"min %d, average %d, p50 %d, p90 %d, max %d bytes over %d queries",
+		sizes[0],
+		total/len(sizes),
+		percentile(50),
+		percentile(90),
+		sizes[len(sizes)-1],
+		len(sizes),
+	)
+}
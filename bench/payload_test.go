@@ -0,0 +1,111 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSizeRange(t *testing.T) {
+	testCases := []struct {
+		want    *sizeRange
+		name    string
+		in      string
+		wantErr string
+	}{{
+		want:    &sizeRange{min: 64, max: 512},
+		name:    "valid",
+		in:      "64-512",
+		wantErr: "",
+	}, {
+		want:    &sizeRange{min: 100, max: 100},
+		name:    "single",
+		in:      "100 - 100",
+		wantErr: "",
+	}, {
+		want:    nil,
+		name:    "no_range",
+		in:      "512",
+		wantErr: `range "512" must be in the MIN-MAX form`,
+	}, {
+		want:    nil,
+		name:    "reversed",
+		in:      "512-64",
+		wantErr: "minimum must not exceed maximum",
+	}, {
+		want:    nil,
+		name:    "too_large",
+		in:      "64-70000",
+		wantErr: "sizes must be from 1 to 65535",
+	}, {
+		want:    nil,
+		name:    "not_number",
+		in:      "a-512",
+		wantErr: `invalid minimum size "a"`,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := parseSizeRange(tc.in)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, r)
+		})
+	}
+}
+
+func TestPadMsgToSize(t *testing.T) {
+	m := &dns.Msg{}
+	m.SetQuestion("example.org.", dns.TypeA)
+
+	padMsgToSize(m, 300)
+
+	b, err := m.Pack()
+	require.NoError(t, err)
+	assert.Len(t, b, 300)
+
+	// The message is already longer than the size.
+	m = &dns.Msg{}
+	m.SetQuestion("example.org.", dns.TypeA)
+
+	padMsgToSize(m, 10)
+
+	padLen, ok := responsePadding(m)
+	require.True(t, ok)
+	assert.Zero(t, padLen)
+}
+
+func Test_runPayloadRandomSize(t *testing.T) {
+	addr := startUDPServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		_ = w.WriteMsg(resp)
+	})
+
+	o := &Options{
+		Address:           addr.String(),
+		Connections:       2,
+		Query:             "example.org",
+		Timeout:           10,
+		QueriesCount:      20,
+		PayloadRandomSize: "100-300",
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Len(t, state.sentSizes, o.QueriesCount)
+
+	for _, size := range state.sentSizes {
+		assert.GreaterOrEqual(t, size, 100)
+		assert.LessOrEqual(t, size, 300)
+	}
+
+	assert.Contains(t, state.sentSizesSummary(), "bytes over 20 queries")
+}
@@ -0,0 +1,80 @@
+package bench
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// nameParams are the parameters of a query used to replace the placeholders in
+// its name.
+type nameParams struct {
+	// rng generates the random labels.
+	rng *rand.Rand
+
+	// labels replace {random} if not nil.
+	labels *labelPool
+
+	// seq is the number of the names with {seq} expanded so far in the test.
+	seq *atomic.Uint64
+
+	// workerID is the index of the connection sending the query.
+	workerID int
+}
+
+// nameParams returns the parameters of the names queried by the connection with
+// workerID using rng.
+func (r *runState) nameParams(rng *rand.Rand, workerID int) (p *nameParams) {
+	return &nameParams{
+		rng:      rng,
+		labels:   r.labels,
+		seq:      &r.nameSeq,
+		workerID: workerID,
+	}
+}
+
+// placeholder is a token in the queried names replaced with a value specific to
+// every query.
+type placeholder struct {
+	// value returns the value replacing all occurrences of the token in a
+	// single name.
+	value func(p *nameParams) (v string)
+
+	// token is the placeholder including the braces, e.g. "{random}".
+	token string
+}
+
+// placeholders are the supported placeholders in the order they're replaced.
+// New ones only need to be added here.
+var placeholders = []placeholder{{
+	token: "{random}",
+	value: func(p *nameParams) (v string) { return p.labels.label(p.rng) },
+}, {
+	token: "{seq}",
+	value: func(p *nameParams) (v string) { return strconv.FormatUint(p.seq.Add(1), 10) },
+}, {
+	token: "{worker}",
+	value: func(p *nameParams) (v string) { return strconv.Itoa(p.workerID) },
+}, {
+	token: "{timestamp}",
+	value: func(_ *nameParams) (v string) { return strconv.FormatInt(time.Now().Unix(), 10) },
+}}
+
+// replacePlaceholders returns hostname with all placeholders replaced with
+// their values for the query with p.
+func replacePlaceholders(p *nameParams, hostname string) (domainName string) {
+	domainName = hostname
+	if !strings.Contains(domainName, "{") {
+		return domainName
+	}
+
+	for _, ph := range placeholders {
+		if strings.Contains(domainName, ph.token) {
+			domainName = strings.ReplaceAll(domainName, ph.token, ph.value(p))
+		}
+	}
+
+	return domainName
+}
@@ -0,0 +1,40 @@
+package bench
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplacePlaceholders(t *testing.T) {
+	var seq atomic.Uint64
+	p := &nameParams{
+		rng:      newRand(1),
+		seq:      &seq,
+		workerID: 3,
+	}
+
+	assert.Equal(t, "example.org", replacePlaceholders(p, "example.org"))
+
+	assert.Equal(t, "1.3.example.org", replacePlaceholders(p, "{seq}.{worker}.example.org"))
+	assert.Equal(t, "2.2.example.org", replacePlaceholders(p, "{seq}.{seq}.example.org"))
+
+	s := replacePlaceholders(p, "{random}.example.org")
+	label, ok := strings.CutSuffix(s, ".example.org")
+	require.True(t, ok)
+	assert.Len(t, label, randomLen)
+
+	before := time.Now().Unix()
+	s = replacePlaceholders(p, "{timestamp}.example.org")
+	ts, err := strconv.ParseInt(strings.TrimSuffix(s, ".example.org"), 10, 64)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, ts, before)
+
+	// Unknown placeholders are left as is.
+	assert.Equal(t, "{foo}.example.org", replacePlaceholders(p, "{foo}.example.org"))
+}
@@ -0,0 +1,301 @@
+package bench
+
+import (
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// Print logs the human-readable summary of the test results.
+func (res *Result) Print() {
+	options, state := res.options, res.state
+
+	log.Info("The test results are:")
+
+	processed, errs := state.counts()
+
+	printSummary(state, processed, errs)
+	printLatencyDistribution(options, state, processed)
+	printResponses(options, state, processed, errs)
+	printConnections(options, state, processed, errs)
+	printQueriesInFlight(options, state, processed, errs)
+	printFeatureResults(options, state, processed)
+	printLastResponses(state.workers)
+}
+
+// printSummary prints the number of the queries, their latency, and the
+// traffic.  processed and errs are the numbers of the processed and the failed
+// queries.
+func printSummary(state *runState, processed, errs int) {
+	log.Info("Elapsed: %s", state.elapsed())
+	log.Info("Average QPS: %f", state.qpsTotal())
+	log.Info("Processed queries: %d", processed)
+	log.Info("Average per query: %s", state.elapsedPerQuery())
+	log.Info("Latency min: %s", state.latency.minimum())
+	log.Info("Latency average: %s", state.latency.average())
+	log.Info("Latency p50: %s", state.latency.percentile(50))
+	log.Info("Latency p90: %s", state.latency.percentile(90))
+	log.Info("Latency p99: %s", state.latency.percentile(99))
+	log.Info("Latency max: %s", state.latency.maximum())
+	log.Info("Latency stddev: %s", state.latency.stdDev())
+	log.Info("Latency jitter: %s", state.latency.jitter())
+	log.Info("Errors count: %d", errs)
+
+	elapsedSec := max(state.elapsed().Seconds(), 1e-9)
+	log.Info(
+		"Bytes sent: %d (%.0f per second), received: %d (%.0f per second)",
+		state.bytesSent,
+		float64(state.bytesSent)/elapsedSec,
+		state.bytesReceived,
+		float64(state.bytesReceived)/elapsedSec,
+	)
+	log.Info("Average response size: %d bytes", state.bytesReceived/max(state.sizedResponses, 1))
+}
+
+// printLatencyDistribution prints the latency histogram and the slowest
+// queries if they are enabled and any query has been processed.
+func printLatencyDistribution(options *Options, state *runState, processed int) {
+	if len(state.latencyBuckets) > 0 && processed > 0 {
+		counts := state.latency.histogram(state.latencyBuckets)
+		log.Info("Latency histogram:\n%s", formatHistogram(state.latencyBuckets, counts))
+	}
+
+	if options.Slowest > 0 && processed > 0 {
+		log.Info("Slowest queries:\n%s", state.slowest)
+	}
+}
+
+// printResponses prints the breakdowns of the errors and the responses.
+func printResponses(options *Options, state *runState, processed, errs int) {
+	if errs > 0 {
+		log.Info("Errors by category: %s", state.errorsBreakdown())
+	}
+
+	if len(state.rcodes) > 0 {
+		log.Info("Response codes: %s", state.rcodesBreakdown())
+		log.Info(
+			"NOERROR responses without answers (NODATA): %d (%.2f%% of NOERROR)",
+			state.noData,
+			100*float64(state.noData)/float64(max(state.rcodes[dns.RcodeSuccess], 1)),
+		)
+	}
+
+	if options.EDNSVersion > 0 {
+		log.Info(
+			"BADVERS responses to EDNS version %d: %d (%.2f%%)",
+			options.EDNSVersion,
+			state.rcodes[dns.RcodeBadVers],
+			100*float64(state.rcodes[dns.RcodeBadVers])/float64(max(processed, 1)),
+		)
+	}
+
+	if state.ttls.count > 0 {
+		log.Info(
+			"Answer TTLs: min %ds, average %.1fs, max %ds over %d records",
+			state.ttls.min,
+			state.ttls.average(),
+			state.ttls.max,
+			state.ttls.count,
+		)
+	}
+
+	if len(state.qtypes) > 0 {
+		log.Info("Queries per type: %s", state.qtypesBreakdown())
+	}
+}
+
+// printConnections prints how the queries were spread over the connections
+// and what setting them up has cost.
+func printConnections(options *Options, state *runState, processed, errs int) {
+	if n := state.latencyFirst.count(); n > 0 {
+		first := state.latencyFirst.average()
+		log.Info("Time to first response: %s average over %d connections", first, n)
+		log.Info("Connection setup time: %s", max(first-state.latency.average(), 0))
+	}
+
+	if len(state.workers) > 1 {
+		slowest, fastest := state.slowestAndFastest()
+		printWorkerStats(state, "Slowest", slowest)
+		printWorkerStats(state, "Fastest", fastest)
+	}
+
+	if options.RampDuration > 0 {
+		if state.errorSpikeRate > 0 {
+			log.Info("Errors started spiking at: %.0f queries per second", state.errorSpikeRate)
+		} else {
+			log.Info("No error spike detected during the ramp-up")
+		}
+	}
+
+	if isPlainUDPAddress(options.Address) || state.hasPlainUDPTarget() {
+		log.Info(
+			"Truncated responses retried over TCP: %d (%.2f%%)",
+			state.truncated,
+			100*float64(state.truncated)/float64(max(processed+errs, 1)),
+		)
+	}
+
+	log.Info(
+		"Connections opened: %d for %d configured (%.2f per connection)",
+		state.upstreams,
+		options.Connections,
+		float64(state.upstreams)/float64(max(options.Connections, 1)),
+	)
+
+	if state.tlsHandshakes > 0 {
+		log.Info(
+			"TLS handshakes: %d, resumed: %d (%.2f%%)",
+			state.tlsHandshakes,
+			state.tlsResumed,
+			100*float64(state.tlsResumed)/float64(state.tlsHandshakes),
+		)
+	}
+
+	if options.QUIC0RTT {
+		quicQueries := max(state.quic0RTT+state.quic1RTT, 1)
+		log.Info(
+			"DNS-over-QUIC queries sent in 0-RTT: %d (%.2f%%), in 1-RTT: %d (%.2f%%), 0-RTT rejected: %d",
+			state.quic0RTT,
+			100*float64(state.quic0RTT)/float64(quicQueries),
+			state.quic1RTT,
+			100*float64(state.quic1RTT)/float64(quicQueries),
+			state.quic0RTTRejected,
+		)
+	}
+}
+
+// printQueriesInFlight prints the statistics of the retried, skipped, and late
+// queries.
+func printQueriesInFlight(options *Options, state *runState, processed, errs int) {
+	if options.Retries > 0 {
+		log.Info("Queries succeeded after a retry: %d", state.retried)
+	}
+
+	if options.OpenModel {
+		log.Info("Max queries in flight: %d", state.maxInFlight)
+	}
+
+	if options.MaxOutstanding > 0 {
+		log.Info(
+			"Skipped queries: %d (%.2f%%)",
+			state.skipped,
+			100*float64(state.skipped)/float64(max(processed+errs+state.skipped, 1)),
+		)
+	}
+
+	if options.LateWait > 0 {
+		total := processed + errs + state.late
+		log.Info(
+			"Late responses: %d (%.2f%%), average latency: %s",
+			state.late,
+			100*float64(state.late)/float64(max(total, 1)),
+			state.latencyLate.average(),
+		)
+	}
+}
+
+// printFeatureResults prints the results specific to the optional features
+// enabled in options.
+func printFeatureResults(options *Options, state *runState, processed int) {
+	if options.PayloadRandomSize != "" {
+		log.Info("Query sizes: %s", state.sentSizesSummary())
+	}
+
+	if options.Padding > 0 {
+		log.Info(
+			"Padded responses: %d of %d, average padding: %d bytes",
+			state.paddedResponses,
+			processed,
+			state.paddingBytes/max(state.paddedResponses, 1),
+		)
+	}
+
+	if options.TCPKeepalive != nil {
+		printTCPKeepaliveResults(state, processed)
+	}
+
+	if options.Cookies {
+		log.Info("Responses with a server cookie: %d of %d", state.serverCookies, processed)
+	}
+
+	if options.NSID && processed > 0 {
+		log.Info("Responses per NSID:\n%s", state.nsidBreakdown())
+	}
+
+	if options.OncePerName {
+		log.Info("Results per name:\n%s", state.nameResultsBreakdown())
+	}
+
+	if options.ExpectIP != "" {
+		log.Info(
+			"Wrong answers: %d (%.2f%%)",
+			state.wrongAnswers,
+			100*float64(state.wrongAnswers)/float64(max(processed, 1)),
+		)
+	}
+
+	if options.Amplify {
+		log.Info(
+			"Deviation from the observed distribution: %.2f%%",
+			distributionDeviation(state.countedHostnames, state.sentHostnames),
+		)
+	}
+
+	if options.CDAB {
+		printCDABResults(state)
+	}
+
+	if options.TCPRatio > 0 {
+		printTransportResults(state)
+	}
+
+	if len(state.targets) > 0 {
+		printSplitResults(state)
+	}
+
+	if options.TrendWindow > 0 {
+		printTrend(state)
+	}
+
+	if len(state.extendedErrors) > 0 {
+		log.Info("Extended DNS errors: %s", state.extendedErrorsBreakdown())
+	}
+
+	if options.SelfMetrics > 0 {
+		log.Info("Self metrics peak: %s", state.selfPeak)
+	}
+}
+
+// printCDABResults prints the comparison of latencies of the queries with the
+// CD bit unset and set.
+func printCDABResults(state *runState) {
+	v, u := state.latencyValidated, state.latencyUnvalidated
+
+	log.Info("DNSSEC validation cost (CD=0 vs CD=1):")
+	log.Info("  Processed queries: %d vs %d", v.count(), u.count())
+	log.Info("  Average per query: %s vs %s", v.average(), u.average())
+	log.Info("  p50: %s vs %s", v.percentile(50), u.percentile(50))
+	log.Info("  p90: %s vs %s", v.percentile(90), u.percentile(90))
+	log.Info("  p99: %s vs %s", v.percentile(99), u.percentile(99))
+	log.Info("  Max: %s vs %s", v.maximum(), u.maximum())
+}
+
+// printTCPKeepaliveResults prints the idle timeouts advertised in the EDNS0
+// TCP keepalive option and the outcome of the queries sent after them.
+func printTCPKeepaliveResults(state *runState, processed int) {
+	log.Info(
+		"Responses with the TCP keepalive option: %d of %d, timeout min: %s, max: %s",
+		state.keepaliveResponses,
+		processed,
+		state.keepaliveMin,
+		state.keepaliveMax,
+	)
+
+	if state.idleExpired > 0 {
+		log.Info(
+			"Queries after the idle timeout: %d, failed: %d (%.2f%%)",
+			state.idleExpired,
+			state.idleExpiredErrors,
+			100*float64(state.idleExpiredErrors)/float64(state.idleExpired),
+		)
+	}
+}
@@ -0,0 +1,191 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// progressUpdateInterval is how often the progress bar is redrawn.
+	progressUpdateInterval = 200 * time.Millisecond
+
+	// progressBarWidth is the number of characters in the bar itself.
+	progressBarWidth = 30
+)
+
+// isTerminal returns true if f is a terminal rather than a file or a pipe.
+func isTerminal(f *os.File) (ok bool) {
+	fi, err := f.Stat()
+
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar redraws a single line with the progress of the test, the current
+// QPS, and the estimated time remaining instead of printing the intermediate
+// results.
+type progressBar struct {
+	// state is the state of the test.
+	state *runState
+
+	// out is where the bar is drawn, it must be a terminal.
+	out io.Writer
+
+	// begun is closed when the measurement starts.
+	begun chan struct{}
+
+	// done is closed when the bar should be removed.
+	done chan struct{}
+
+	// finished is closed when the bar has been drawn for the last time.
+	finished chan struct{}
+
+	// beginOnce and stopOnce make begin and stop idempotent.
+	beginOnce *sync.Once
+	stopOnce  *sync.Once
+
+	// total is the overall number of queries to send.
+	total int
+}
+
+// newProgressBar creates a new *progressBar for the test with total queries
+// and starts the goroutine drawing it once begin is called.
+func newProgressBar(state *runState, out io.Writer, total int) (p *progressBar) {
+	p = &progressBar{
+		state:     state,
+		out:       out,
+		begun:     make(chan struct{}),
+		done:      make(chan struct{}),
+		finished:  make(chan struct{}),
+		beginOnce: &sync.Once{},
+		stopOnce:  &sync.Once{},
+		total:     total,
+	}
+
+	go p.draw()
+
+	return p
+}
+
+// newTestProgressBar returns the progress bar of the test described by options
+// or nil if it shouldn't be drawn.  The bar is drawn instead of the
+// intermediate results when the number of queries is known and the output is
+// an interactive terminal.
+func newTestProgressBar(options *Options, state *runState) (p *progressBar) {
+	state.showProgress = !state.quiet &&
+		!options.Verbose &&
+		!state.unlimited &&
+		options.ReportInterval == 0 &&
+		options.SelfMetrics == 0 &&
+		options.LogOutput == "" &&
+		isTerminal(os.Stdout)
+	if !state.showProgress {
+		return nil
+	}
+
+	return newProgressBar(state, os.Stdout, state.queriesCount)
+}
+
+// begin starts drawing the bar.  It's safe for use on a nil *progressBar.
+func (p *progressBar) begin() {
+	if p == nil {
+		return
+	}
+
+	p.beginOnce.Do(func() { close(p.begun) })
+}
+
+// stop draws the bar for the last time and moves to the next line so that the
+// following log messages are printed normally.  It returns once it's done.
+// It's safe for use on a nil *progressBar.
+func (p *progressBar) stop() {
+	if p == nil {
+		return
+	}
+
+	p.stopOnce.Do(func() { close(p.done) })
+	<-p.finished
+}
+
+// draw redraws the bar until p is stopped.
+func (p *progressBar) draw() {
+	defer close(p.finished)
+
+	lastCount, lastTime := 0, time.Now()
+
+	select {
+	case <-p.begun:
+	case <-p.done:
+		// The bar could be stopped right after it has begun.
+		select {
+		case <-p.begun:
+			p.finish(lastCount, lastTime)
+		default:
+		}
+
+		return
+	}
+
+	ticker := time.NewTicker(progressUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			p.finish(lastCount, lastTime)
+
+			return
+		case <-ticker.C:
+			lastCount, lastTime = p.print(lastCount, lastTime)
+		}
+	}
+}
+
+// finish draws the bar for the last time and moves to the next line.
+func (p *progressBar) finish(lastCount int, lastTime time.Time) {
+	p.print(lastCount, lastTime)
+	_, _ = fmt.Fprintln(p.out)
+}
+
+// print draws the current state of the test.  lastCount and lastTime are the
+// number of queries and the time of the previous call, they're used to
+// calculate the current QPS.
+func (p *progressBar) print(lastCount int, lastTime time.Time) (count int, now time.Time) {
+	p.state.m.Lock()
+	count = p.state.processed + p.state.errors
+	elapsed := p.state.elapsedLocked()
+	p.state.m.Unlock()
+
+	now = time.Now()
+	qps := float64(count-lastCount) / now.Sub(lastTime).Seconds()
+
+	var eta time.Duration
+	if count > 0 {
+		eta = time.Duration(float64(elapsed) / float64(count) * float64(p.total-count))
+	}
+
+	_, _ = fmt.Fprintf(p.out, "\r%s\x1b[K", formatProgress(count, p.total, qps, eta))
+
+	return count, now
+}
+
+// formatProgress returns the line of the progress bar for count queries out of
+// total sent at qps queries per second with eta remaining.
+func formatProgress(count, total int, qps float64, eta time.Duration) (s string) {
+	ratio := min(float64(count)/float64(max(total, 1)), 1)
+	filled := int(ratio * progressBarWidth)
+
+	return fmt.Sprintf(
+		"[%s%s] %5.1f%% %d/%d, %.0f q/s, ETA %s",
+		strings.Repeat("#", filled),
+		strings.Repeat("-", progressBarWidth-filled),
+		100*ratio,
+		count,
+		total,
+		qps,
+		eta.Round(time.Second),
+	)
+}
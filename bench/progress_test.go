@@ -0,0 +1,44 @@
+package bench
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatProgress(t *testing.T) {
+	s := formatProgress(25, 100, 12.4, 90*time.Second+300*time.Millisecond)
+	assert.Equal(
+		t,
+		"["+strings.Repeat("#", 7)+strings.Repeat("-", 23)+"]  25.0% 25/100, 12 q/s, ETA 1m30s",
+		s,
+	)
+
+	s = formatProgress(120, 100, 0, 0)
+	assert.True(t, strings.HasPrefix(s, "["+strings.Repeat("#", progressBarWidth)+"] 100.0%"))
+}
+
+func TestProgressBar(t *testing.T) {
+	state := &runState{startTime: time.Now(), processed: 5, errors: 1}
+	out := &bytes.Buffer{}
+
+	// The bar isn't drawn before it begins.
+	p := newProgressBar(state, out, 10)
+	p.stop()
+	assert.Empty(t, out.String())
+
+	p = newProgressBar(state, out, 10)
+	p.begin()
+	p.stop()
+	p.stop()
+
+	assert.Contains(t, out.String(), " 60.0% 6/10")
+	assert.True(t, strings.HasSuffix(out.String(), "\n"))
+
+	var nilBar *progressBar
+	nilBar.begin()
+	nilBar.stop()
+}
@@ -0,0 +1,577 @@
+package bench
+
+import (
+	"cmp"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/stringutil"
+	"github.com/miekg/dns"
+)
+
+// readHostnames reads the list of hostnames from the file, one per line.
+// Empty lines and lines starting with # are skipped.
+func readHostnames(path string) (hostnames []string, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	for _, line := range stringutil.SplitTrimmed(string(b), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hostnames = append(hostnames, line)
+	}
+
+	return hostnames, nil
+}
+
+// defaultQuery is the host name queried unless --query or the queries file is
+// set.  It's not the default value of the flag, so that it's known whether the
+// flag has been set.
+const defaultQuery = "example.org"
+
+// readQueries returns the hostnames to query and the queries to replay
+// according to options.  replay is nil unless options.ReplayFile is set, in
+// which case hostnames are the names of their questions.
+func readQueries(options *Options) (hostnames []string, replay []*dns.Msg, err error) {
+	if options.ReplayFile != "" {
+		options.logProgress("Reading queries to replay from the file %s", options.ReplayFile)
+
+		replay, err = readReplayFile(options.ReplayFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading queries from %s: %w", options.ReplayFile, err)
+		}
+
+		for _, m := range replay {
+			hostnames = append(hostnames, m.Question[0].Name)
+		}
+	} else if options.QueriesPath != "" {
+		options.logProgress("Reading hostnames from the file %s", options.QueriesPath)
+
+		if options.Query != "" {
+			log.Debug("The queries file takes precedence over the query %s", options.Query)
+		}
+
+		hostnames, err = readHostnames(options.QueriesPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading hostnames from %s: %w", options.QueriesPath, err)
+		}
+	} else {
+		hostnames = []string{cmp.Or(options.Query, defaultQuery)}
+	}
+
+	if len(hostnames) == 0 {
+		return nil, nil, fmt.Errorf("empty list of hostnames in %s", options.QueriesPath)
+	}
+
+	return hostnames, replay, nil
+}
+
+// scheduleHostnames sets the hostnames of r and the order they are queried in
+// according to options and the number of the queries to send.  hostnames are
+// the lines of the queries file, see [readQueries].  r.rng must be set.
+func (r *runState) scheduleHostnames(options *Options, hostnames []string) (err error) {
+	if options.Amplify {
+		r.countedHostnames, err = parseCountedHostnames(hostnames)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", options.QueriesPath, err)
+		}
+
+		r.amplified = amplifyHostnames(r.rng, r.countedHostnames, options.QueriesCount)
+		r.sentHostnames = map[string]int{}
+		hostnames = r.amplified.hostnames
+	} else if options.QueriesPath != "" && hasWeights(hostnames) {
+		log.Debug("Picking hostnames proportionally to their weights")
+
+		var counted []countedHostname
+		counted, err = parseCountedHostnames(hostnames)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", options.QueriesPath, err)
+		}
+
+		r.sampler = newWeightedSampler(counted)
+		hostnames = r.sampler.hostnames
+		if options.OncePerName {
+			// Every name is queried once regardless of its weight.
+			r.sampler = nil
+		}
+	}
+
+	r.queriesCount = options.QueriesCount
+	if options.OncePerName {
+		// The results are reported per name, so the duplicates would be
+		// queried and reported again.
+		hostnames = uniqueHostnames(hostnames)
+		r.queriesCount = len(hostnames)
+	}
+
+	r.hostnames = hostnames
+
+	r.unlimited = r.queriesCount <= 0
+
+	return nil
+}
+
+// uniqueHostnames returns hostnames without the duplicates in the order of
+// their first occurrence.
+func uniqueHostnames(hostnames []string) (res []string) {
+	seen := make(map[string]struct{}, len(hostnames))
+	for _, h := range hostnames {
+		if _, ok := seen[h]; !ok {
+			seen[h] = struct{}{}
+			res = append(res, h)
+		}
+	}
+
+	return res
+}
+
+// parseQType parses the DNS query type name, e.g. "AAAA".
+func parseQType(s string) (qtype uint16, err error) {
+	qtype, ok := dns.StringToType[strings.ToUpper(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown query type %q", s)
+	}
+
+	return qtype, nil
+}
+
+// parseQClass parses a DNS query class name, e.g. "IN" or "CH".
+func parseQClass(s string) (qclass uint16, err error) {
+	qclass, ok := dns.StringToClass[strings.ToUpper(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown query class %q", s)
+	}
+
+	return qclass, nil
+}
+
+// parseQTypes parses a comma-separated list of DNS query type names, e.g.
+// "A,AAAA,HTTPS".
+func parseQTypes(s string) (qtypes []uint16, err error) {
+	for _, name := range stringutil.SplitTrimmed(s, ",") {
+		var qtype uint16
+		qtype, err = parseQType(name)
+		if err != nil {
+			return nil, err
+		}
+
+		qtypes = append(qtypes, qtype)
+	}
+
+	if len(qtypes) == 0 {
+		return nil, fmt.Errorf("empty list of query types %q", s)
+	}
+
+	return qtypes, nil
+}
+
+// countedHostname is a hostname along with the number of times it has been
+// observed in the captured traffic.
+type countedHostname struct {
+	// hostname is the queried hostname.
+	hostname string
+
+	// count is how many times the hostname has been observed.
+	count int
+}
+
+// parseCountedHostnames parses lines in the "hostname count" format.  Lines
+// without a count are counted once.  Counts of duplicate hostnames are summed.
+func parseCountedHostnames(lines []string) (hosts []countedHostname, err error) {
+	indices := map[string]int{}
+	for i, line := range lines {
+		fields := strings.Fields(line)
+
+		h := countedHostname{count: 1}
+		switch len(fields) {
+		case 1:
+			h.hostname = fields[0]
+		case 2:
+			h.hostname = fields[0]
+			h.count, err = strconv.Atoi(fields[1])
+			if err != nil || h.count <= 0 {
+				return nil, fmt.Errorf("line %d: invalid count %q", i+1, fields[1])
+			}
+		default:
+			return nil, fmt.Errorf("line %d: expected \"hostname [count]\", got %q", i+1, line)
+		}
+
+		if idx, ok := indices[h.hostname]; ok {
+			hosts[idx].count += h.count
+
+			continue
+		}
+
+		indices[h.hostname] = len(hosts)
+		hosts = append(hosts, h)
+	}
+
+	return hosts, nil
+}
+
+// amplifiedSchedule is a shuffled list of hostnames in which every hostname
+// occurs proportionally to its observed count.  The hostnames are computed from
+// their indices, so that the list itself isn't kept in memory.
+type amplifiedSchedule struct {
+	// perm shuffles the indices of the list.
+	perm *indexPermutation
+
+	// hostnames are the distinct hostnames of the list.
+	hostnames []string
+
+	// cumulative is the cumulative sum of the number of occurrences of
+	// hostnames, i.e. its i-th element is the number of occurrences of the
+	// first i+1 hostnames.
+	cumulative []int
+}
+
+// amplifyHostnames returns a shuffled list of exactly total hostnames in which
+// every hostname occurs proportionally to its observed count.  The rounding
+// remainders are distributed using the largest remainder method so that the
+// resulting distribution is as close to the observed one as possible.  rng is
+// used to shuffle the list.  total must be positive.
+func amplifyHostnames(
+	rng *rand.Rand,
+	hosts []countedHostname,
+	total int,
+) (schedule *amplifiedSchedule) {
+	observed := 0
+	for _, h := range hosts {
+		observed += h.count
+	}
+
+	type share struct {
+		idx       int
+		remainder int
+	}
+
+	shares := make([]share, 0, len(hosts))
+	allocated := 0
+	quotas := make([]int, len(hosts))
+	for i, h := range hosts {
+		quotas[i] = total * h.count / observed
+		allocated += quotas[i]
+		shares = append(shares, share{idx: i, remainder: total * h.count % observed})
+	}
+
+	slices.SortStableFunc(shares, func(a, b share) (res int) {
+		return cmp.Compare(b.remainder, a.remainder)
+	})
+
+	for i := 0; allocated < total; i++ {
+		quotas[shares[i%len(shares)].idx]++
+		allocated++
+	}
+
+	schedule = &amplifiedSchedule{
+		perm:       newIndexPermutation(rng, total),
+		hostnames:  make([]string, 0, len(hosts)),
+		cumulative: make([]int, 0, len(hosts)),
+	}
+
+	allocated = 0
+	for i, h := range hosts {
+		if quotas[i] == 0 {
+			continue
+		}
+
+		allocated += quotas[i]
+		schedule.hostnames = append(schedule.hostnames, h.hostname)
+		schedule.cumulative = append(schedule.cumulative, allocated)
+	}
+
+	return schedule
+}
+
+// len returns the number of hostnames in the list.
+func (s *amplifiedSchedule) len() (n int) {
+	return s.cumulative[len(s.cumulative)-1]
+}
+
+// at returns the i-th hostname of the list.  The list is repeated over again
+// once it's exhausted.
+func (s *amplifiedSchedule) at(i int) (hostname string) {
+	n := s.perm.at(i % s.len())
+
+	// Find the first hostname which cumulative number of occurrences is
+	// greater than n.
+	j, _ := slices.BinarySearch(s.cumulative, n+1)
+
+	return s.hostnames[j]
+}
+
+// indexPermutationRounds is the number of the rounds of the Feistel network of
+// indexPermutation.
+const indexPermutationRounds = 4
+
+// indexPermutation is a pseudorandom permutation of the integers in [0, n).
+// It's a balanced Feistel network over the smallest even number of bits
+// covering n, and the values outside the range are encrypted again until they
+// get into it.
+type indexPermutation struct {
+	// keys are the keys of the rounds.
+	keys [indexPermutationRounds]uint64
+
+	// n is the number of the integers to permute.
+	n uint64
+
+	// halfBits is the number of bits in each half of the network.
+	halfBits uint
+
+	// mask is the mask of a half.
+	mask uint64
+}
+
+// newIndexPermutation returns a new permutation of the integers in [0, n)
+// with the keys taken from rng.  n must be positive.
+func newIndexPermutation(rng *rand.Rand, n int) (p *indexPermutation) {
+	halfBits := max(uint(bits.Len64(uint64(n-1))+1)/2, 1)
+
+	p = &indexPermutation{
+		n:        uint64(n),
+		halfBits: halfBits,
+		mask:     1<<halfBits - 1,
+	}
+
+	for i := range p.keys {
+		p.keys[i] = rng.Uint64()
+	}
+
+	return p
+}
+
+// at returns the integer which i in [0, n) is mapped to.
+func (p *indexPermutation) at(i int) (res int) {
+	// The network is a permutation of a range that includes [0, n), so the
+	// walk gets back into it eventually.  The range is less than 4 times
+	// larger, so it takes a few steps on average.
+	x := uint64(i)
+	for {
+		x = p.encrypt(x)
+		if x < p.n {
+			return int(x)
+		}
+	}
+}
+
+// encrypt runs x through the rounds of the network.
+func (p *indexPermutation) encrypt(x uint64) (res uint64) {
+	l, r := x>>p.halfBits, x&p.mask
+	for _, k := range p.keys {
+		l, r = r, l^(splitmix64(r^k)&p.mask)
+	}
+
+	return l<<p.halfBits | r
+}
+
+// hasWeights returns true if any of lines is in the "hostname weight" format.
+func hasWeights(lines []string) (ok bool) {
+	return slices.ContainsFunc(lines, func(line string) (found bool) {
+		return len(strings.Fields(line)) > 1
+	})
+}
+
+// weightedSampler randomly picks hostnames proportionally to their weights.
+type weightedSampler struct {
+	// hostnames is the list of hostnames to pick from.
+	hostnames []string
+
+	// cumulative is the cumulative sum of the weights of hostnames, i.e. its
+	// i-th element is the sum of the weights of the first i+1 hostnames.
+	cumulative []int
+}
+
+// newWeightedSampler creates a new *weightedSampler that picks hostnames
+// proportionally to their counts.  hosts must not be empty.
+func newWeightedSampler(hosts []countedHostname) (s *weightedSampler) {
+	s = &weightedSampler{
+		hostnames:  make([]string, 0, len(hosts)),
+		cumulative: make([]int, 0, len(hosts)),
+	}
+
+	total := 0
+	for _, h := range hosts {
+		total += h.count
+		s.hostnames = append(s.hostnames, h.hostname)
+		s.cumulative = append(s.cumulative, total)
+	}
+
+	return s
+}
+
+// pick returns a random hostname using rng.
+func (s *weightedSampler) pick(rng *rand.Rand) (hostname string) {
+	n := rng.Intn(s.cumulative[len(s.cumulative)-1])
+
+	// Find the first hostname which cumulative weight is greater than n.
+	i, _ := slices.BinarySearch(s.cumulative, n+1)
+
+	return s.hostnames[i]
+}
+
+// distributionDeviation returns the total variation distance in percents
+// between the observed distribution of hostnames and the distribution of the
+// hostnames that were actually sent.
+func distributionDeviation(hosts []countedHostname, sent map[string]int) (d float64) {
+	observed, sentTotal := 0, 0
+	for _, h := range hosts {
+		observed += h.count
+		sentTotal += sent[h.hostname]
+	}
+
+	if observed == 0 || sentTotal == 0 {
+		return 100
+	}
+
+	sum := 0.0
+	for _, h := range hosts {
+		expected := float64(h.count) / float64(observed)
+		actual := float64(sent[h.hostname]) / float64(sentTotal)
+		sum += max(expected-actual, actual-expected)
+	}
+
+	return sum / 2 * 100
+}
+
+// labelPool is a fixed set of random labels replacing {random} in turn to
+// bound the number of unique names.  A nil *labelPool generates a new random
+// label every time.
+type labelPool struct {
+	// next is the index of the next label to use.
+	next atomic.Uint64
+
+	// labels are the unique random labels.
+	labels []string
+}
+
+// newLabelPool returns a new *labelPool with n unique labels generated with
+// rng.  It returns nil if n is not positive.
+func newLabelPool(rng *rand.Rand, n int) (p *labelPool) {
+	if n <= 0 {
+		return nil
+	}
+
+	seen := make(map[string]struct{}, n)
+	p = &labelPool{
+		labels: make([]string, 0, n),
+	}
+	for len(p.labels) < n {
+		l := randString(rng, randomLen)
+		if _, ok := seen[l]; ok {
+			continue
+		}
+
+		seen[l] = struct{}{}
+		p.labels = append(p.labels, l)
+	}
+
+	return p
+}
+
+// label returns the next label from the pool.  It's safe for concurrent use.
+func (p *labelPool) label(rng *rand.Rand) (l string) {
+	if p == nil {
+		return randString(rng, randomLen)
+	}
+
+	i := p.next.Add(1) - 1
+
+	return p.labels[i%uint64(len(p.labels))]
+}
+
+// expandHostname replaces the placeholders in hostname with their values for
+// the query with p and randomizes its case if needed.
+func expandHostname(options *Options, p *nameParams, hostname string) (domainName string) {
+	domainName = replacePlaceholders(p, hostname)
+
+	if options.RandomizeCase {
+		domainName = randomizeCase(p.rng, domainName)
+	}
+
+	return domainName
+}
+
+// newQueryMsg creates a new DNS query message for domainName with the
+// parameters from options and q.
+func newQueryMsg(options *Options, q query, domainName string) (m *dns.Msg) {
+	id := options.QueryID
+	if !options.NoRandomID {
+		id = dns.Id()
+	}
+
+	if q.msg != nil {
+		// Replay the message as is except for the ID, which is the same for
+		// all repetitions otherwise.
+		m = q.msg.Copy()
+		m.Id = id
+
+		return m
+	}
+
+	m = &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Id:               id,
+			RecursionDesired: !options.NoRD,
+			CheckingDisabled: q.checkingDisabled || options.CD,
+		},
+		Question: []dns.Question{{
+			Name:   dns.Fqdn(domainName),
+			Qtype:  q.qtype,
+			Qclass: q.qclass,
+		}},
+	}
+
+	for i := 1; i < options.Questions; i++ {
+		m.Question = append(m.Question, m.Question[0])
+	}
+
+	if options.UDPSize > 0 || options.DNSSEC {
+		udpSize := options.UDPSize
+		if udpSize == 0 {
+			udpSize = dns.DefaultMsgSize
+		}
+
+		// Only add one OPT record with the DO bit if both are set.
+		m.SetEdns0(udpSize, options.DNSSEC)
+	}
+
+	if options.EDNSVersion > 0 || options.EDNSFlags > 0 {
+		setEDNSHeader(m, options.EDNSVersion, options.EDNSFlags)
+	}
+
+	if q.ecs.IsValid() {
+		addECS(m, q.ecs)
+	}
+
+	if len(q.cookie) > 0 {
+		addCookie(m, q.cookie)
+	}
+
+	if options.NSID {
+		addNSID(m)
+	}
+
+	if options.TCPKeepalive != nil {
+		addTCPKeepalive(m, *options.TCPKeepalive)
+	}
+
+	if options.Padding > 0 {
+		padMsg(m, options.Padding)
+	} else if q.size > 0 {
+		padMsgToSize(m, q.size)
+	}
+
+	return m
+}
@@ -0,0 +1,166 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadHostnames(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "queries.txt")
+	err := os.WriteFile(filePath, []byte("# comment\nexample.org\n\n  example.com  \n"), 0o600)
+	require.NoError(t, err)
+
+	hostnames, err := readHostnames(filePath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.org", "example.com"}, hostnames)
+}
+
+func TestReadQueries(t *testing.T) {
+	hostnames, replay, err := readQueries(&Options{})
+	require.NoError(t, err)
+	assert.Nil(t, replay)
+	assert.Equal(t, []string{defaultQuery}, hostnames)
+
+	hostnames, _, err = readQueries(&Options{Query: "example.net"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.net"}, hostnames)
+}
+
+func TestParseCountedHostnames(t *testing.T) {
+	hosts, err := parseCountedHostnames([]string{
+		"example.org 3",
+		"example.com",
+		"example.org 2",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []countedHostname{
+		{hostname: "example.org", count: 5},
+		{hostname: "example.com", count: 1},
+	}, hosts)
+
+	_, err = parseCountedHostnames([]string{"example.org -1"})
+	assert.Error(t, err)
+
+	_, err = parseCountedHostnames([]string{"example.org 1 2"})
+	assert.Error(t, err)
+}
+
+func TestAmplifyHostnames(t *testing.T) {
+	hosts := []countedHostname{
+		{hostname: "a.example", count: 5},
+		{hostname: "b.example", count: 3},
+		{hostname: "c.example", count: 1},
+	}
+
+	schedule := amplifyHostnames(newRand(1), hosts, 100)
+	require.Equal(t, 100, schedule.len())
+
+	sent := map[string]int{}
+	for i := range schedule.len() {
+		sent[schedule.at(i)]++
+	}
+
+	assert.Equal(t, map[string]int{
+		"a.example": 56,
+		"b.example": 33,
+		"c.example": 11,
+	}, sent)
+	assert.Less(t, distributionDeviation(hosts, sent), 1.0)
+}
+
+func TestIndexPermutation(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 7, 64, 100, 1000} {
+		p := newIndexPermutation(newRand(int64(n)), n)
+
+		seen := make([]bool, n)
+		for i := range n {
+			v := p.at(i)
+			require.GreaterOrEqual(t, v, 0)
+			require.Less(t, v, n)
+			require.False(t, seen[v], "n %d: %d is repeated", n, v)
+
+			seen[v] = true
+		}
+	}
+
+	// The order is shuffled.
+	p := newIndexPermutation(newRand(1), 100)
+	identity := true
+	for i := range 100 {
+		identity = identity && p.at(i) == i
+	}
+
+	assert.False(t, identity)
+}
+
+func TestParseQTypes(t *testing.T) {
+	qtypes, err := parseQTypes("A, aaaa,HTTPS")
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeHTTPS}, qtypes)
+
+	qtypes, err = parseQTypes("ANY")
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{dns.TypeANY}, qtypes)
+
+	_, err = parseQTypes("A,FOO")
+	assert.Error(t, err)
+
+	_, err = parseQTypes(" , ")
+	assert.Error(t, err)
+}
+
+func TestParseQClass(t *testing.T) {
+	qclass, err := parseQClass("ch")
+	require.NoError(t, err)
+	assert.Equal(t, uint16(dns.ClassCHAOS), qclass)
+
+	_, err = parseQClass("FOO")
+	assert.Error(t, err)
+}
+
+func TestHasWeights(t *testing.T) {
+	assert.False(t, hasWeights([]string{"example.org", "example.net"}))
+	assert.True(t, hasWeights([]string{"example.org", "example.net 10"}))
+}
+
+func TestWeightedSampler(t *testing.T) {
+	s := newWeightedSampler([]countedHostname{
+		{hostname: "hot.example", count: 9},
+		{hostname: "cold.example", count: 1},
+	})
+
+	const total = 10_000
+
+	rng := newRand(1)
+	picked := map[string]int{}
+	for range total {
+		picked[s.pick(rng)]++
+	}
+
+	require.Len(t, picked, 2)
+	assert.InDelta(t, 0.9, float64(picked["hot.example"])/total, 0.03)
+}
+
+func TestLabelPool(t *testing.T) {
+	rng := newRand(1)
+
+	var p *labelPool
+	assert.Len(t, p.label(rng), randomLen)
+	assert.Nil(t, newLabelPool(rng, 0))
+
+	p = newLabelPool(rng, 3)
+	labels := map[string]int{}
+	for range 9 {
+		labels[p.label(rng)]++
+	}
+
+	assert.Len(t, labels, 3)
+	for l, n := range labels {
+		assert.Equal(t, 3, n, l)
+	}
+}
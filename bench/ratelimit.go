@@ -0,0 +1,237 @@
+package bench
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"go.uber.org/ratelimit"
+)
+
+// errorSpikeThreshold is the share of failed queries in a one-second window
+// that is considered an error spike during the ramp-up.
+const errorSpikeThreshold = 0.05
+
+// maxCatchUp is how far behind the schedule the rate limiters can get.  The
+// permissions scheduled earlier are skipped, so that the queries aren't sent in
+// a burst after a long stall, e.g. a timeout with a single connection.
+const maxCatchUp = time.Second
+
+// newRateLimiter returns the rate limiter of the queries according to options.
+// seed is the seed of the test, the jitter uses a generator derived from it.
+func newRateLimiter(options *Options, seed int64) (l ratelimit.Limiter) {
+	// Use a separate source for the jitter so that it doesn't change the
+	// names and the choices made with the same seed and doesn't repeat them.
+	j := newJitter(options.Jitter, newRand(subSeed(seed, seedPurposeJitter, 0)))
+
+	switch {
+	case options.RampDuration > 0:
+		return newRampLimiter(options.RampStartRate, options.Rate, options.RampDuration, j)
+	case options.Rate > 0:
+		return newScheduleLimiter(options.Rate, j)
+	default:
+		return ratelimit.NewUnlimited()
+	}
+}
+
+// catchUp returns the scheduled time t of the next permission or the earliest
+// time allowed by [maxCatchUp] if t is too far in the past.  A zero t means
+// that no permission has been issued yet, in which case it's now.
+func catchUp(t time.Time) (res time.Time) {
+	now := time.Now()
+	if t.IsZero() {
+		return now
+	}
+
+	return latestTime(t, now.Add(-maxCatchUp))
+}
+
+// latestTime returns the latest of a and b.
+func latestTime(a, b time.Time) (t time.Time) {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+// rampLimiter is a rate limiter which rate linearly increases from startRate
+// to targetRate over the ramp duration.
+type rampLimiter struct {
+	// start is the time when the ramp-up has started.
+	start time.Time
+
+	// next is the time when the next query is allowed to be sent.
+	next time.Time
+
+	// duration is the duration of the ramp-up.
+	duration time.Duration
+
+	// startRate is the initial rate limit.
+	startRate float64
+
+	// targetRate is the rate limit at the end of the ramp-up.
+	targetRate float64
+
+	// jitter randomizes the intervals between the queries.  nil means no
+	// jitter.
+	jitter *jitter
+
+	// mu protects next and jitter.
+	mu sync.Mutex
+}
+
+// type check
+var _ ratelimit.Limiter = (*rampLimiter)(nil)
+
+// newRampLimiter creates a new *rampLimiter.  The ramp-up starts immediately.
+// j is nil if the intervals between the queries aren't randomized.
+func newRampLimiter(
+	startRate int,
+	targetRate int,
+	duration time.Duration,
+	j *jitter,
+) (l *rampLimiter) {
+	return &rampLimiter{
+		start:      time.Now(),
+		duration:   duration,
+		startRate:  float64(startRate),
+		targetRate: float64(targetRate),
+		jitter:     j,
+	}
+}
+
+// currentRate returns the rate limit at the moment now.
+func (l *rampLimiter) currentRate(now time.Time) (rate float64) {
+	progress := float64(now.Sub(l.start)) / float64(l.duration)
+	progress = min(max(progress, 0), 1)
+
+	return l.startRate + (l.targetRate-l.startRate)*progress
+}
+
+// Take implements the [ratelimit.Limiter] interface for *rampLimiter.
+func (l *rampLimiter) Take() (t time.Time) {
+	l.mu.Lock()
+	// Don't skip the permissions recently missed by slow queries, see the
+	// comment on [scheduleLimiter].
+	t = catchUp(l.next)
+	l.next = t.Add(l.jitter.apply(time.Duration(float64(time.Second) / l.currentRate(t))))
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(t))
+
+	return t
+}
+
+// scheduleLimiter is a rate limiter that issues permissions on a fixed
+// schedule.  Unlike the limiters from [ratelimit], it doesn't skip the
+// permissions that weren't taken in time, e.g. because of a slow response, so
+// that the time returned by Take is when the query should have been sent.
+// Measuring the latency from that time avoids the coordinated omission, i.e.
+// slow responses reducing the send rate and hiding their own delays.  The
+// permissions more than [maxCatchUp] behind the schedule are skipped though.
+type scheduleLimiter struct {
+	// next is the time when the next query is scheduled to be sent.
+	next time.Time
+
+	// interval is the interval between the queries.
+	interval time.Duration
+
+	// jitter randomizes the intervals between the queries.  nil means no
+	// jitter.
+	jitter *jitter
+
+	// mu protects next and jitter.
+	mu sync.Mutex
+}
+
+// type check
+var _ ratelimit.Limiter = (*scheduleLimiter)(nil)
+
+// newScheduleLimiter creates a new *scheduleLimiter issuing rate permissions
+// per second.  rate must be positive.  j is nil if the intervals between the
+// queries aren't randomized.
+func newScheduleLimiter(rate int, j *jitter) (l *scheduleLimiter) {
+	return &scheduleLimiter{
+		interval: time.Second / time.Duration(rate),
+		jitter:   j,
+	}
+}
+
+// Take implements the [ratelimit.Limiter] interface for *scheduleLimiter.  It
+// returns the scheduled time, which is in the past if the caller is late.
+func (l *scheduleLimiter) Take() (t time.Time) {
+	l.mu.Lock()
+	t = catchUp(l.next)
+
+	l.next = t.Add(l.jitter.apply(l.interval))
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(t))
+
+	return t
+}
+
+// jitter randomizes the intervals between the queries so that the load isn't
+// perfectly uniform.  It isn't safe for concurrent use.
+type jitter struct {
+	// rng is the source of the random deviations.
+	rng *rand.Rand
+
+	// ratio is the maximum deviation of an interval relative to its length,
+	// from 0 to 1.
+	ratio float64
+}
+
+// newJitter returns the jitter deviating the intervals by up to percent of
+// their length or nil if percent is zero.
+func newJitter(percent float64, rng *rand.Rand) (j *jitter) {
+	if percent == 0 {
+		return nil
+	}
+
+	return &jitter{
+		rng:   rng,
+		ratio: percent / 100,
+	}
+}
+
+// apply returns the interval d deviated randomly by up to the ratio of j in
+// either direction, so that the average interval stays the same.  j may be
+// nil, in which case d is returned as is.
+func (j *jitter) apply(d time.Duration) (res time.Duration) {
+	if j == nil {
+		return d
+	}
+
+	return time.Duration(float64(d) * (1 + j.ratio*(2*j.rng.Float64()-1)))
+}
+
+// monitorRamp samples the errors rate every second during the ramp-up and
+// records the rate limit at which errors started spiking.  It returns when
+// done is closed.
+func monitorRamp(state *runState, l *rampLimiter, done <-chan bool) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	prevProcessed, prevErrors := 0, 0
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			processed, errs := state.counts()
+			total := processed + errs - prevProcessed - prevErrors
+			failed := errs - prevErrors
+			prevProcessed, prevErrors = processed, errs
+
+			if total > 0 && float64(failed)/float64(total) > errorSpikeThreshold {
+				rate := l.currentRate(now)
+				if state.setErrorSpikeRate(rate) {
+					log.Info("Errors started spiking at %.0f queries per second", rate)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRampLimiter(t *testing.T) {
+	l := newRampLimiter(10, 110, 10*time.Second, nil)
+
+	assert.Equal(t, 10.0, l.currentRate(l.start))
+	assert.Equal(t, 60.0, l.currentRate(l.start.Add(5*time.Second)))
+	assert.Equal(t, 110.0, l.currentRate(l.start.Add(20*time.Second)))
+
+	// The first query is sent immediately, the second one after 1/10s.
+	first := l.Take()
+	second := l.Take()
+	assert.InDelta(t, 100*time.Millisecond, second.Sub(first), float64(5*time.Millisecond))
+}
+
+func TestScheduleLimiter(t *testing.T) {
+	l := newScheduleLimiter(10, nil)
+
+	first := l.Take()
+	second := l.Take()
+	assert.InDelta(t, 100*time.Millisecond, second.Sub(first), float64(5*time.Millisecond))
+
+	// Emulate a slow query, the permissions that haven't been taken in time
+	// are issued immediately and keep their scheduled time.
+	time.Sleep(300 * time.Millisecond)
+
+	third := l.Take()
+	assert.Equal(t, second.Add(100*time.Millisecond), third)
+	assert.Less(t, third, time.Now().Add(-100*time.Millisecond))
+}
+
+func TestScheduleLimiter_maxCatchUp(t *testing.T) {
+	l := newScheduleLimiter(100, nil)
+
+	first := l.Take()
+
+	// Emulate a stall longer than maxCatchUp, the permissions scheduled before
+	// it are skipped instead of being issued in a burst.
+	time.Sleep(maxCatchUp + 200*time.Millisecond)
+
+	second := l.Take()
+	assert.Greater(t, second.Sub(first), 150*time.Millisecond)
+	assert.InDelta(t, maxCatchUp, time.Since(second), float64(50*time.Millisecond))
+
+	// There is no more than maxCatchUp worth of permissions to catch up on.
+	start := time.Now()
+	n := 0
+	for next := second; time.Since(next) > 20*time.Millisecond; n++ {
+		next = l.Take()
+	}
+
+	assert.InDelta(t, 100, n, 5)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestJitter(t *testing.T) {
+	assert.Nil(t, newJitter(0, newRand(1)))
+
+	var nilJitter *jitter
+	assert.Equal(t, time.Second, nilJitter.apply(time.Second))
+
+	j := newJitter(20, newRand(1))
+
+	var sum time.Duration
+	const n = 1000
+	for range n {
+		d := j.apply(time.Second)
+		assert.GreaterOrEqual(t, d, 800*time.Millisecond)
+		assert.LessOrEqual(t, d, 1200*time.Millisecond)
+
+		sum += d
+	}
+
+	// The average interval stays about the same.
+	assert.InDelta(t, time.Second, sum/n, float64(20*time.Millisecond))
+}
+
+func TestScheduleLimiter_jitter(t *testing.T) {
+	l := newScheduleLimiter(10, newJitter(50, newRand(1)))
+
+	prev := l.Take()
+	for range 3 {
+		next := l.Take()
+		d := next.Sub(prev)
+		assert.GreaterOrEqual(t, d, 50*time.Millisecond)
+		assert.LessOrEqual(t, d, 150*time.Millisecond)
+
+		prev = next
+	}
+}
@@ -0,0 +1,261 @@
+package bench
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// Magic numbers of the pcap files in both byte orders with the microsecond and
+// nanosecond timestamps.
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+)
+
+// Link-layer header types of the pcap files that are supported.
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+)
+
+// Lengths of the headers of the pcap files and the packets in them.
+const (
+	pcapHeaderLen       = 24
+	pcapRecordHeaderLen = 16
+	ethernetHeaderLen   = 14
+	linuxSLLHeaderLen   = 16
+	nullHeaderLen       = 4
+	ipv6HeaderLen       = 40
+	udpHeaderLen        = 8
+)
+
+// EtherType values of the network layer protocols.
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+)
+
+// protoUDP is the IP protocol number of UDP.
+const protoUDP = 17
+
+// errNotDNSQuery is returned when a packet doesn't contain a DNS query sent
+// over UDP to port 53.
+const errNotDNSQuery errors.Error = "not a dns query"
+
+// readReplayFile reads the DNS queries to replay from the file at path.  The
+// file is either a pcap file with the queries sent over UDP to port 53 or a
+// text file with one hex-encoded DNS message per line, empty lines and lines
+// starting with # are skipped.  The format is detected by the magic number of
+// pcap files.
+func readReplayFile(path string) (msgs []*dns.Msg, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	if isPcap(b) {
+		msgs, err = parsePcap(b)
+	} else {
+		msgs, err = parseHexMessages(string(b))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("no dns queries found in %s", path)
+	}
+
+	return msgs, nil
+}
+
+// isPcap returns true if b starts with the magic number of pcap files.
+func isPcap(b []byte) (ok bool) {
+	if len(b) < 4 {
+		return false
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(b) {
+		case pcapMagicMicro, pcapMagicNano:
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseHexMessages parses the hex-encoded DNS messages from s, one per line.
+func parseHexMessages(s string) (msgs []*dns.Msg, err error) {
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var b []byte
+		b, err = hex.DecodeString(strings.Map(dropHexSpace, line))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: decoding hex: %w", i+1, err)
+		}
+
+		m := &dns.Msg{}
+		err = m.Unpack(b)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: unpacking message: %w", i+1, err)
+		}
+
+		if len(m.Question) == 0 {
+			return nil, fmt.Errorf("line %d: message has no question", i+1)
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	return msgs, nil
+}
+
+// dropHexSpace is a [strings.Map] function that drops the characters used to
+// separate the bytes in hex dumps.
+func dropHexSpace(r rune) (res rune) {
+	if r == ' ' || r == '\t' || r == ':' {
+		return -1
+	}
+
+	return r
+}
+
+// parsePcap returns the DNS queries from the packets of the pcap file b.  The
+// packets that aren't DNS queries sent over UDP to port 53 are skipped.
+func parsePcap(b []byte) (msgs []*dns.Msg, err error) {
+	if len(b) < pcapHeaderLen {
+		return nil, fmt.Errorf("pcap header is too short: %d bytes", len(b))
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if m := binary.LittleEndian.Uint32(b); m != pcapMagicMicro && m != pcapMagicNano {
+		order = binary.BigEndian
+	}
+
+	linkType := order.Uint32(b[20:])
+	switch linkType {
+	case linkTypeNull, linkTypeEthernet, linkTypeRaw, linkTypeLinuxSLL:
+		// Go on.
+	default:
+		return nil, fmt.Errorf("unsupported pcap link type %d", linkType)
+	}
+
+	for b = b[pcapHeaderLen:]; len(b) >= pcapRecordHeaderLen; {
+		inclLen := int(order.Uint32(b[8:]))
+		b = b[pcapRecordHeaderLen:]
+		if inclLen > len(b) {
+			return nil, fmt.Errorf("packet length %d exceeds the file", inclLen)
+		}
+
+		var m *dns.Msg
+		m, err = parsePacket(b[:inclLen], linkType)
+		b = b[inclLen:]
+		if err != nil {
+			// Skip the packets that aren't DNS queries.
+			continue
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	return msgs, nil
+}
+
+// parsePacket returns the DNS query from the captured packet pkt with the
+// link-layer header of linkType.
+func parsePacket(pkt []byte, linkType uint32) (m *dns.Msg, err error) {
+	var etherType uint16
+	switch linkType {
+	case linkTypeEthernet:
+		if len(pkt) < ethernetHeaderLen {
+			return nil, errNotDNSQuery
+		}
+
+		etherType, pkt = binary.BigEndian.Uint16(pkt[12:]), pkt[ethernetHeaderLen:]
+		if etherType == etherTypeVLAN && len(pkt) >= 4 {
+			etherType, pkt = binary.BigEndian.Uint16(pkt[2:]), pkt[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(pkt) < linuxSLLHeaderLen {
+			return nil, errNotDNSQuery
+		}
+
+		etherType, pkt = binary.BigEndian.Uint16(pkt[14:]), pkt[linuxSLLHeaderLen:]
+	case linkTypeNull:
+		if len(pkt) < nullHeaderLen {
+			return nil, errNotDNSQuery
+		}
+
+		// The address family is in the host byte order, so rely on the IP
+		// version instead.
+		pkt = pkt[nullHeaderLen:]
+	}
+
+	payload, err := udpPayload(pkt, etherType)
+	if err != nil {
+		return nil, err
+	}
+
+	m = &dns.Msg{}
+	err = m.Unpack(payload)
+	if err != nil || m.Response || len(m.Question) == 0 {
+		return nil, errNotDNSQuery
+	}
+
+	return m, nil
+}
+
+// udpPayload returns the payload of the UDP datagram sent to port 53 in the IP
+// packet pkt.  etherType is used to check the IP version if it's not zero.
+func udpPayload(pkt []byte, etherType uint16) (payload []byte, err error) {
+	if len(pkt) == 0 {
+		return nil, errNotDNSQuery
+	}
+
+	var udp []byte
+	switch version := pkt[0] >> 4; {
+	case version == 4 && (etherType == 0 || etherType == etherTypeIPv4):
+		ihl := int(pkt[0]&0x0f) * 4
+		if len(pkt) < ihl || ihl < 20 || pkt[9] != protoUDP {
+			return nil, errNotDNSQuery
+		}
+
+		// Skip the fragments, since they can't be reassembled here.
+		if binary.BigEndian.Uint16(pkt[6:])&0x3fff != 0 {
+			return nil, errNotDNSQuery
+		}
+
+		udp = pkt[ihl:]
+	case version == 6 && (etherType == 0 || etherType == etherTypeIPv6):
+		// The extension headers aren't supported.
+		if len(pkt) < ipv6HeaderLen || pkt[6] != protoUDP {
+			return nil, errNotDNSQuery
+		}
+
+		udp = pkt[ipv6HeaderLen:]
+	default:
+		return nil, errNotDNSQuery
+	}
+
+	if len(udp) < udpHeaderLen || binary.BigEndian.Uint16(udp[2:]) != 53 {
+		return nil, errNotDNSQuery
+	}
+
+	return udp[udpHeaderLen:], nil
+}
@@ -0,0 +1,116 @@
+package bench
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPacket returns an Ethernet frame with an IPv4 UDP datagram to dstPort
+// containing m.
+func newTestPacket(t *testing.T, m *dns.Msg, dstPort uint16) (pkt []byte) {
+	t.Helper()
+
+	payload, err := m.Pack()
+	require.NoError(t, err)
+
+	udp := make([]byte, udpHeaderLen, udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], 12345)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpHeaderLen+len(payload)))
+	udp = append(udp, payload...)
+
+	ip := make([]byte, 20, 20+len(udp))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(udp)))
+	ip[8] = 64
+	ip[9] = protoUDP
+	copy(ip[12:], []byte{192, 0, 2, 1})
+	copy(ip[16:], []byte{192, 0, 2, 53})
+	ip = append(ip, udp...)
+
+	pkt = make([]byte, ethernetHeaderLen, ethernetHeaderLen+len(ip))
+	binary.BigEndian.PutUint16(pkt[12:], etherTypeIPv4)
+
+	return append(pkt, ip...)
+}
+
+// newTestPcap returns a pcap file with the Ethernet frames pkts.
+func newTestPcap(pkts ...[]byte) (b []byte) {
+	b = make([]byte, pcapHeaderLen)
+	binary.LittleEndian.PutUint32(b[0:], pcapMagicMicro)
+	binary.LittleEndian.PutUint16(b[4:], 2)
+	binary.LittleEndian.PutUint16(b[6:], 4)
+	binary.LittleEndian.PutUint32(b[16:], 65535)
+	binary.LittleEndian.PutUint32(b[20:], linkTypeEthernet)
+
+	for _, pkt := range pkts {
+		hdr := make([]byte, pcapRecordHeaderLen)
+		binary.LittleEndian.PutUint32(hdr[8:], uint32(len(pkt)))
+		binary.LittleEndian.PutUint32(hdr[12:], uint32(len(pkt)))
+		b = append(b, hdr...)
+		b = append(b, pkt...)
+	}
+
+	return b
+}
+
+func TestReadReplayFile(t *testing.T) {
+	query := (&dns.Msg{}).SetQuestion("example.org.", dns.TypeAAAA)
+	resp := (&dns.Msg{}).SetReply(query)
+	other := (&dns.Msg{}).SetQuestion("example.net.", dns.TypeA)
+
+	t.Run("pcap", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queries.pcap")
+		err := os.WriteFile(path, newTestPcap(
+			newTestPacket(t, query, 53),
+			newTestPacket(t, resp, 53),
+			newTestPacket(t, other, 5353),
+		), 0o600)
+		require.NoError(t, err)
+
+		msgs, err := readReplayFile(path)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+		assert.Equal(t, query.Question, msgs[0].Question)
+	})
+
+	t.Run("hex", func(t *testing.T) {
+		b, err := query.Pack()
+		require.NoError(t, err)
+
+		content := "# queries\n" + hex.EncodeToString(b) + "\n\n"
+		path := filepath.Join(t.TempDir(), "queries.txt")
+		err = os.WriteFile(path, []byte(content), 0o600)
+		require.NoError(t, err)
+
+		msgs, err := readReplayFile(path)
+		require.NoError(t, err)
+		require.Len(t, msgs, 1)
+		assert.Equal(t, query.Question, msgs[0].Question)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queries.pcap")
+		err := os.WriteFile(path, newTestPcap(newTestPacket(t, resp, 53)), 0o600)
+		require.NoError(t, err)
+
+		_, err = readReplayFile(path)
+		assert.Error(t, err)
+	})
+
+	t.Run("bad_hex", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queries.txt")
+		err := os.WriteFile(path, []byte("zz\n"), 0o600)
+		require.NoError(t, err)
+
+		_, err = readReplayFile(path)
+		assert.Error(t, err)
+	})
+}
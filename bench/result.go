@@ -0,0 +1,224 @@
+package bench
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Result is the summary of the test results.  All durations are in
+// milliseconds.
+type Result struct {
+	// options are the options the test has been run with.
+	options *Options
+
+	// state is the final state of the test used for the detailed summary.
+	state *runState
+
+	// Address is the address of the tested DNS server.
+	Address string `json:"address"`
+
+	// Elapsed is the overall duration of the test.
+	Elapsed float64 `json:"elapsed_ms"`
+
+	// QPS is the average number of queries per second.
+	QPS float64 `json:"qps"`
+
+	// Processed is the number of successfully processed queries.
+	Processed int `json:"processed"`
+
+	// Errors is the number of failed queries.
+	Errors int `json:"errors"`
+
+	// AveragePerQuery is the average elapsed time per query.
+	AveragePerQuery float64 `json:"average_per_query_ms"`
+
+	// LatencyMin is the minimum query latency.
+	LatencyMin float64 `json:"latency_min_ms"`
+
+	// LatencyAverage is the average query latency.
+	LatencyAverage float64 `json:"latency_average_ms"`
+
+	// LatencyP50 is the 50th percentile of the query latency.
+	LatencyP50 float64 `json:"latency_p50_ms"`
+
+	// LatencyP90 is the 90th percentile of the query latency.
+	LatencyP90 float64 `json:"latency_p90_ms"`
+
+	// LatencyP99 is the 99th percentile of the query latency.
+	LatencyP99 float64 `json:"latency_p99_ms"`
+
+	// LatencyMax is the maximum query latency.
+	LatencyMax float64 `json:"latency_max_ms"`
+
+	// LatencyStdDev is the standard deviation of the query latency.
+	LatencyStdDev float64 `json:"latency_stddev_ms"`
+
+	// LatencyJitter is the mean absolute difference between the latencies of
+	// the consecutive queries.
+	LatencyJitter float64 `json:"latency_jitter_ms"`
+
+	// BytesSent is the total wire size of the queries sent.
+	BytesSent int `json:"bytes_sent"`
+
+	// BytesReceived is the total wire size of the responses received.
+	BytesReceived int `json:"bytes_received"`
+
+	// Rcodes is the number of responses per response code name.
+	Rcodes map[string]int `json:"rcodes"`
+
+	// NoData is the number of NOERROR responses without answer records.
+	NoData int `json:"nodata"`
+
+	// ErrorCategories is the number of errors per category.
+	ErrorCategories map[string]int `json:"error_categories"`
+
+	// ConnectionsOpened is the number of connections opened including the
+	// ones re-created after errors.
+	ConnectionsOpened int `json:"connections_opened"`
+
+	// Targets are the results per server if the queries are split between
+	// multiple servers.
+	Targets []*TargetResult `json:"targets,omitempty"`
+}
+
+// newResult creates the summary of the test run with options from its final
+// state.
+func newResult(options *Options, state *runState) (res *Result) {
+	rcodes := make(map[string]int, len(state.rcodes))
+	for code, n := range state.rcodes {
+		rcodes[rcodeToString(code)] = n
+	}
+
+	categories := make(map[string]int, len(state.errorCategories))
+	for c, n := range state.errorCategories {
+		categories[string(c)] = n
+	}
+
+	processed, errs := state.counts()
+
+	return &Result{
+		options:           options,
+		state:             state,
+		Address:           cmp.Or(options.Address, options.Split),
+		Elapsed:           milliseconds(state.elapsed()),
+		QPS:               state.qpsTotal(),
+		Processed:         processed,
+		Errors:            errs,
+		AveragePerQuery:   milliseconds(state.elapsedPerQuery()),
+		LatencyMin:        milliseconds(state.latency.minimum()),
+		LatencyAverage:    milliseconds(state.latency.average()),
+		LatencyP50:        milliseconds(state.latency.percentile(50)),
+		LatencyP90:        milliseconds(state.latency.percentile(90)),
+		LatencyP99:        milliseconds(state.latency.percentile(99)),
+		LatencyMax:        milliseconds(state.latency.maximum()),
+		LatencyStdDev:     milliseconds(state.latency.stdDev()),
+		LatencyJitter:     milliseconds(state.latency.jitter()),
+		BytesSent:         state.bytesSent,
+		BytesReceived:     state.bytesReceived,
+		Rcodes:            rcodes,
+		NoData:            state.noData,
+		ErrorCategories:   categories,
+		ConnectionsOpened: state.upstreams,
+		Targets:           newTargetResults(state),
+	}
+}
+
+// WriteJSON writes the machine-readable summary of the test results to the
+// file at path.
+func (res *Result) WriteJSON(path string) (err error) {
+	b, err := json.MarshalIndent(res, "", "    ")
+	if err != nil {
+		return fmt.Errorf("encoding results: %w", err)
+	}
+
+	b = append(b, '\n')
+
+	err = os.WriteFile(path, b, 0o644)
+	if err != nil {
+		return fmt.Errorf("writing results: %w", err)
+	}
+
+	return nil
+}
+
+// milliseconds returns d as a floating-point number of milliseconds.
+func milliseconds(d time.Duration) (ms float64) {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WritePrometheus atomically writes the test results to the file at path in
+// the Prometheus text exposition format, so that it could be collected by the
+// node_exporter's textfile collector.
+func (res *Result) WritePrometheus(path string) (err error) {
+	state := res.state
+	processed, errs := state.counts()
+
+	b := &strings.Builder{}
+	writeMetric(b, "dnsbench_queries_total", "counter", "The number of successfully processed queries.", processed)
+	writeMetric(b, "dnsbench_errors_total", "counter", "The number of failed queries.", errs)
+	writeMetric(b, "dnsbench_qps", "gauge", "The average number of queries per second.", state.qpsTotal())
+
+	const name = "dnsbench_latency_seconds"
+	fmt.Fprintf(b, "# HELP %s The latency of the successfully processed queries.\n", name)
+	fmt.Fprintf(b, "# TYPE %s summary\n", name)
+	for _, q := range []float64{0.5, 0.9, 0.99} {
+		fmt.Fprintf(b, "%s{quantile=\"%g\"} %g\n", name, q, state.latency.percentile(q*100).Seconds())
+	}
+	fmt.Fprintf(b, "%s_sum %g\n", name, state.latency.total.Seconds())
+	fmt.Fprintf(b, "%s_count %d\n", name, state.latency.count())
+
+	return writeFileAtomic(path, []byte(b.String()))
+}
+
+// writeMetric writes a single Prometheus metric with its metadata to b.
+func writeMetric(b *strings.Builder, name, typ, help string, value any) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, typ)
+	fmt.Fprintf(b, "%s %v\n", name, value)
+}
+
+// writeFileAtomic writes data to a temporary file and then renames it to path,
+// so that readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+
+	tmpPath := f.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	_, err = f.Write(data)
+	if err != nil {
+		_ = f.Close()
+
+		return fmt.Errorf("writing temporary file: %w", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("closing temporary file: %w", err)
+	}
+
+	// Make the file readable by the collector, CreateTemp uses 0600.
+	err = os.Chmod(tmpPath, 0o644)
+	if err != nil {
+		return fmt.Errorf("changing file mode: %w", err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("renaming temporary file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package bench
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResult_WriteJSON(t *testing.T) {
+	state := &runState{
+		startTime:     time.Now().Add(-time.Second),
+		processed:     2,
+		errors:        1,
+		bytesSent:     87,
+		bytesReceived: 120,
+	}
+	state.latency.add(10 * time.Millisecond)
+	state.latency.add(30 * time.Millisecond)
+
+	path := filepath.Join(t.TempDir(), "results.json")
+	err := newResult(&Options{Address: "8.8.8.8"}, state).WriteJSON(path)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	res := &Result{}
+	err = json.Unmarshal(b, res)
+	require.NoError(t, err)
+
+	assert.Equal(t, "8.8.8.8", res.Address)
+	assert.Equal(t, 2, res.Processed)
+	assert.Equal(t, 1, res.Errors)
+	assert.InEpsilon(t, 10.0, res.LatencyP50, latencyEpsilon)
+	assert.Equal(t, 30.0, res.LatencyMax)
+	assert.Equal(t, 10.0, res.LatencyStdDev)
+	assert.Equal(t, 20.0, res.LatencyJitter)
+	assert.Equal(t, 87, res.BytesSent)
+	assert.Equal(t, 120, res.BytesReceived)
+	assert.GreaterOrEqual(t, res.Elapsed, 1000.0)
+}
+
+func TestResult_WritePrometheus(t *testing.T) {
+	state := &runState{
+		startTime: time.Now().Add(-time.Second),
+		processed: 2,
+		errors:    1,
+	}
+	state.latency.add(10 * time.Millisecond)
+	state.latency.add(30 * time.Millisecond)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dnsbench.prom")
+	err := newResult(&Options{}, state).WritePrometheus(path)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	s := string(b)
+	assert.Contains(t, s, "dnsbench_queries_total 2\n")
+	assert.Contains(t, s, "dnsbench_errors_total 1\n")
+	assert.Contains(t, s, "dnsbench_latency_seconds{quantile=\"0.5\"} 0.010")
+	assert.Contains(t, s, "dnsbench_latency_seconds{quantile=\"0.99\"} 0.03\n")
+	assert.Contains(t, s, "dnsbench_latency_seconds_count 2\n")
+
+	// Make sure that no temporary files are left.
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,229 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+const (
+	// scaleStepDuration is the default duration of every test run when
+	// searching for the maximum QPS.
+	scaleStepDuration = 5 * time.Second
+
+	// scaleMaxErrorRate is the default percentage of failed queries above
+	// which the number of connections is considered too high.
+	scaleMaxErrorRate = 1.0
+
+	// scaleMinGain is the minimum relative QPS improvement for the larger
+	// number of connections to be considered better.
+	scaleMinGain = 0.05
+
+	// scaleMaxConnections is the number of connections after which the
+	// search stops doubling them.
+	scaleMaxConnections = 4096
+
+	// scaleRefineSteps is the maximum number of the test runs that narrow
+	// down the number of connections after the doubling has stopped.
+	scaleRefineSteps = 3
+)
+
+// RunFindMaxQPS searches for the number of connections giving the highest QPS.
+// It runs the test for a short duration doubling the number of connections
+// starting from options.Connections until the QPS stops improving or the error
+// rate becomes too high, and then bisects the last interval.  It returns the
+// results of every run in order and the best one, which is nil if none of the
+// runs had an acceptable error rate.  See [Run] for snapshots.
+func RunFindMaxQPS(
+	ctx context.Context,
+	options *Options,
+	snapshots <-chan os.Signal,
+) (steps []*Result, best *Result, err error) {
+	switch {
+	case options.hasOutputFiles():
+		return nil, nil, errors.Error("--find-max-qps can't be used with --json-output, --prometheus-output, --jsonl-output, --csv or --timeseries-csv")
+	case options.AddressB != "" || options.AddressFile != "":
+		return nil, nil, errors.Error("--find-max-qps can't be used with --address-b or --address-file")
+	case options.OpenModel:
+		return nil, nil, errors.Error("--find-max-qps can't be used with --open-model")
+	case options.DryRun:
+		return nil, nil, errors.Error("--find-max-qps can't be used with --dry-run")
+	}
+
+	// The intermediate results of the runs would be hard to tell apart.
+	stepOptions := *options
+	stepOptions.FindMaxQPS = false
+	stepOptions.Quiet = true
+	stepOptions.QueriesCount = 0
+	if stepOptions.Duration <= 0 {
+		stepOptions.Duration = scaleStepDuration
+	}
+
+	// Use the same random names and choices in all runs.
+	if stepOptions.Seed == 0 {
+		stepOptions.Seed = time.Now().UnixNano()
+	}
+
+	maxErrorRate := scaleMaxErrorRate
+	if options.FailOver != nil {
+		maxErrorRate = *options.FailOver
+	}
+
+	s := &scaleSearch{
+		snapshots:    snapshots,
+		options:      stepOptions,
+		maxErrorRate: maxErrorRate,
+	}
+
+	conns := max(options.Connections, 1)
+	worse := 0
+	for {
+		var res *Result
+		res, err = s.try(ctx, conns)
+		if err != nil || ctx.Err() != nil {
+			return s.steps, s.best, err
+		}
+
+		if !s.improves(res) {
+			worse = conns
+
+			break
+		}
+
+		s.best = res
+		if conns >= scaleMaxConnections {
+			return s.steps, s.best, nil
+		}
+
+		conns = min(conns*2, scaleMaxConnections)
+	}
+
+	if s.best == nil {
+		return s.steps, nil, nil
+	}
+
+	// The best number of connections is somewhere between the best and the
+	// worse ones.
+	better := s.best.options.Connections
+	for i := 0; i < scaleRefineSteps && worse-better > 1; i++ {
+		conns = (better + worse) / 2
+
+		var res *Result
+		res, err = s.try(ctx, conns)
+		if err != nil || ctx.Err() != nil {
+			return s.steps, s.best, err
+		}
+
+		if s.improves(res) {
+			s.best, better = res, conns
+		} else {
+			worse = conns
+		}
+	}
+
+	return s.steps, s.best, nil
+}
+
+// scaleSearch is the state of the search for the maximum QPS.
+type scaleSearch struct {
+	// best is the result of the best run so far.  It's nil if no run had an
+	// acceptable error rate.
+	best *Result
+
+	// steps are the results of all runs in order.
+	steps []*Result
+
+	// snapshots is passed to every run, see [Run].
+	snapshots <-chan os.Signal
+
+	// options are the options for every run, the number of connections is
+	// overridden.
+	options Options
+
+	// maxErrorRate is the percentage of failed queries above which the result
+	// of a run is unacceptable.
+	maxErrorRate float64
+}
+
+// try runs the test with conns connections and records its result.
+func (s *scaleSearch) try(ctx context.Context, conns int) (res *Result, err error) {
+	o := s.options
+	o.Connections = conns
+	if len(s.steps) > 0 {
+		// The process-wide settings are applied by the first run.
+		o.CPUAffinity = ""
+	}
+
+	log.Info("Testing %d connections for %s", conns, o.Duration)
+
+	state, err := run(ctx, &o, s.snapshots)
+	if err != nil {
+		return nil, fmt.Errorf("testing %d connections: %w", conns, err)
+	}
+
+	res = newResult(&o, state)
+	s.steps = append(s.steps, res)
+
+	log.Info(
+		"%d connections: %.2f QPS, error rate %.2f%%",
+		conns,
+		state.qpsTotal(),
+		state.errorRate(),
+	)
+
+	return res, nil
+}
+
+// improves returns true if res has an acceptable error rate and its QPS is
+// noticeably higher than the one of the best run.
+func (s *scaleSearch) improves(res *Result) (ok bool) {
+	if res.state.errorRate() > s.maxErrorRate {
+		return false
+	}
+
+	return s.best == nil || res.state.qpsTotal() > s.best.state.qpsTotal()*(1+scaleMinGain)
+}
+
+// PrintFindMaxQPS logs the results of the runs made when searching for the
+// maximum QPS and the best number of connections.
+func PrintFindMaxQPS(steps []*Result, best *Result) {
+	b := &strings.Builder{}
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "Connections\tProcessed\tErrors\tQPS\tError rate\tAverage\tp99")
+	for _, res := range steps {
+		s := res.state
+		processed, errs := s.counts()
+		_, _ = fmt.Fprintf(
+			w,
+			"%d\t%d\t%d\t%.2f\t%.2f%%\t%s\t%s\n",
+			res.options.Connections,
+			processed,
+			errs,
+			s.qpsTotal(),
+			s.errorRate(),
+			s.latency.average(),
+			s.latency.percentile(99),
+		)
+	}
+	_ = w.Flush()
+
+	log.Info("The results per number of connections are:\n%s", strings.TrimSuffix(b.String(), "\n"))
+
+	if best == nil {
+		log.Info("None of the tests had an acceptable error rate")
+
+		return
+	}
+
+	log.Info(
+		"The optimal number of connections is %d with the peak QPS of %.2f",
+		best.options.Connections,
+		best.state.qpsTotal(),
+	)
+}
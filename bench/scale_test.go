@@ -0,0 +1,104 @@
+package bench
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunFindMaxQPS(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// The server answers one query at a time, so more connections don't
+	// improve the QPS.
+	var mu sync.Mutex
+	srv := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			resp := &dns.Msg{}
+			resp.SetReply(req)
+			_ = w.WriteMsg(resp)
+		}),
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+
+	o := &Options{
+		Address:     pc.LocalAddr().String(),
+		Connections: 1,
+		Query:       "example.org",
+		Timeout:     1,
+		Duration:    300 * time.Millisecond,
+	}
+
+	steps, best, err := RunFindMaxQPS(context.Background(), o, nil)
+	require.NoError(t, err)
+	require.NotNil(t, best)
+	require.GreaterOrEqual(t, len(steps), 2)
+	require.Contains(t, steps, best)
+
+	require.Equal(t, o.Connections, steps[0].options.Connections)
+	require.Equal(t, 2*o.Connections, steps[1].options.Connections)
+	require.Less(t, best.options.Connections, scaleMaxConnections)
+
+	PrintFindMaxQPS(steps, best)
+}
+
+func TestRunFindMaxQPS_errors(t *testing.T) {
+	// Nothing listens on the port, so every query fails right away.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	o := &Options{
+		Address:     "tcp://" + addr,
+		Connections: 1,
+		Query:       "example.org",
+		Timeout:     1,
+		Duration:    100 * time.Millisecond,
+	}
+
+	steps, best, err := RunFindMaxQPS(context.Background(), o, nil)
+	require.NoError(t, err)
+	require.Nil(t, best)
+	require.Len(t, steps, 1)
+
+	PrintFindMaxQPS(steps, best)
+}
+
+func TestRunFindMaxQPS_invalidOptions(t *testing.T) {
+	o := &Options{
+		Address:     "127.0.0.1",
+		AddressB:    "127.0.0.2",
+		Connections: 1,
+	}
+
+	_, _, err := RunFindMaxQPS(context.Background(), o, nil)
+	require.Error(t, err)
+}
+
+func TestRunFindMaxQPS_outputFiles(t *testing.T) {
+	o := &Options{
+		Address:       "127.0.0.1",
+		Connections:   1,
+		TimeseriesCSV: filepath.Join(t.TempDir(), "timeseries.csv"),
+	}
+
+	_, _, err := RunFindMaxQPS(context.Background(), o, nil)
+	require.ErrorContains(t, err, "--timeseries-csv")
+}
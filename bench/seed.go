@@ -0,0 +1,35 @@
+package bench
+
+// seedPurpose tells apart the random number generators derived from the seed
+// of the test, see [subSeed].
+type seedPurpose uint64
+
+// Valid seedPurpose values.
+const (
+	// seedPurposeJitter is the purpose of the generator of the rate limit
+	// jitter.
+	seedPurposeJitter seedPurpose = iota + 1
+
+	// seedPurposeWorker is the purpose of the generators of the workers, see
+	// [runState.workerRand].
+	seedPurposeWorker
+)
+
+// subSeed returns the seed of the generator with purpose derived from the
+// seed of the test.  idx tells apart the generators with the same purpose.  The
+// values are mixed, so that the streams of the derived generators don't repeat
+// the ones of the main generator or of the tests with the adjacent seeds.
+func subSeed(seed int64, purpose seedPurpose, idx int64) (res int64) {
+	x := splitmix64(uint64(seed) ^ splitmix64(uint64(purpose)))
+
+	return int64(splitmix64(x + uint64(idx)))
+}
+
+// splitmix64 returns the output of the SplitMix64 generator for the state x.
+func splitmix64(x uint64) (res uint64) {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+
+	return x ^ x>>31
+}
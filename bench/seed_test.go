@@ -0,0 +1,52 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubSeed(t *testing.T) {
+	const seed = 42
+
+	seeds := map[int64]struct{}{seed: {}, seed + 1: {}}
+	for _, s := range []int64{
+		subSeed(seed, seedPurposeJitter, 0),
+		subSeed(seed, seedPurposeJitter, 1),
+		subSeed(seed+1, seedPurposeJitter, 0),
+	} {
+		assert.NotContains(t, seeds, s)
+		seeds[s] = struct{}{}
+	}
+
+	// The derivation is reproducible.
+	assert.Equal(t, subSeed(seed, seedPurposeJitter, 0), subSeed(seed, seedPurposeJitter, 0))
+
+	// The jitter doesn't repeat the stream of the main generator.
+	main, jitter := newRand(seed), newRand(subSeed(seed, seedPurposeJitter, 0))
+	assert.NotEqual(t, main.Int63(), jitter.Int63())
+}
+
+func TestRunState_workerRand(t *testing.T) {
+	const seed = 42
+
+	s := &runState{seed: seed}
+	next := &runState{seed: seed + 1}
+
+	values := map[int64]string{
+		newRand(seed).Int63():     "main",
+		newRand(seed + 1).Int63(): "main of next seed",
+	}
+
+	// The labels use the worker ID of -1.
+	for _, id := range []int{-1, 0, 1} {
+		for name, r := range map[string]*runState{"seed": s, "next seed": next} {
+			v := r.workerRand(id).Int63()
+			assert.NotContains(t, values, v, "%s worker %d", name, id)
+			values[v] = name
+		}
+	}
+
+	// The streams are reproducible.
+	assert.Equal(t, s.workerRand(0).Int63(), (&runState{seed: seed}).workerRand(0).Int63())
+}
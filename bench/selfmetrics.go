@@ -0,0 +1,101 @@
+package bench
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// selfMetrics is the resource usage of the benchmark process itself.
+type selfMetrics struct {
+	// heapInUse is the size of the heap spans in use in bytes.
+	heapInUse uint64
+
+	// sys is the memory obtained from the OS in bytes.
+	sys uint64
+
+	// goroutines is the number of goroutines.
+	goroutines int
+
+	// fds is the number of open file descriptors.  It's negative if it's not
+	// known on this platform.
+	fds int
+
+	// gcCycles is the number of completed GC cycles.
+	gcCycles uint32
+}
+
+// readSelfMetrics returns the current resource usage of the process.
+func readSelfMetrics() (m selfMetrics) {
+	ms := &runtime.MemStats{}
+	runtime.ReadMemStats(ms)
+
+	return selfMetrics{
+		heapInUse:  ms.HeapInuse,
+		sys:        ms.Sys,
+		goroutines: runtime.NumGoroutine(),
+		fds:        openFDs(),
+		gcCycles:   ms.NumGC,
+	}
+}
+
+// String implements the [fmt.Stringer] interface for selfMetrics.
+func (m selfMetrics) String() (s string) {
+	fds := "unknown"
+	if m.fds >= 0 {
+		fds = fmt.Sprint(m.fds)
+	}
+
+	return fmt.Sprintf(
+		"goroutines: %d, heap in use: %.1f MiB, memory from the OS: %.1f MiB, GC cycles: %d, open file descriptors: %s",
+		m.goroutines,
+		float64(m.heapInUse)/(1<<20),
+		float64(m.sys)/(1<<20),
+		m.gcCycles,
+		fds,
+	)
+}
+
+// peak returns the maximum values of m and other.
+func (m selfMetrics) peak(other selfMetrics) (res selfMetrics) {
+	return selfMetrics{
+		heapInUse:  max(m.heapInUse, other.heapInUse),
+		sys:        max(m.sys, other.sys),
+		goroutines: max(m.goroutines, other.goroutines),
+		fds:        max(m.fds, other.fds),
+		gcCycles:   max(m.gcCycles, other.gcCycles),
+	}
+}
+
+// logSelfMetrics logs the resource usage of the process every interval and
+// records its peak in state.  It returns when done is closed.
+func logSelfMetrics(state *runState, interval time.Duration, done <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			m := readSelfMetrics()
+			log.Info("Self metrics: %s", m)
+
+			state.addSelfMetrics(m)
+		}
+	}
+}
+
+// addSelfMetrics records m in the peak resource usage of the process.
+func (r *runState) addSelfMetrics(m selfMetrics) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	r.selfPeak = r.selfPeak.peak(m)
+}
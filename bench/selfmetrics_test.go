@@ -0,0 +1,64 @@
+package bench
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSelfMetrics(t *testing.T) {
+	m := readSelfMetrics()
+
+	assert.Positive(t, m.goroutines)
+	assert.Positive(t, m.heapInUse)
+	assert.GreaterOrEqual(t, m.sys, m.heapInUse)
+
+	if runtime.GOOS == "linux" {
+		assert.Positive(t, m.fds)
+	} else {
+		assert.Equal(t, -1, m.fds)
+	}
+}
+
+func TestSelfMetrics_peak(t *testing.T) {
+	a := selfMetrics{heapInUse: 10, sys: 30, goroutines: 5, fds: 7, gcCycles: 1}
+	b := selfMetrics{heapInUse: 20, sys: 25, goroutines: 3, fds: 9, gcCycles: 2}
+
+	want := selfMetrics{heapInUse: 20, sys: 30, goroutines: 5, fds: 9, gcCycles: 2}
+	assert.Equal(t, want, a.peak(b))
+	assert.Equal(t, want, b.peak(a))
+}
+
+func Test_runSelfMetrics(t *testing.T) {
+	addr := startUDPServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		_ = w.WriteMsg(resp)
+	})
+
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	o := &Options{
+		Address:      addr.String(),
+		Connections:  2,
+		Query:        "example.org",
+		Timeout:      10,
+		Rate:         50,
+		QueriesCount: 10,
+		SelfMetrics:  50 * time.Millisecond,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Positive(t, state.selfPeak.goroutines)
+	require.Contains(t, out.String(), "Self metrics: goroutines: ")
+}
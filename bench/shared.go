@@ -0,0 +1,58 @@
+package bench
+
+import (
+	"context"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+)
+
+// sharedUpstream is the upstream shared by all connections, so that the
+// queries are multiplexed over its own connections.  Closing it is a no-op,
+// since the connections close and re-create their upstreams after errors, the
+// underlying upstream is closed once the test is finished.
+type sharedUpstream struct {
+	upstream.Upstream
+}
+
+// type check
+var (
+	_ upstream.Upstream = (*sharedUpstream)(nil)
+	_ contextExchanger  = (*sharedUpstream)(nil)
+)
+
+// ExchangeContext implements the [contextExchanger] interface for
+// *sharedUpstream.  ctx must have a deadline, which is the case for the
+// queries sent with [exchangeTimeout].
+func (u *sharedUpstream) ExchangeContext(
+	ctx context.Context,
+	req *dns.Msg,
+) (resp *dns.Msg, err error) {
+	deadline, _ := ctx.Deadline()
+
+	return exchangeTimeout(ctx, u.Upstream, req, time.Until(deadline))
+}
+
+// Close implements the [upstream.Upstream] interface for *sharedUpstream.
+func (u *sharedUpstream) Close() (err error) {
+	return nil
+}
+
+// isConcurrentUpstream returns true if the upstream created for options is safe
+// for concurrent use.  Our own clients using a single connection aren't.
+func isConcurrentUpstream(options *Options) (ok bool) {
+	addr := options.Address
+	switch {
+	case isUnixAddress(addr), options.QUIC0RTT && isDoQAddress(addr):
+		return false
+	case options.LateWait > 0, options.LocalAddress != "":
+		return false
+	case options.Questions > 1 && isPlainUDPAddress(addr):
+		return false
+	case isDoTAddress(addr) && (options.TLSResumption != "" || options.ConnectTimeout > 0):
+		return false
+	default:
+		return true
+	}
+}
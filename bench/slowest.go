@@ -0,0 +1,142 @@
+package bench
+
+import (
+	"cmp"
+	"container/heap"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// slowQuery is a successfully processed query kept among the slowest ones.
+type slowQuery struct {
+	// hostname is the domain name of the query.
+	hostname string
+
+	// latency is the time it took to get the response.
+	latency time.Duration
+
+	// qtype is the type of the query.
+	qtype uint16
+
+	// rcode is the response code.
+	rcode int
+}
+
+// slowQueryHeap is a min-heap of the queries by their latency, so that the
+// fastest of the kept queries is the first to be replaced.
+type slowQueryHeap []*slowQuery
+
+// type check
+var _ heap.Interface = (*slowQueryHeap)(nil)
+
+// Len implements the [heap.Interface] interface for *slowQueryHeap.
+func (h *slowQueryHeap) Len() (n int) { return len(*h) }
+
+// Less implements the [heap.Interface] interface for *slowQueryHeap.
+func (h *slowQueryHeap) Less(i, j int) (ok bool) { return (*h)[i].latency < (*h)[j].latency }
+
+// Swap implements the [heap.Interface] interface for *slowQueryHeap.
+func (h *slowQueryHeap) Swap(i, j int) { (*h)[i], (*h)[j] = (*h)[j], (*h)[i] }
+
+// Push implements the [heap.Interface] interface for *slowQueryHeap.
+func (h *slowQueryHeap) Push(x any) { *h = append(*h, x.(*slowQuery)) }
+
+// Pop implements the [heap.Interface] interface for *slowQueryHeap.
+func (h *slowQueryHeap) Pop() (x any) {
+	old := *h
+	x, *h = old[len(old)-1], old[:len(old)-1]
+
+	return x
+}
+
+// slowestQueries keeps the n slowest successfully processed queries of the
+// test, so the memory it uses doesn't grow with the number of queries.  A nil
+// *slowestQueries is a no-op.
+type slowestQueries struct {
+	// queries are the slowest queries so far.
+	queries slowQueryHeap
+
+	// n is the maximum number of the queries to keep.
+	n int
+
+	// mu protects queries.
+	mu sync.Mutex
+}
+
+// newSlowestQueries returns a new *slowestQueries keeping n queries.  It
+// returns nil if n is zero.
+func newSlowestQueries(n int) (s *slowestQueries) {
+	if n == 0 {
+		return nil
+	}
+
+	return &slowestQueries{
+		queries: make(slowQueryHeap, 0, n),
+		n:       n,
+	}
+}
+
+// add records the query for hostname of type qtype answered with rcode in d.
+func (s *slowestQueries) add(hostname string, qtype uint16, rcode int, d time.Duration) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.queries) < s.n {
+		heap.Push(&s.queries, &slowQuery{hostname: hostname, latency: d, qtype: qtype, rcode: rcode})
+
+		return
+	}
+
+	if d <= s.queries[0].latency {
+		return
+	}
+
+	// Reuse the fastest of the kept queries for the new one.
+	q := s.queries[0]
+	q.hostname, q.latency, q.qtype, q.rcode = hostname, d, qtype, rcode
+	heap.Fix(&s.queries, 0)
+}
+
+// sorted returns the kept queries from the slowest to the fastest.
+func (s *slowestQueries) sorted() (queries []*slowQuery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queries = slices.Clone(s.queries)
+	slices.SortFunc(queries, func(a, b *slowQuery) (res int) {
+		return cmp.Compare(b.latency, a.latency)
+	})
+
+	return queries
+}
+
+// String implements the [fmt.Stringer] interface for *slowestQueries.  It
+// returns a table of the kept queries from the slowest to the fastest.
+func (s *slowestQueries) String() (str string) {
+	b := &strings.Builder{}
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "Name\tType\tLatency\tRcode")
+	for _, q := range s.sorted() {
+		_, _ = fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\n",
+			q.hostname,
+			dns.Type(q.qtype),
+			q.latency,
+			rcodeToString(q.rcode),
+		)
+	}
+	_ = w.Flush()
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
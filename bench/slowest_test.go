@@ -0,0 +1,82 @@
+package bench
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowestQueries(t *testing.T) {
+	s := newSlowestQueries(3)
+	for i, ms := range []int{5, 1, 9, 3, 7, 2} {
+		s.add(string(rune('a'+i))+".example.", dns.TypeA, dns.RcodeSuccess, time.Duration(ms)*time.Millisecond)
+	}
+
+	var names []string
+	for _, q := range s.sorted() {
+		names = append(names, q.hostname)
+	}
+
+	assert.Equal(t, []string{"c.example.", "e.example.", "a.example."}, names)
+
+	t.Run("nil", func(t *testing.T) {
+		var nilSlowest *slowestQueries
+		assert.NotPanics(t, func() {
+			nilSlowest.add("example.org.", dns.TypeA, dns.RcodeSuccess, time.Second)
+		})
+		assert.Nil(t, newSlowestQueries(0))
+	})
+}
+
+func Test_runSlowest(t *testing.T) {
+	addr := startUDPServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		if req.Question[0].Name == "slow.example." {
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		resp := &dns.Msg{}
+		resp.SetRcode(req, dns.RcodeNameError)
+		_ = w.WriteMsg(resp)
+	})
+
+	path := filepath.Join(t.TempDir(), "queries.txt")
+	err := os.WriteFile(path, []byte("fast.example\nslow.example\n"), 0o644)
+	require.NoError(t, err)
+
+	o := &Options{
+		Address:      addr.String(),
+		Connections:  1,
+		QueriesPath:  path,
+		Timeout:      10,
+		QueriesCount: 10,
+		Slowest:      2,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	newResult(o, state).Print()
+
+	out := buf.String()
+	require.Contains(t, out, "Slowest queries:")
+
+	_, table, _ := strings.Cut(out, "Slowest queries:")
+	lines := strings.Split(strings.TrimSpace(table), "\n")
+	require.Greater(t, len(lines), 2)
+
+	assert.Contains(t, lines[1], "slow.example")
+	assert.Contains(t, lines[1], "NXDOMAIN")
+	assert.Contains(t, lines[2], "slow.example")
+}
@@ -0,0 +1,80 @@
+package bench
+
+import (
+	"os"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// printSnapshots prints the results of the test so far every time a value is
+// received from snapshots.  It returns when done is closed.
+func printSnapshots(state *runState, snapshots <-chan os.Signal, done <-chan bool) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-snapshots:
+			state.m.Lock()
+			if !state.finished {
+				state.printSnapshotLocked()
+			}
+			state.m.Unlock()
+		}
+	}
+}
+
+// fanOutSnapshots returns two channels, each of which receives every value
+// received from snapshots until done is closed.  The values aren't queued, so
+// the ones received while the previous one hasn't been handled yet are dropped.
+func fanOutSnapshots(snapshots <-chan os.Signal, done <-chan struct{}) (a, b <-chan os.Signal) {
+	outA := make(chan os.Signal, 1)
+	outB := make(chan os.Signal, 1)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-snapshots:
+				for _, out := range []chan os.Signal{outA, outB} {
+					select {
+					case out <- sig:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	return outA, outB
+}
+
+// printSnapshotLocked prints the number of queries, the QPS, and the latency
+// percentiles since the start of the test.  This method must be protected by
+// the mutex on the outside.
+func (r *runState) printSnapshotLocked() {
+	elapsed := r.elapsedLocked()
+	total := r.processed + r.errors
+
+	var qps float64
+	if elapsed > 0 {
+		qps = float64(total) / elapsed.Seconds()
+	}
+
+	var errorsPercent float64
+	if total > 0 {
+		errorsPercent = float64(r.errors) / float64(total) * 100
+	}
+
+	log.Info("Snapshot after %s", elapsed)
+	log.Info("Processed queries: %d", r.processed)
+	log.Info("Errors: %d (%.2f%%)", r.errors, errorsPercent)
+	log.Info("Average QPS: %f", qps)
+	log.Info("Latency average: %s", r.latency.average())
+	log.Info("Latency p50: %s", r.latency.percentile(50))
+	log.Info("Latency p90: %s", r.latency.percentile(90))
+	log.Info("Latency p99: %s", r.latency.percentile(99))
+	log.Info("Latency max: %s", r.latency.maximum())
+
+	printLastResponses(r.workers)
+}
@@ -0,0 +1,235 @@
+package bench
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// splitTarget is one of the servers the queries are split between.
+type splitTarget struct {
+	// options are the options of the test with the address of the server.
+	options *Options
+
+	// stats are the statistics of the queries sent to the server.
+	stats transportStats
+
+	// weight is the relative share of the queries sent to the server.
+	weight int
+
+	// current is the current weight of the smooth weighted round-robin.
+	current int
+}
+
+// newSplitTargets parses options.Split, which is a comma-separated list of
+// server addresses with their weights in the ADDR=WEIGHT form, and checks that
+// the other options are compatible with it.  The addresses are validated
+// unless it's disabled.  targets are nil if options.Split is empty.
+func newSplitTargets(options *Options) (targets []*splitTarget, err error) {
+	if options.Split == "" {
+		return nil, nil
+	}
+
+	if options.Address != "" || options.AddressB != "" {
+		return nil, errors.Error("--split can't be used with --address or --address-b")
+	}
+
+	// These options are only checked against a single server address, or the
+	// connections don't send the queries themselves.
+	if options.LateWait > 0 ||
+		options.ConnectTimeout > 0 ||
+		options.LocalAddress != "" ||
+		options.TLSResumption != "" ||
+		options.QUIC0RTT ||
+		options.TCPRatio > 0 ||
+		options.SharedUpstream ||
+		options.OpenModel ||
+		options.Warmup > 0 ||
+		options.DryRun {
+		return nil, errors.Error(
+			"--split can't be used with --late-wait, --connect-timeout, --local-address, " +
+				"--tls-resumption, --quic-0rtt, --tcp-ratio, --shared-upstream, --open-model, " +
+				"--warmup, or --dry-run",
+		)
+	}
+
+	for _, s := range strings.Split(options.Split, ",") {
+		s = strings.TrimSpace(s)
+
+		// The addresses may contain "=" in the query of DNS-over-HTTPS URLs.
+		i := strings.LastIndex(s, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q must be in the ADDR=WEIGHT form", s)
+		}
+
+		addr := s[:i]
+		weight, convErr := strconv.Atoi(s[i+1:])
+		if convErr != nil || weight <= 0 {
+			return nil, fmt.Errorf("weight of %s must be a positive integer, got %q", addr, s[i+1:])
+		}
+
+		err = checkAddress(options, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		o := *options
+		o.Address = addr
+		targets = append(targets, &splitTarget{options: &o, weight: weight})
+	}
+
+	if len(targets) < 2 {
+		return nil, errors.Error("at least two servers are required")
+	}
+
+	return targets, nil
+}
+
+// pickTargetLocked returns the index of the server the next query is sent to.
+// It uses the smooth weighted round-robin, so the queries to the servers are
+// interleaved and their shares match the weights exactly.  This method must be
+// protected by the mutex on the outside.
+func (r *runState) pickTargetLocked() (idx int) {
+	total := 0
+	for i, t := range r.targets {
+		t.current += t.weight
+		total += t.weight
+		if t.current > r.targets[idx].current {
+			idx = i
+		}
+	}
+
+	r.targets[idx].current -= total
+
+	return idx
+}
+
+// connectionOptions returns the options of the servers the queries of a single
+// connection are sent to.  It's options itself unless the queries are split
+// between multiple servers.
+func connectionOptions(options *Options, state *runState) (opts []*Options) {
+	if len(state.targets) == 0 {
+		return []*Options{options}
+	}
+
+	opts = make([]*Options, 0, len(state.targets))
+	for _, t := range state.targets {
+		opts = append(opts, t.options)
+	}
+
+	return opts
+}
+
+// addTargetResult records the outcome of a query sent to the server with index
+// idx.  failed is true if the query has failed, in which case d is ignored.
+func (r *runState) addTargetResult(idx int, d time.Duration, failed bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	s := &r.targets[idx].stats
+	if failed {
+		s.errors++
+	} else {
+		s.latency.add(d)
+	}
+}
+
+// hasPlainUDPTarget returns true if the queries are split between multiple
+// servers and one of them is a plain DNS address.
+func (r *runState) hasPlainUDPTarget() (ok bool) {
+	for _, t := range r.targets {
+		if isPlainUDPAddress(t.options.Address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// printSplitResults prints the results of the queries sent to every server the
+// queries are split between, one per line.
+func printSplitResults(state *runState) {
+	total := 0
+	for _, t := range state.targets {
+		total += t.weight
+	}
+
+	b := &strings.Builder{}
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "Address\tShare\tProcessed\tErrors\tAverage\tp50\tp90\tp99")
+	for _, t := range state.targets {
+		l := &t.stats.latency
+		_, _ = fmt.Fprintf(
+			w,
+			"%s\t%.2f%%\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			t.options.Address,
+			100*float64(t.weight)/float64(total),
+			l.count(),
+			t.stats.errors,
+			l.average(),
+			l.percentile(50),
+			l.percentile(90),
+			l.percentile(99),
+		)
+	}
+	_ = w.Flush()
+
+	log.Info("The results per server are:\n%s", strings.TrimSuffix(b.String(), "\n"))
+}
+
+// TargetResult is the summary of the queries sent to one of the servers the
+// queries are split between.  All durations are in milliseconds.
+type TargetResult struct {
+	// Address is the address of the server.
+	Address string `json:"address"`
+
+	// Weight is the relative share of the queries sent to the server.
+	Weight int `json:"weight"`
+
+	// Processed is the number of successfully processed queries.
+	Processed int `json:"processed"`
+
+	// Errors is the number of failed queries.
+	Errors int `json:"errors"`
+
+	// LatencyAverage is the average query latency.
+	LatencyAverage float64 `json:"latency_average_ms"`
+
+	// LatencyP50 is the 50th percentile of the query latency.
+	LatencyP50 float64 `json:"latency_p50_ms"`
+
+	// LatencyP90 is the 90th percentile of the query latency.
+	LatencyP90 float64 `json:"latency_p90_ms"`
+
+	// LatencyP99 is the 99th percentile of the query latency.
+	LatencyP99 float64 `json:"latency_p99_ms"`
+}
+
+// newTargetResults returns the summaries of the queries sent to every server
+// the queries are split between.  results are nil if they aren't split.
+func newTargetResults(state *runState) (results []*TargetResult) {
+	for _, t := range state.targets {
+		l := &t.stats.latency
+		results = append(results, &TargetResult{
+			Address:        t.options.Address,
+			Weight:         t.weight,
+			Processed:      l.count(),
+			Errors:         t.stats.errors,
+			LatencyAverage: milliseconds(l.average()),
+			LatencyP50:     milliseconds(l.percentile(50)),
+			LatencyP90:     milliseconds(l.percentile(90)),
+			LatencyP99:     milliseconds(l.percentile(99)),
+		})
+	}
+
+	return results
+}
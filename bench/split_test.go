@@ -0,0 +1,134 @@
+package bench
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSplitTargets(t *testing.T) {
+	testCases := []struct {
+		name       string
+		options    *Options
+		wantAddrs  []string
+		wantErrMsg string
+	}{{
+		name:      "valid",
+		options:   &Options{Split: "tls://dns.adguard-dns.com=70, 8.8.8.8=30"},
+		wantAddrs: []string{"tls://dns.adguard-dns.com", "8.8.8.8"},
+	}, {
+		name:      "doh_query",
+		options:   &Options{Split: "https://dns.example/dns-query?x=1=2,8.8.8.8=1"},
+		wantAddrs: []string{"https://dns.example/dns-query?x=1", "8.8.8.8"},
+	}, {
+		name:       "no_weight",
+		options:    &Options{Split: "8.8.8.8,1.1.1.1=1"},
+		wantErrMsg: `"8.8.8.8" must be in the ADDR=WEIGHT form`,
+	}, {
+		name:       "zero_weight",
+		options:    &Options{Split: "8.8.8.8=0,1.1.1.1=1"},
+		wantErrMsg: `weight of 8.8.8.8 must be a positive integer, got "0"`,
+	}, {
+		name:       "single",
+		options:    &Options{Split: "8.8.8.8=1"},
+		wantErrMsg: "at least two servers are required",
+	}, {
+		name:       "invalid_address",
+		options:    &Options{Split: "8.8.8.8=1,ftp://1.1.1.1=1"},
+		wantErrMsg: "server address ftp://1.1.1.1 is invalid",
+	}, {
+		name:       "address",
+		options:    &Options{Split: "8.8.8.8=1,1.1.1.1=1", Address: "8.8.4.4"},
+		wantErrMsg: "--split can't be used with --address or --address-b",
+	}, {
+		name:       "open_model",
+		options:    &Options{Split: "8.8.8.8=1,1.1.1.1=1", OpenModel: true},
+		wantErrMsg: "--split can't be used with",
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			targets, err := newSplitTargets(tc.options)
+			if tc.wantErrMsg != "" {
+				require.ErrorContains(t, err, tc.wantErrMsg)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			var addrs []string
+			for _, target := range targets {
+				addrs = append(addrs, target.options.Address)
+			}
+
+			assert.Equal(t, tc.wantAddrs, addrs)
+		})
+	}
+}
+
+func TestRunState_pickTargetLocked(t *testing.T) {
+	state := &runState{
+		targets: []*splitTarget{{weight: 3}, {weight: 1}},
+	}
+
+	var picks []int
+	for range 8 {
+		picks = append(picks, state.pickTargetLocked())
+	}
+
+	// The queries to the servers are interleaved.
+	assert.Equal(t, []int{0, 0, 1, 0, 0, 0, 1, 0}, picks)
+}
+
+func Test_runSplit(t *testing.T) {
+	var countA, countB atomic.Int32
+	newHandler := func(count *atomic.Int32) (h dns.HandlerFunc) {
+		return func(w dns.ResponseWriter, req *dns.Msg) {
+			count.Add(1)
+
+			resp := &dns.Msg{}
+			resp.SetReply(req)
+			_ = w.WriteMsg(resp)
+		}
+	}
+
+	addrA := startUDPServer(t, newHandler(&countA))
+	addrB := startUDPServer(t, newHandler(&countB))
+
+	o := &Options{
+		Split:        addrA.String() + "=70," + addrB.String() + "=30",
+		Connections:  2,
+		Query:        "example.org",
+		Timeout:      10,
+		QueriesCount: 100,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	assert.Equal(t, int32(70), countA.Load())
+	assert.Equal(t, int32(30), countB.Load())
+
+	// Every connection has an upstream per server.
+	assert.Equal(t, 4, state.upstreams)
+
+	res := newResult(o, state)
+	require.Len(t, res.Targets, 2)
+	assert.Equal(t, addrA.String(), res.Targets[0].Address)
+	assert.Equal(t, 70, res.Targets[0].Processed)
+	assert.Equal(t, 30, res.Targets[1].Processed)
+	assert.Equal(t, o.Split, res.Address)
+
+	t.Run("address_b", func(t *testing.T) {
+		invalid := *o
+		invalid.AddressB = addrB.String()
+
+		_, err := run(context.Background(), &invalid, nil)
+		require.ErrorContains(t, err, "--split can't be used with --address or --address-b")
+	})
+}
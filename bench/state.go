@@ -0,0 +1,1381 @@
+package bench
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"maps"
+	"math/rand"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"go.uber.org/ratelimit"
+)
+
+// runState represents the overall bench run state and is shared among each
+// worker goroutine.
+type runState struct {
+	// rate limits the queries per second.
+	rate ratelimit.Limiter
+
+	// startTime is the time when the test has been started.
+	startTime time.Time
+	// deadline is the time when the test should stop.  Zero means no
+	// deadline.
+	deadline time.Time
+	// endTime is the time when the test has finished.
+	endTime time.Time
+	// finished is true if the test has finished and the statistics must not
+	// be updated anymore.
+	finished bool
+	// processed is the number of queries successfully processed.
+	processed int
+	// errors is the number of queries that failed.
+	errors int
+
+	// latency is the latency of the successfully processed queries.
+	latency latencyStats
+
+	// latencyFirst is the latency of the first successful responses over every
+	// new connection, i.e. the time to establish the connection and to get the
+	// first response.
+	latencyFirst latencyStats
+
+	// latencyBuckets is the sorted list of the upper bounds of the latency
+	// histogram buckets.
+	latencyBuckets []time.Duration
+
+	// slowest keeps the slowest queries of the test.  It's nil unless
+	// --slowest is set.
+	slowest *slowestQueries
+
+	// workers is the statistics of every connection indexed by its number.
+	workers []workerStats
+
+	// rcodes is the number of responses per response code.
+	rcodes map[int]int
+
+	// noData is the number of NOERROR responses without answer records.
+	noData int
+
+	// errorCategories is the number of errors per category.
+	errorCategories map[errorCategory]int
+
+	// queriesCount is the overall number of queries to send.  It's ignored if
+	// unlimited is true.
+	queriesCount int
+
+	// unlimited is true if the queries are sent until the test is interrupted
+	// or the deadline is exceeded.
+	unlimited bool
+
+	// queriesSent is the number of queries sent.
+	queriesSent int
+
+	// hostnames is the list of hostnames to query.
+	hostnames []string
+
+	// replay is the list of DNS queries to send as is, hostnames contains
+	// their question names.  It's nil unless the queries are replayed from a
+	// file.
+	replay []*dns.Msg
+
+	// amplified is the list of hostnames to query in the amplify mode.  If
+	// it's set, it's used instead of hostnames.
+	amplified *amplifiedSchedule
+
+	// sampler picks the hostnames to query randomly if the queries file has
+	// weights.  If nil, hostnames are queried in order.
+	sampler *weightedSampler
+
+	// lastPair is the first query of the last pair in the CD A/B mode.
+	lastPair query
+
+	// qtype is the type of the DNS queries.
+	qtype uint16
+
+	// qclass is the class of the DNS queries.
+	qclass uint16
+
+	// ecs is the client subnet to send in the EDNS0 Client Subnet option.
+	ecs netip.Prefix
+
+	// qtypes is the list of query types to randomly pick from.  If set, it
+	// takes precedence over qtype.
+	qtypes []uint16
+
+	// sentQTypes is the number of queries sent per query type.
+	sentQTypes map[uint16]int
+
+	// cdAB is true if the CD bit should be alternated per query.
+	cdAB bool
+
+	// latencyValidated is the latency of the queries with the CD bit unset.
+	// It is only recorded in the CD A/B mode.
+	latencyValidated *latencyStats
+
+	// latencyUnvalidated is the latency of the queries with the CD bit set.
+	// It is only recorded in the CD A/B mode.
+	latencyUnvalidated *latencyStats
+
+	// tcpRatio is the fraction of the queries that should be sent over TCP.
+	tcpRatio float64
+
+	// statsUDP and statsTCP are the statistics of the queries sent over UDP
+	// and TCP.  They are only recorded if tcpRatio is positive.
+	statsUDP *transportStats
+	statsTCP *transportStats
+
+	// dohMethod is the HTTP method of the DNS-over-HTTPS queries, either GET
+	// or POST.
+	dohMethod string
+
+	// targets are the servers the queries are split between.  They are nil
+	// unless --split is set.
+	targets []*splitTarget
+
+	// trendWindow is the length of the time windows the results are bucketed
+	// into.  Zero disables it.
+	trendWindow time.Duration
+
+	// windows is the statistics of the queries sent in every time window
+	// since the start of the test.
+	windows []*windowStats
+
+	// late is the number of queries that were answered after the timeout.
+	late int
+
+	// sessionCache is the TLS session cache shared by all connections if the
+	// TLS session resumption is enabled.
+	sessionCache tls.ClientSessionCache
+
+	// shared is the upstream shared by all connections.  It's nil unless
+	// --shared-upstream is set.
+	shared *sharedUpstream
+
+	// quicTokens is the QUIC token store shared by all connections if 0-RTT
+	// is enabled for DNS-over-QUIC.
+	quicTokens quic.TokenStore
+
+	// resolver resolves the hostname of the server address.  nil means the
+	// system resolver.
+	resolver *net.Resolver
+
+	// tlsHandshakes is the number of the TLS handshakes performed.
+	tlsHandshakes int
+
+	// upstreams is the number of the upstreams created including the ones
+	// re-created after errors, i.e. the number of connections opened unless
+	// the protocol opens more of them by itself.
+	upstreams int
+
+	// tlsResumed is the number of the TLS handshakes that resumed a session.
+	tlsResumed int
+
+	// quic0RTT is the number of the DNS-over-QUIC queries sent in the 0-RTT
+	// data accepted by the server.
+	quic0RTT int
+
+	// quic1RTT is the number of the DNS-over-QUIC queries sent after the
+	// handshake, including the ones in quic0RTTRejected.
+	quic1RTT int
+
+	// quic0RTTRejected is the number of the DNS-over-QUIC queries sent once
+	// again after the server has rejected the 0-RTT data.
+	quic0RTTRejected int
+
+	// retried is the number of queries that succeeded only after a retry.
+	retried int
+
+	// truncated is the number of queries that were retried over TCP since the
+	// response over UDP was truncated.
+	truncated int
+
+	// inFlight is the number of queries sent that haven't been answered yet.
+	// It is only tracked in the open model.
+	inFlight int
+
+	// maxInFlight is the maximum of inFlight during the test.
+	maxInFlight int
+
+	// skipped is the number of queries in the open model that weren't sent
+	// since there were too many queries in flight.
+	skipped int
+
+	// latencyLate is the latency of the late responses.
+	latencyLate latencyStats
+
+	// paddedResponses is the number of responses that had the EDNS0 padding
+	// option.
+	paddedResponses int
+
+	// paddingBytes is the total length of the padding in the responses.
+	paddingBytes int
+
+	// keepaliveResponses is the number of responses that had the EDNS0 TCP
+	// keepalive option.
+	keepaliveResponses int
+
+	// keepaliveMin and keepaliveMax are the shortest and the longest idle
+	// timeouts advertised in the EDNS0 TCP keepalive option.
+	keepaliveMin time.Duration
+	keepaliveMax time.Duration
+
+	// idleExpired is the number of queries sent over a connection after it
+	// has been idle for longer than the timeout advertised by the server.
+	idleExpired int
+
+	// idleExpiredErrors is the number of idleExpired queries that have failed.
+	idleExpiredErrors int
+
+	// bytesSent is the total wire size of the queries sent.
+	bytesSent int
+
+	// payloadSizes is the range of the random wire sizes of the queries.  It's
+	// nil unless --payload-random-size is set.
+	payloadSizes *sizeRange
+
+	// sentSizes is the wire sizes of the queries sent.  It's only recorded if
+	// payloadSizes is set.
+	sentSizes []int
+
+	// bytesReceived is the total wire size of the responses received.
+	bytesReceived int
+
+	// sizedResponses is the number of responses which size is included into
+	// bytesReceived.
+	sizedResponses int
+
+	// serverCookies is the number of responses that had a server cookie.
+	serverCookies int
+
+	// ttls are the TTLs of the answer records of the responses.
+	ttls ttlStats
+
+	// nsidRcodes is the number of responses per response code for every name
+	// server identifier, the responses without one are counted under the
+	// empty string.  It is only set if NSID is requested.
+	nsidRcodes map[string]map[int]int
+
+	// nameResults is the outcome of the query for every hostname.  It is only
+	// set if every name is queried once.
+	nameResults map[string]*nameResult
+
+	// expectedIPs is the sorted set of IP addresses expected in the answers.
+	// If empty, the answers aren't checked.
+	expectedIPs []netip.Addr
+
+	// wrongAnswers is the number of responses which answers didn't match
+	// expectedIPs.
+	wrongAnswers int
+
+	// countedHostnames is the observed distribution of hostnames.  It is only
+	// set in the amplify mode.
+	countedHostnames []countedHostname
+
+	// rng is the random number generator for picking the query types and the
+	// hostnames.  The workers use their own ones for the random names, see
+	// workerRand.
+	rng *rand.Rand
+
+	// seed is the seed of rng.
+	seed int64
+
+	// maxErrors is the number of errors above which the test is aborted.  Zero
+	// means no limit.
+	maxErrors int
+
+	// abort cancels the context of the connections with the reason of the
+	// abortion.
+	abort context.CancelCauseFunc
+
+	// sentHostnames is the number of queries sent per hostname.  It is only
+	// recorded in the amplify mode.
+	sentHostnames map[string]int
+
+	// extendedErrors is the number of responses per extended DNS error code
+	// (RFC 8914) that were encountered during the test.
+	extendedErrors map[uint16]int
+
+	// quiet defines whether the intermediate results shouldn't be printed.
+	quiet bool
+
+	// showProgress defines whether the progress bar is drawn instead of
+	// printing the intermediate results.
+	showProgress bool
+
+	// reportInterval is the interval of printing the intermediate results.  If
+	// zero, they are printed every printEveryNRecords queries.
+	reportInterval time.Duration
+
+	// lastPrintedState is the last time we printed the intermediate state.
+	// It is printed on every 100's query.
+	lastPrintedState     time.Time
+	lastPrintedProcessed int
+	lastPrintedErrors    int
+
+	// warmupWG is used to wait for all connections to finish the warmup.
+	warmupWG sync.WaitGroup
+
+	// warmupFinished is closed when all connections have finished the warmup
+	// and the measurement should start.
+	warmupFinished chan struct{}
+
+	// errorSpikeRate is the rate limit at which errors started spiking during
+	// the ramp-up.  Zero means that no spike has been detected.
+	errorSpikeRate float64
+
+	// queryLog is the log of every query outcome.  It's nil if not enabled.
+	queryLog *csvQueryLog
+
+	// jsonl is the JSON lines output of the intermediate results.  It's nil
+	// if not enabled.
+	jsonl *jsonlReport
+
+	// statsd sends the metrics to StatsD during the test.  It's nil if not
+	// enabled.
+	statsd *statsdReporter
+
+	// selfPeak is the peak resource usage of the benchmark process sampled
+	// with --self-metrics.
+	selfPeak selfMetrics
+
+	// timeseries writes the statistics of every second of the test.  It's nil
+	// if not enabled.
+	timeseries *timeseriesCSV
+
+	// labels replace {random} in the queried names.  It's nil if every query
+	// should get a new random label.
+	labels *labelPool
+
+	// nameSeq is the number of the names with {seq} expanded so far.
+	nameSeq atomic.Uint64
+
+	// m protects all fields.
+	m sync.Mutex
+}
+
+// newRunState creates the state of the test described by options, which must
+// be valid, see [Options.validate].  It parses the options and reads the
+// queries files, but doesn't create the output files, see
+// [runState.openOutputs].
+func newRunState(options *Options) (state *runState, err error) {
+	state = &runState{
+		sessionCache:    tls.NewLRUClientSessionCache(0),
+		dohMethod:       options.dohMethod(),
+		quicTokens:      quic.NewLRUTokenStore(1, 10),
+		workers:         make([]workerStats, options.Connections),
+		reportInterval:  options.ReportInterval,
+		quiet:           options.isQuiet(),
+		sentQTypes:      map[uint16]int{},
+		rcodes:          map[int]int{},
+		errorCategories: map[errorCategory]int{},
+		extendedErrors:  map[uint16]int{},
+		cdAB:            options.CDAB,
+		tcpRatio:        options.TCPRatio,
+		trendWindow:     options.TrendWindow,
+		maxErrors:       options.MaxErrors,
+		slowest:         newSlowestQueries(options.Slowest),
+	}
+
+	state.targets, err = newSplitTargets(options)
+	if err != nil {
+		return nil, fmt.Errorf("split %s is invalid: %w", options.Split, err)
+	}
+
+	err = state.parseQueryOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	state.seed = options.Seed
+	if state.seed == 0 {
+		state.seed = time.Now().UnixNano()
+	}
+	options.logProgress("Using the random seed %d", state.seed)
+
+	state.rng = newRand(state.seed)
+	state.rate = newRateLimiter(options, state.seed)
+
+	hostnames, replay, err := readQueries(options)
+	if err != nil {
+		return nil, err
+	}
+
+	state.replay = replay
+	err = state.scheduleHostnames(options, hostnames)
+	if err != nil {
+		return nil, err
+	}
+
+	state.resolver, err = newBootstrapResolver(options.Bootstrap)
+	if err != nil {
+		return nil, err
+	}
+
+	state.initFeatureStats(options)
+
+	// Use a separate generator so that the labels don't depend on the
+	// number of connections.
+	state.labels = newLabelPool(state.workerRand(-1), options.UniqueNames)
+
+	state.startTime = time.Now()
+	if options.Duration > 0 {
+		state.deadline = state.startTime.Add(options.Duration)
+	}
+
+	return state, nil
+}
+
+// parseQueryOptions parses the options of the synthesized queries and of the
+// checks of their responses into the fields of r.
+func (r *runState) parseQueryOptions(options *Options) (err error) {
+	r.qtype = dns.TypeA
+	if options.QType != "" {
+		r.qtype, err = parseQType(options.QType)
+		if err != nil {
+			return fmt.Errorf("query type %s is invalid: %w", options.QType, err)
+		}
+	}
+
+	if options.ECS != "" {
+		r.ecs, err = netip.ParsePrefix(options.ECS)
+		if err != nil {
+			return fmt.Errorf("client subnet %s is invalid: %w", options.ECS, err)
+		}
+
+		r.ecs = r.ecs.Masked()
+	}
+
+	if options.QTypes != "" {
+		r.qtypes, err = parseQTypes(options.QTypes)
+		if err != nil {
+			return fmt.Errorf("list of query types %s is invalid: %w", options.QTypes, err)
+		}
+	}
+
+	r.qclass = dns.ClassINET
+	if options.QClass != "" {
+		r.qclass, err = parseQClass(options.QClass)
+		if err != nil {
+			return fmt.Errorf("query class %s is invalid: %w", options.QClass, err)
+		}
+	}
+
+	if options.PayloadRandomSize != "" {
+		r.payloadSizes, err = parseSizeRange(options.PayloadRandomSize)
+		if err != nil {
+			return fmt.Errorf("payload size %s is invalid: %w", options.PayloadRandomSize, err)
+		}
+	}
+
+	if options.LatencyBuckets != "" {
+		r.latencyBuckets, err = parseLatencyBuckets(options.LatencyBuckets)
+		if err != nil {
+			return fmt.Errorf("latency buckets %s are invalid: %w", options.LatencyBuckets, err)
+		}
+	}
+
+	if options.ExpectIP != "" {
+		r.expectedIPs, err = parseIPSet(options.ExpectIP)
+		if err != nil {
+			return fmt.Errorf("list of expected IP addresses %s is invalid: %w", options.ExpectIP, err)
+		}
+	}
+
+	return nil
+}
+
+// initFeatureStats creates the statistics of the optional features enabled in
+// options.
+func (r *runState) initFeatureStats(options *Options) {
+	if options.NSID {
+		r.nsidRcodes = map[string]map[int]int{}
+	}
+
+	if options.OncePerName {
+		r.nameResults = map[string]*nameResult{}
+	}
+
+	if options.Warmup > 0 {
+		r.warmupWG.Add(options.Connections)
+		r.warmupFinished = make(chan struct{})
+	}
+
+	if options.CDAB {
+		r.latencyValidated = &latencyStats{}
+		r.latencyUnvalidated = &latencyStats{}
+	}
+
+	if options.TCPRatio > 0 {
+		r.statsUDP = &transportStats{}
+		r.statsTCP = &transportStats{}
+	}
+}
+
+// openOutputs creates the files and the reporters the progress of the test is
+// written to during the test.  They must be closed with [runState.closeOutputs].
+func (r *runState) openOutputs(options *Options) (err error) {
+	if options.CSVOutput != "" {
+		r.queryLog, err = newCSVQueryLog(options.CSVOutput)
+		if err != nil {
+			return fmt.Errorf("creating csv file %s: %w", options.CSVOutput, err)
+		}
+	}
+
+	if options.JSONLOutput != "" {
+		r.jsonl, err = newJSONLReport(options.JSONLOutput)
+		if err != nil {
+			return fmt.Errorf("creating jsonl file %s: %w", options.JSONLOutput, err)
+		}
+	}
+
+	if options.StatsD != "" {
+		r.statsd, err = newStatsdReporter(options.StatsD, options.StatsDPrefix)
+		if err != nil {
+			return fmt.Errorf("statsd address %s is invalid: %w", options.StatsD, err)
+		}
+	}
+
+	if options.TimeseriesCSV != "" {
+		r.timeseries, err = newTimeseriesCSV(options.TimeseriesCSV)
+		if err != nil {
+			return fmt.Errorf("creating time series file %s: %w", options.TimeseriesCSV, err)
+		}
+	}
+
+	return nil
+}
+
+// closeOutputs flushes and closes the outputs created by
+// [runState.openOutputs].
+func (r *runState) closeOutputs() {
+	r.statsd.close()
+	r.timeseries.close()
+
+	if r.queryLog != nil {
+		log.OnCloserError(r.queryLog, log.ERROR)
+	}
+
+	log.OnCloserError(r.jsonl, log.ERROR)
+}
+
+// qpsTotal returns the number of queries processed in one second.
+func (r *runState) qpsTotal() (q float64) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	e := r.elapsedLocked()
+
+	return float64(r.processed+r.errors) / e.Seconds()
+}
+
+// elapsed returns total elapsed time.
+func (r *runState) elapsed() (e time.Duration) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.elapsedLocked()
+}
+
+// elapsedLocked returns total elapsed time.  If the test has finished, it
+// returns the duration of the test.  This method must be protected by the
+// mutex on the outside.
+func (r *runState) elapsedLocked() (e time.Duration) {
+	if r.finished {
+		return r.endTime.Sub(r.startTime)
+	}
+
+	return time.Since(r.startTime)
+}
+
+// elapsedPerQuery returns elapsed time per query.
+func (r *runState) elapsedPerQuery() (e time.Duration) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	elapsed := r.elapsedLocked()
+	avgElapsed := elapsed
+	if r.processed > 0 {
+		avgElapsed = elapsed / time.Duration(r.processed)
+	}
+
+	return avgElapsed
+}
+
+// query contains the parameters of a single query to be sent.
+type query struct {
+	// hostname is the hostname to be queried.
+	hostname string
+
+	// qtype is the type of the query.
+	qtype uint16
+
+	// qclass is the class of the query.
+	qclass uint16
+
+	// ecs is the client subnet to send in the EDNS0 Client Subnet option.  If
+	// it's invalid, the option is not sent.
+	ecs netip.Prefix
+
+	// cookie is the DNS cookie to send in the EDNS0 cookie option.  If it's
+	// empty, the option is not sent.
+	cookie []byte
+
+	// msg is the DNS query to replay.  If it's not nil, it's sent as is
+	// except for the ID.
+	msg *dns.Msg
+
+	// checkingDisabled is the value of the CD bit.
+	checkingDisabled bool
+
+	// overTCP is true if the query to a plain DNS address is sent over TCP
+	// instead of UDP.
+	overTCP bool
+
+	// size is the wire size the query is padded to.  Zero means no padding
+	// to a random size.
+	size int
+
+	// target is the index of the server the query is sent to if the queries
+	// are split between multiple servers.
+	target int
+}
+
+// newQuery returns the parameters of a query for hostname that don't depend on
+// the order of the query.  This method must be protected by the mutex on the
+// outside.
+func (r *runState) newQuery(hostname string) (q query) {
+	q.hostname = hostname
+	q.qtype = r.qtype
+	if len(r.qtypes) > 0 {
+		q.qtype = r.qtypes[r.rng.Intn(len(r.qtypes))]
+	}
+	q.qclass = r.qclass
+	q.ecs = r.ecs
+	if r.payloadSizes != nil {
+		q.size = r.payloadSizes.pick(r.rng)
+	}
+
+	return q
+}
+
+// nextQuery reserves the next query to be sent and returns its parameters.  ok
+// is false if all the queries have already been sent.
+func (r *runState) nextQuery() (q query, ok bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if !r.unlimited && r.queriesSent >= r.queriesCount {
+		return query{}, false
+	}
+
+	seq := r.queriesSent
+	r.queriesSent++
+
+	idx := seq
+	checkingDisabled := false
+	if r.cdAB {
+		// Query every hostname twice in a row, first with the CD bit unset and
+		// then with the CD bit set.
+		idx = seq / 2
+		checkingDisabled = seq%2 == 1
+	}
+
+	if checkingDisabled {
+		// The queries of a pair only differ in the CD bit, so that they
+		// measure the same query.
+		q = r.lastPair
+	} else {
+		hostname := r.hostnameAt(idx)
+		if r.sampler != nil {
+			hostname = r.sampler.pick(r.rng)
+		}
+
+		q = r.withReplay(r.newQuery(hostname), idx)
+		if len(r.targets) > 0 {
+			q.target = r.pickTargetLocked()
+		}
+
+		r.lastPair = q
+	}
+
+	q.checkingDisabled = checkingDisabled
+	r.sentQTypes[q.qtype]++
+	if r.sentHostnames != nil {
+		r.sentHostnames[q.hostname]++
+	}
+
+	return q, true
+}
+
+// warmupQuery returns the parameters of the i-th warmup query.  It doesn't
+// affect the statistics of the test.
+func (r *runState) warmupQuery(i int) (q query) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.withReplay(r.newQuery(r.hostnameAt(i)), i)
+}
+
+// hostnameAt returns the idx-th hostname to query.  The list of hostnames is
+// queried over again once it's exhausted.
+func (r *runState) hostnameAt(idx int) (hostname string) {
+	if r.amplified != nil {
+		return r.amplified.at(idx)
+	}
+
+	return r.hostnames[idx%len(r.hostnames)]
+}
+
+// withReplay returns q with the idx-th message to replay if the queries are
+// replayed from a file.  The file is replayed over again once it's exhausted.
+func (r *runState) withReplay(q query, idx int) (res query) {
+	if r.replay == nil {
+		return q
+	}
+
+	q.msg = r.replay[idx%len(r.replay)]
+	q.qtype, q.qclass = q.msg.Question[0].Qtype, q.msg.Question[0].Qclass
+
+	return q
+}
+
+// startMeasurement resets the start time and the deadline of the test after
+// the warmup.
+func (r *runState) startMeasurement(duration time.Duration) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.startTime = time.Now()
+	if duration > 0 {
+		r.deadline = r.startTime.Add(duration)
+	}
+}
+
+// addCDLatency records the latency of a successful query sent in the CD A/B
+// mode.
+func (r *runState) addCDLatency(checkingDisabled bool, d time.Duration) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	if checkingDisabled {
+		r.latencyUnvalidated.add(d)
+	} else {
+		r.latencyValidated.add(d)
+	}
+}
+
+// incResponse increments processed number, records the query latency, the
+// response code, and the answer TTLs of resp for the whole test and for the
+// connection workerID, returns the new processed number.
+func (r *runState) incResponse(workerID int, resp *dns.Msg, d time.Duration) (p int) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return r.processed
+	}
+
+	r.processed++
+	r.latency.add(d)
+	r.workers[workerID].processed++
+	r.workers[workerID].latency.add(d)
+	r.workers[workerID].lastResponse = resp
+	r.rcodes[resp.Rcode]++
+	if resp.Rcode == dns.RcodeSuccess && len(resp.Answer) == 0 {
+		r.noData++
+	}
+	r.ttls.addAnswers(resp)
+	r.printIntermediateResults()
+
+	return r.processed
+}
+
+// rcodesBreakdown returns a human-readable breakdown of the response codes
+// sorted by the number of responses in the descending order.
+func (r *runState) rcodesBreakdown() (s string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	codes := slices.SortedFunc(maps.Keys(r.rcodes), func(a, b int) (res int) {
+		return cmp.Or(cmp.Compare(r.rcodes[b], r.rcodes[a]), cmp.Compare(a, b))
+	})
+
+	parts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("%s: %d", rcodeToString(code), r.rcodes[code]))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// qtypesBreakdown returns a human-readable breakdown of the queries sent per
+// query type in the order the types were specified.
+func (r *runState) qtypesBreakdown() (s string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	parts := make([]string, 0, len(r.sentQTypes))
+	seen := map[uint16]bool{}
+	for _, qtype := range r.qtypes {
+		if seen[qtype] {
+			continue
+		}
+
+		seen[qtype] = true
+		parts = append(parts, fmt.Sprintf("%s: %d", dns.Type(qtype), r.sentQTypes[qtype]))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// errorsBreakdown returns a human-readable breakdown of the errors by
+// category.
+func (r *runState) errorsBreakdown() (s string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	parts := make([]string, 0, len(r.errorCategories))
+	for _, c := range errorCategories {
+		if n := r.errorCategories[c]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %d", c, n))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// rcodeToString returns the name of the response code.
+func rcodeToString(rcode int) (s string) {
+	// The queries are never signed, so the response code shared with BADSIG
+	// is BADVERS, see RFC 6891.
+	if rcode == dns.RcodeBadVers {
+		return "BADVERS"
+	}
+
+	if name, ok := dns.RcodeToString[rcode]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// printIntermediateResults prints intermediate results if needed.  This method
+// must be protected by the mutex on the outside.
+func (r *runState) printIntermediateResults() {
+	if r.reportInterval > 0 || (r.quiet || r.showProgress) && r.jsonl == nil {
+		// The results are either reported by reportPeriodically, not printed
+		// at all, or replaced with the progress bar.
+		return
+	}
+
+	// Time to print the intermediate result and qps.
+	queriesCount := r.processed + r.errors - r.lastPrintedProcessed - r.lastPrintedErrors
+
+	if queriesCount%printEveryNRecords == 0 {
+		r.printIntermediateResultsLocked()
+	}
+}
+
+// printIntermediateResultsLocked prints the number of queries and the QPS since
+// the last time it was called and writes them to the JSON lines output if
+// enabled.  This method must be protected by the mutex on the outside.
+func (r *runState) printIntermediateResultsLocked() {
+	queriesCount := r.processed + r.errors - r.lastPrintedProcessed - r.lastPrintedErrors
+
+	startTime := r.lastPrintedState
+	if r.lastPrintedState.IsZero() {
+		startTime = r.startTime
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(startTime)
+	qps := float64(queriesCount) / elapsed.Seconds()
+
+	r.jsonl.write(&intermediateRecord{
+		Time:      now,
+		Elapsed:   milliseconds(r.elapsedLocked()),
+		QPS:       qps,
+		Processed: r.processed,
+		Errors:    r.errors,
+	})
+
+	if !r.quiet && !r.showProgress {
+		log.Info("Processed %d queries, errors: %d", r.processed, r.errors)
+		log.Info("Queries per second: %f", qps)
+	}
+
+	r.lastPrintedState = now
+	r.lastPrintedProcessed = r.processed
+	r.lastPrintedErrors = r.errors
+}
+
+// reportPeriodically prints the intermediate results every interval.  It
+// returns when done is closed.
+func reportPeriodically(state *runState, interval time.Duration, done <-chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			state.m.Lock()
+			if !state.finished {
+				state.printIntermediateResultsLocked()
+			}
+			state.m.Unlock()
+		}
+	}
+}
+
+// countExtendedErrors records extended DNS errors (RFC 8914) found in resp.
+func (r *runState) countExtendedErrors(resp *dns.Msg) {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	for _, o := range opt.Option {
+		if ede, ok := o.(*dns.EDNS0_EDE); ok {
+			r.extendedErrors[ede.InfoCode]++
+		}
+	}
+}
+
+// countResponsePadding records the EDNS0 padding found in resp.
+func (r *runState) countResponsePadding(resp *dns.Msg) {
+	padLen, ok := responsePadding(resp)
+	if !ok {
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	r.paddedResponses++
+	r.paddingBytes += padLen
+}
+
+// countTCPKeepalive records the idle timeout from the EDNS0 TCP keepalive
+// option found in resp.
+func (r *runState) countTCPKeepalive(resp *dns.Msg) {
+	timeout, ok := responseTCPKeepalive(resp)
+	if !ok {
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	if r.keepaliveResponses == 0 {
+		r.keepaliveMin, r.keepaliveMax = timeout, timeout
+	} else {
+		r.keepaliveMin = min(r.keepaliveMin, timeout)
+		r.keepaliveMax = max(r.keepaliveMax, timeout)
+	}
+
+	r.keepaliveResponses++
+}
+
+// incIdleExpired increments the number of queries sent after the advertised
+// idle timeout of the connection and the number of the failed ones if failed
+// is true.
+func (r *runState) incIdleExpired(failed bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	r.idleExpired++
+	if failed {
+		r.idleExpiredErrors++
+	}
+}
+
+// countServerCookie records the server cookie found in resp.
+func (r *runState) countServerCookie(resp *dns.Msg) {
+	_, server, ok := responseCookie(resp)
+	if !ok || len(server) == 0 {
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	r.serverCookies++
+}
+
+// countNSID records the name server identifier and the response code of resp.
+func (r *runState) countNSID(resp *dns.Msg) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished || r.nsidRcodes == nil {
+		return
+	}
+
+	nsid := responseNSID(resp)
+	rcodes := r.nsidRcodes[nsid]
+	if rcodes == nil {
+		rcodes = map[int]int{}
+		r.nsidRcodes[nsid] = rcodes
+	}
+
+	rcodes[resp.Rcode]++
+}
+
+// nsidBreakdown returns a human-readable breakdown of the responses per name
+// server identifier sorted by the number of responses in the descending order,
+// one identifier per line with its response codes.
+func (r *runState) nsidBreakdown() (s string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	totals := make(map[string]int, len(r.nsidRcodes))
+	for nsid, rcodes := range r.nsidRcodes {
+		for _, n := range rcodes {
+			totals[nsid] += n
+		}
+	}
+
+	nsids := slices.SortedFunc(maps.Keys(totals), func(a, b string) (res int) {
+		return cmp.Or(cmp.Compare(totals[b], totals[a]), strings.Compare(a, b))
+	})
+
+	lines := make([]string, 0, len(nsids))
+	for _, nsid := range nsids {
+		rcodes := r.nsidRcodes[nsid]
+		codes := slices.SortedFunc(maps.Keys(rcodes), func(a, b int) (res int) {
+			return cmp.Or(cmp.Compare(rcodes[b], rcodes[a]), cmp.Compare(a, b))
+		})
+
+		parts := make([]string, 0, len(codes))
+		for _, code := range codes {
+			parts = append(parts, fmt.Sprintf("%s: %d", rcodeToString(code), rcodes[code]))
+		}
+
+		name := nsid
+		if name == "" {
+			name = "(none)"
+		}
+
+		lines = append(lines, fmt.Sprintf("  %s: %d (%s)", name, totals[nsid], strings.Join(parts, ", ")))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// checkAnswer compares the IP addresses from the answer section of resp with
+// the expected ones and counts a mismatch.
+func (r *runState) checkAnswer(resp *dns.Msg) {
+	if len(r.expectedIPs) == 0 || slices.Equal(answerIPs(resp), r.expectedIPs) {
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	r.wrongAnswers++
+}
+
+// extendedErrorsBreakdown returns a human-readable breakdown of the extended
+// DNS errors encountered during the test sorted by the error code.
+func (r *runState) extendedErrorsBreakdown() (s string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	codes := maps.Keys(r.extendedErrors)
+	parts := make([]string, 0, len(r.extendedErrors))
+	for _, code := range slices.Sorted(codes) {
+		name, ok := dns.ExtendedErrorCodeToString[code]
+		if !ok {
+			name = fmt.Sprintf("Code %d", code)
+		}
+
+		parts = append(parts, fmt.Sprintf("%s: %d", name, r.extendedErrors[code]))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// incErrors increments errors number and records the category of err for the
+// whole test and for the connection workerID, returns the new value.
+func (r *runState) incErrors(workerID int, err error) (e int) {
+	category := classifyError(err)
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return r.errors
+	}
+
+	r.errors++
+	r.workers[workerID].errors++
+	r.errorCategories[category]++
+	r.printIntermediateResults()
+
+	if r.maxErrors > 0 && r.errors > r.maxErrors {
+		r.abort(errTooManyErrors)
+	}
+
+	return r.errors
+}
+
+// incLate increments late responses number and records the response latency,
+// returns the new value.
+func (r *runState) incLate(d time.Duration) (l int) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return r.late
+	}
+
+	r.late++
+	r.latencyLate.add(d)
+
+	return r.late
+}
+
+// addFirstResponse records the latency of the first successful response over
+// a new connection.
+func (r *runState) addFirstResponse(d time.Duration) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	r.latencyFirst.add(d)
+}
+
+// incUpstreams increments the number of the upstreams created.
+func (r *runState) incUpstreams() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	r.upstreams++
+}
+
+// verifyConnection counts the TLS handshake of cs.  It's used as the
+// VerifyConnection callback of the TLS connections, so it never fails.
+func (r *runState) verifyConnection(cs tls.ConnectionState) (err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return nil
+	}
+
+	r.tlsHandshakes++
+	if cs.DidResume {
+		r.tlsResumed++
+	}
+
+	return nil
+}
+
+// count0RTT counts the DNS-over-QUIC query that got a response.  zeroRTT is
+// true if it was sent in the accepted 0-RTT data, rejected is true if it was
+// sent once again since the 0-RTT data was rejected.
+func (r *runState) count0RTT(zeroRTT, rejected bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	if zeroRTT {
+		r.quic0RTT++
+	} else {
+		r.quic1RTT++
+	}
+
+	if rejected {
+		r.quic0RTTRejected++
+	}
+}
+
+// incRetried increments the number of queries that succeeded after a retry.
+func (r *runState) incRetried() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	r.retried++
+}
+
+// incTruncated increments the number of queries retried over TCP.
+func (r *runState) incTruncated() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	r.truncated++
+}
+
+// addBytes records the wire sizes of the query m and its response resp, which
+// may be nil if there is none.
+func (r *runState) addBytes(m, resp *dns.Msg) {
+	sent, received := m.Len(), 0
+	if resp != nil {
+		received = resp.Len()
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	r.bytesSent += sent
+	if r.payloadSizes != nil {
+		r.sentSizes = append(r.sentSizes, sent)
+	}
+	if resp != nil {
+		r.bytesReceived += received
+		r.sizedResponses++
+	}
+}
+
+// incSkipped increments the number of queries that weren't sent since there
+// were too many queries in flight.
+func (r *runState) incSkipped() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	r.skipped++
+}
+
+// incInFlight increments the number of queries in flight and updates its
+// maximum.
+func (r *runState) incInFlight() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.inFlight++
+	r.maxInFlight = max(r.maxInFlight, r.inFlight)
+}
+
+// decInFlight decrements the number of queries in flight.
+func (r *runState) decInFlight() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.inFlight--
+}
+
+// counts returns the number of processed and failed queries.
+func (r *runState) counts() (processed, errs int) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.processed, r.errors
+}
+
+// errorRate returns the percentage of failed queries.
+func (r *runState) errorRate() (rate float64) {
+	processed, errs := r.counts()
+
+	return 100 * float64(errs) / float64(max(processed+errs, 1))
+}
+
+// setErrorSpikeRate records the rate at which errors started spiking if it
+// hasn't been recorded yet.  It returns true if the rate has been recorded.
+func (r *runState) setErrorSpikeRate(rate float64) (ok bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.errorSpikeRate > 0 {
+		return false
+	}
+
+	r.errorSpikeRate = rate
+
+	return true
+}
+
+// finish marks the test as finished.  After that the statistics are no longer
+// updated, so that they could be consistently read.
+func (r *runState) finish() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if !r.finished {
+		r.finished = true
+		r.endTime = time.Now()
+	}
+}
+
+// deadlineExceeded returns true if the test deadline has passed.
+func (r *runState) deadlineExceeded() (ok bool) {
+	return !r.deadline.IsZero() && time.Now().After(r.deadline)
+}
+
+// workerRand returns a new random number generator for the worker with
+// workerID.  Every worker has its own one so that they don't contend for a
+// lock and generate the same names across runs with the same seed.  Its seed is
+// derived from the one of the test, so that it doesn't repeat the streams of
+// other generators, including the ones of the tests with the adjacent seeds.
+func (r *runState) workerRand(workerID int) (rng *rand.Rand) {
+	return newRand(subSeed(r.seed, seedPurposeWorker, int64(workerID)))
+}
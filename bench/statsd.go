@@ -0,0 +1,144 @@
+package bench
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+const (
+	// statsdInterval is the interval of sending the metrics to StatsD.
+	statsdInterval = time.Second
+
+	// statsdWriteTimeout is the maximum duration of sending the metrics, so
+	// that an unavailable StatsD server never stalls the test.
+	statsdWriteTimeout = 100 * time.Millisecond
+)
+
+// statsdReporter periodically sends the metrics of the test to a StatsD server
+// over UDP.  A nil *statsdReporter is a no-op.
+type statsdReporter struct {
+	// prevTime is the time of the previous flush.
+	prevTime time.Time
+
+	// conn is the UDP socket connected to the StatsD server.
+	conn net.Conn
+
+	// stop is closed to make the reporter send the final metrics and stop.
+	stop chan struct{}
+
+	// done is closed when the reporter has stopped.
+	done chan struct{}
+
+	// prefix is prepended to the names of the metrics.
+	prefix string
+
+	// prevLatency is the sum of the latencies at the previous flush.
+	prevLatency time.Duration
+
+	// prevProcessed is the number of processed queries at the previous
+	// flush.
+	prevProcessed int
+
+	// prevErrors is the number of errors at the previous flush.
+	prevErrors int
+
+	// prevLatencyCount is the number of latencies at the previous flush.
+	prevLatencyCount int
+}
+
+// newStatsdReporter creates a new *statsdReporter sending the metrics with the
+// names starting with prefix to addr.
+func newStatsdReporter(addr, prefix string) (r *statsdReporter, err error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statsdReporter{
+		conn:   conn,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		prefix: prefix,
+	}, nil
+}
+
+// start starts sending the metrics of state every statsdInterval.
+func (r *statsdReporter) start(state *runState) {
+	if r == nil {
+		return
+	}
+
+	r.prevTime = time.Now()
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(statsdInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				r.flush(state)
+
+				return
+			case <-ticker.C:
+				r.flush(state)
+			}
+		}
+	}()
+}
+
+// close sends the final metrics and closes the connection.
+func (r *statsdReporter) close() {
+	if r == nil {
+		return
+	}
+
+	close(r.stop)
+	<-r.done
+
+	log.OnCloserError(r.conn, log.DEBUG)
+}
+
+// flush sends the metrics of state collected since the previous flush.  The
+// counters are sent as the increments, the QPS and the average latency of the
+// interval are sent as gauges.
+func (r *statsdReporter) flush(state *runState) {
+	state.m.Lock()
+	processed, errs := state.processed, state.errors
+	latency, latencyCount := state.latency.total, state.latency.count()
+	state.m.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(r.prevTime)
+
+	queries := processed - r.prevProcessed
+	failed := errs - r.prevErrors
+	qps := float64(queries+failed) / max(elapsed.Seconds(), 1e-9)
+
+	var avgLatency time.Duration
+	if n := latencyCount - r.prevLatencyCount; n > 0 {
+		avgLatency = (latency - r.prevLatency) / time.Duration(n)
+	}
+
+	r.prevTime = now
+	r.prevProcessed, r.prevErrors = processed, errs
+	r.prevLatency, r.prevLatencyCount = latency, latencyCount
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s.queries:%d|c\n", r.prefix, queries)
+	fmt.Fprintf(b, "%s.errors:%d|c\n", r.prefix, failed)
+	fmt.Fprintf(b, "%s.qps:%.2f|g\n", r.prefix, qps)
+	fmt.Fprintf(b, "%s.latency_avg_ms:%.3f|g", r.prefix, float64(avgLatency)/float64(time.Millisecond))
+
+	_ = r.conn.SetWriteDeadline(now.Add(statsdWriteTimeout))
+	_, err := r.conn.Write([]byte(b.String()))
+	if err != nil {
+		log.Debug("sending metrics to statsd: %s", err)
+	}
+}
@@ -0,0 +1,73 @@
+package bench
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runStatsD(t *testing.T) {
+	addr := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	testutil.CleanupAndRequireSuccess(t, pc.Close)
+
+	o := &Options{
+		Address:            addr,
+		Connections:        2,
+		Query:              "example.org",
+		Timeout:            10,
+		QueriesCount:       50,
+		InsecureSkipVerify: true,
+		StatsD:             pc.LocalAddr().String(),
+		StatsDPrefix:       "test",
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+
+	// The final metrics are sent before the test returns, so all the queries
+	// are counted by the time it does.
+	queries := 0
+	buf := make([]byte, 64*1024)
+	for {
+		require.NoError(t, pc.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+
+		var n int
+		n, _, err = pc.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			require.True(t, strings.HasPrefix(line, "test."), line)
+
+			value, ok := strings.CutPrefix(line, "test.queries:")
+			if !ok {
+				continue
+			}
+
+			var v int
+			v, err = strconv.Atoi(strings.TrimSuffix(value, "|c"))
+			require.NoError(t, err)
+
+			queries += v
+		}
+	}
+
+	require.Equal(t, o.QueriesCount, queries)
+}
@@ -0,0 +1,84 @@
+package bench
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// nameResult is the outcome of the query for a single name when every name of
+// the queries file is queried once.
+type nameResult struct {
+	// err is the error of the query, if any.
+	err error
+
+	// latency is the time it took to get the response.
+	latency time.Duration
+
+	// rcode is the response code.  It's only set if err is nil.
+	rcode int
+
+	// answers is the number of the answer records.  It's only set if err is
+	// nil.
+	answers int
+}
+
+// addNameResult records the outcome of the query for hostname, which is either
+// resp or err, answered in d.
+func (r *runState) addNameResult(hostname string, resp *dns.Msg, err error, d time.Duration) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	res := &nameResult{err: err, latency: d}
+	if err == nil {
+		res.rcode, res.answers = resp.Rcode, len(resp.Answer)
+	}
+
+	r.nameResults[hostname] = res
+}
+
+// nameResultsBreakdown returns a human-readable list of the outcomes of the
+// queries for every name in the order of the queries file.
+func (r *runState) nameResultsBreakdown() (s string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	lines := make([]string, 0, len(r.hostnames))
+	for _, hostname := range r.hostnames {
+		res, ok := r.nameResults[hostname]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("  %s: not queried", hostname))
+
+			continue
+		}
+
+		lines = append(lines, "  "+hostname+": "+res.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// String implements the [fmt.Stringer] interface for *nameResult.
+func (res *nameResult) String() (s string) {
+	if errors.Is(res.err, errLateResponse) {
+		return fmt.Sprintf("late response in %s", res.latency)
+	}
+
+	if res.err != nil {
+		return fmt.Sprintf("%s error in %s: %s", classifyError(res.err), res.latency, res.err)
+	}
+
+	return fmt.Sprintf(
+		"%s, answers: %d in %s",
+		rcodeToString(res.rcode),
+		res.answers,
+		res.latency,
+	)
+}
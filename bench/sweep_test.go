@@ -0,0 +1,65 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runOncePerName(t *testing.T) {
+	var mu sync.Mutex
+	queried := map[string]int{}
+	addr := startUDPServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		name := req.Question[0].Name
+
+		mu.Lock()
+		queried[name]++
+		mu.Unlock()
+
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		if name == "nx.example." {
+			resp.Rcode = dns.RcodeNameError
+		}
+		_ = w.WriteMsg(resp)
+	})
+
+	// The weights are ignored, and the duplicates are only queried once.
+	filePath := filepath.Join(t.TempDir(), "queries.txt")
+	err := os.WriteFile(filePath, []byte("a.example 5\nb.example 1\nnx.example 1\nb.example 1\n"), 0o644)
+	require.NoError(t, err)
+
+	o := &Options{
+		Address:      addr.String(),
+		Connections:  2,
+		QueriesPath:  filePath,
+		Timeout:      1,
+		QueriesCount: 100,
+		OncePerName:  true,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, 3, state.processed)
+
+	mu.Lock()
+	require.Equal(t, map[string]int{"a.example.": 1, "b.example.": 1, "nx.example.": 1}, queried)
+	mu.Unlock()
+
+	require.Equal(t, dns.RcodeNameError, state.nameResults["nx.example"].rcode)
+	require.Contains(t, state.nameResultsBreakdown(), "  nx.example: NXDOMAIN, answers: 0 in ")
+	require.Equal(t, 1, strings.Count(state.nameResultsBreakdown(), "  b.example: "))
+
+	o.CDAB = true
+	_, err = run(context.Background(), o, nil)
+	require.ErrorContains(t, err, "can't be used with --amplify or --cd-ab")
+
+	o.CDAB, o.Amplify = false, true
+	_, err = run(context.Background(), o, nil)
+	require.Error(t, err)
+}
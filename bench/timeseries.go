@@ -0,0 +1,153 @@
+package bench
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// timeseriesInterval is the interval of the rows of the time series.
+const timeseriesInterval = time.Second
+
+// timeseriesCSV writes the number of queries, errors, and the latency
+// percentiles of every second of the test to a CSV file.  A nil *timeseriesCSV
+// is a no-op.
+type timeseriesCSV struct {
+	// file is the underlying CSV file.
+	file *os.File
+
+	// w writes the rows to file.
+	w *csv.Writer
+
+	// stop is closed to make the writer write the final row and stop.
+	stop chan struct{}
+
+	// done is closed when the writer has stopped.
+	done chan struct{}
+
+	// latency is the latency of the queries processed since the previous row.
+	latency latencyStats
+
+	// errors is the number of the queries failed since the previous row.
+	errors int
+
+	// mu protects latency and errors.
+	mu sync.Mutex
+}
+
+// newTimeseriesCSV creates the CSV file at path and writes the header to it.
+func newTimeseriesCSV(path string) (ts *timeseriesCSV, err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		// Don't wrap the error since it's informative enough as is.
+		return nil, err
+	}
+
+	ts = &timeseriesCSV{
+		file: f,
+		w:    csv.NewWriter(f),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	err = ts.w.Write([]string{
+		"timestamp",
+		"queries_this_second",
+		"errors_this_second",
+		"p50_this_second",
+		"p99_this_second",
+	})
+	if err != nil {
+		_ = f.Close()
+
+		return nil, fmt.Errorf("writing header: %w", err)
+	}
+
+	return ts, nil
+}
+
+// add records the outcome of a query answered in d.  failed is true if the
+// query has failed, in which case d is ignored.
+func (ts *timeseriesCSV) add(d time.Duration, failed bool) {
+	if ts == nil {
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if failed {
+		ts.errors++
+	} else {
+		ts.latency.add(d)
+	}
+}
+
+// start starts writing a row every timeseriesInterval.
+func (ts *timeseriesCSV) start() {
+	if ts == nil {
+		return
+	}
+
+	go func() {
+		defer close(ts.done)
+
+		ticker := time.NewTicker(timeseriesInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ts.stop:
+				ts.flush(time.Now())
+
+				return
+			case now := <-ticker.C:
+				ts.flush(now)
+			}
+		}
+	}()
+}
+
+// flush writes the row for the queries recorded since the previous one and
+// resets the counters.  The latencies are in milliseconds.
+func (ts *timeseriesCSV) flush(now time.Time) {
+	ts.mu.Lock()
+	latency, errs := ts.latency, ts.errors
+	ts.latency, ts.errors = latencyStats{}, 0
+	ts.mu.Unlock()
+
+	n := latency.count()
+
+	// The error is returned by Flush, so ignore it here.
+	_ = ts.w.Write([]string{
+		now.UTC().Format(time.RFC3339Nano),
+		strconv.Itoa(n + errs),
+		strconv.Itoa(errs),
+		strconv.FormatFloat(milliseconds(latency.percentile(50)), 'f', 3, 64),
+		strconv.FormatFloat(milliseconds(latency.percentile(99)), 'f', 3, 64),
+	})
+}
+
+// close writes the final row with the queries recorded since the previous one
+// and closes the file.
+func (ts *timeseriesCSV) close() {
+	if ts == nil {
+		return
+	}
+
+	close(ts.stop)
+	<-ts.done
+
+	ts.w.Flush()
+	err := ts.w.Error()
+	if err != nil {
+		log.Error("writing time series: %s", err)
+	}
+
+	log.OnCloserError(ts.file, log.ERROR)
+}
@@ -0,0 +1,79 @@
+package bench
+
+import (
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// transportStats is the statistics of the queries sent over a single transport
+// when the queries to a plain DNS address are split between UDP and TCP.
+type transportStats struct {
+	// latency is the latency of the successfully processed queries.
+	latency latencyStats
+
+	// errors is the number of failed queries.
+	errors int
+}
+
+// newPlainTCPUpstream creates an upstream that queries the plain DNS address
+// from options over TCP.
+func newPlainTCPUpstream(options *Options, state *runState) (u upstream.Upstream) {
+	state.incUpstreams()
+
+	return newTCPUpstream(
+		options.Address,
+		time.Duration(options.Timeout)*time.Second,
+		newBootstrap(options.IPVersion, state.resolver),
+	)
+}
+
+// createQueryUpstream creates a new upstream for the queries sent over TCP if
+// overTCP is true, or for the rest of the queries otherwise.
+func createQueryUpstream(options *Options, state *runState, overTCP bool) (u upstream.Upstream) {
+	if overTCP {
+		return newPlainTCPUpstream(options, state)
+	}
+
+	return createUpstream(options, state)
+}
+
+// addTransportResult records the outcome of a query sent over TCP if overTCP
+// is true, or over UDP otherwise.  failed is true if the query has failed, in
+// which case d is ignored.
+func (r *runState) addTransportResult(overTCP bool, d time.Duration, failed bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	s := r.statsUDP
+	if overTCP {
+		s = r.statsTCP
+	}
+
+	if failed {
+		s.errors++
+	} else {
+		s.latency.add(d)
+	}
+}
+
+// printTransportResults prints the comparison of the queries sent over UDP and
+// TCP.
+func printTransportResults(state *runState) {
+	u, t := state.statsUDP, state.statsTCP
+	ul, tl := &u.latency, &t.latency
+
+	log.Info("UDP vs TCP:")
+	log.Info("  Processed queries: %d vs %d", ul.count(), tl.count())
+	log.Info("  Errors: %d vs %d", u.errors, t.errors)
+	log.Info("  Average per query: %s vs %s", ul.average(), tl.average())
+	log.Info("  p50: %s vs %s", ul.percentile(50), tl.percentile(50))
+	log.Info("  p90: %s vs %s", ul.percentile(90), tl.percentile(90))
+	log.Info("  p99: %s vs %s", ul.percentile(99), tl.percentile(99))
+	log.Info("  Max: %s vs %s", ul.maximum(), tl.maximum())
+}
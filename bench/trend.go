@@ -0,0 +1,126 @@
+package bench
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// trendThreshold is the relative change of the median latency between the
+// first and the last windows that is considered a trend.
+const trendThreshold = 0.05
+
+// windowStats is the statistics of the queries sent within a single time
+// window of the test.
+type windowStats struct {
+	// latency is the latency of the successfully processed queries.
+	latency latencyStats
+
+	// errors is the number of failed queries.
+	errors int
+}
+
+// addWindowResult records the outcome of the query sent at start in the time
+// window it belongs to.  failed is true if the query has failed, in which case
+// d is ignored.
+func (r *runState) addWindowResult(start time.Time, d time.Duration, failed bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.finished {
+		return
+	}
+
+	idx := max(int(start.Sub(r.startTime)/r.trendWindow), 0)
+	for len(r.windows) <= idx {
+		r.windows = append(r.windows, &windowStats{})
+	}
+
+	w := r.windows[idx]
+	if failed {
+		w.errors++
+	} else {
+		w.latency.add(d)
+	}
+}
+
+// printTrend prints the statistics of every time window of the test and
+// whether the latency has changed from the first window to the last complete
+// one.
+func printTrend(state *runState) {
+	windows := state.windows
+	if len(windows) == 0 {
+		return
+	}
+
+	size, elapsed := state.trendWindow, state.elapsed()
+
+	b := &strings.Builder{}
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "Window\tProcessed\tErrors\tQPS\tAverage\tp50\tp90")
+	for i, ws := range windows {
+		from := time.Duration(i) * size
+		to := min(from+size, max(elapsed, from))
+
+		n := ws.latency.count()
+
+		var qps float64
+		if to > from {
+			qps = float64(n+ws.errors) / (to - from).Seconds()
+		}
+
+		_, _ = fmt.Fprintf(
+			w,
+			"%s-%s\t%d\t%d\t%.2f\t%s\t%s\t%s\n",
+			from,
+			to.Round(time.Millisecond),
+			n,
+			ws.errors,
+			qps,
+			ws.latency.average(),
+			ws.latency.percentile(50),
+			ws.latency.percentile(90),
+		)
+	}
+	_ = w.Flush()
+
+	log.Info("Latency over %s windows:\n%s", size, strings.TrimSuffix(b.String(), "\n"))
+
+	// Don't compare with the last window if it's incomplete, since it may be
+	// too short.
+	complete := min(len(windows), int(elapsed/size))
+	if complete < 2 {
+		log.Info("Not enough complete windows to tell the latency trend")
+
+		return
+	}
+
+	first, last := windows[0].latency.percentile(50), windows[complete-1].latency.percentile(50)
+	log.Info(
+		"Latency p50 in the first window vs the last complete one: %s vs %s, %s",
+		first,
+		last,
+		latencyTrend(first, last),
+	)
+}
+
+// latencyTrend returns the human-readable description of the change of the
+// latency from first to last.
+func latencyTrend(first, last time.Duration) (s string) {
+	if first == 0 || last == 0 {
+		return "not enough responses to tell the trend"
+	}
+
+	change := float64(last-first) / float64(first)
+	switch {
+	case change <= -trendThreshold:
+		return fmt.Sprintf("the latency has improved by %.2f%%", -change*100)
+	case change >= trendThreshold:
+		return fmt.Sprintf("the latency has worsened by %.2f%%", change*100)
+	default:
+		return "the latency hasn't changed significantly"
+	}
+}
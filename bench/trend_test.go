@@ -0,0 +1,81 @@
+package bench
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/proxy"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyTrend(t *testing.T) {
+	testCases := []struct {
+		name  string
+		want  string
+		first time.Duration
+		last  time.Duration
+	}{{
+		name:  "improved",
+		want:  "the latency has improved by 50.00%",
+		first: 20 * time.Millisecond,
+		last:  10 * time.Millisecond,
+	}, {
+		name:  "worsened",
+		want:  "the latency has worsened by 100.00%",
+		first: 10 * time.Millisecond,
+		last:  20 * time.Millisecond,
+	}, {
+		name:  "unchanged",
+		want:  "the latency hasn't changed significantly",
+		first: 100 * time.Millisecond,
+		last:  101 * time.Millisecond,
+	}, {
+		name:  "no_responses",
+		want:  "not enough responses to tell the trend",
+		first: 0,
+		last:  10 * time.Millisecond,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, latencyTrend(tc.first, tc.last))
+		})
+	}
+}
+
+func Test_runTrendWindow(t *testing.T) {
+	// The responses are slow until the "cache" warms up, i.e. for about the
+	// first 200ms of the test.
+	var queries atomic.Int32
+	serverAddress := startTestServer(t, func(_ *proxy.Proxy, d *proxy.DNSContext) (err error) {
+		if queries.Add(1) <= 10 {
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		resp := &dns.Msg{}
+		resp.SetReply(d.Req)
+		d.Res = resp
+
+		return nil
+	})
+
+	o := &Options{
+		Address:            serverAddress,
+		Connections:        1,
+		Query:              "example.org",
+		Timeout:            10,
+		Duration:           500 * time.Millisecond,
+		TrendWindow:        100 * time.Millisecond,
+		InsecureSkipVerify: true,
+	}
+
+	state := runTest(t, o)
+	require.GreaterOrEqual(t, len(state.windows), 4)
+
+	first, last := state.windows[0], state.windows[len(state.windows)-1]
+	require.Positive(t, last.latency.count())
+	require.Greater(t, first.latency.percentile(50), last.latency.percentile(50))
+}
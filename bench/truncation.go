@@ -0,0 +1,42 @@
+package bench
+
+import (
+	"context"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// retryTruncated sends m once again over TCP if resp, the response to it over
+// UDP from the server with options, is truncated, as a real client would do.
+// The retried queries are counted in state.  tcp returns the upstream to retry
+// over, it's only called if the query is retried.  If tcp is nil, a new one is
+// created and closed after the retry.  The plain DNS-over-UDP upstreams never
+// retry the truncated responses by themselves, see [newUpstream].
+func retryTruncated(
+	ctx context.Context,
+	options *Options,
+	state *runState,
+	m *dns.Msg,
+	resp *dns.Msg,
+	tcp func() (u upstream.Upstream),
+) (res *dns.Msg, err error) {
+	if !resp.Truncated || !isPlainUDPAddress(options.Address) {
+		return resp, nil
+	}
+
+	log.Debug("Response to %s is truncated, retrying over TCP", m.Question[0].Name)
+
+	state.incTruncated()
+
+	var u upstream.Upstream
+	if tcp != nil {
+		u = tcp()
+	} else {
+		u = newPlainTCPUpstream(options, state)
+		defer log.OnCloserError(u, log.DEBUG)
+	}
+
+	return exchangeTimeout(ctx, u, m, options.queryTimeout())
+}
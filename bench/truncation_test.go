@@ -0,0 +1,120 @@
+package bench
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/testutil"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTruncatingServer starts a plain DNS server listening on the same port
+// for both UDP and TCP, which truncates every response over UDP, and returns
+// its address.
+func startTruncatingServer(t *testing.T) (addr string) {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	require.NoError(t, err)
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		_, resp.Truncated = w.RemoteAddr().(*net.UDPAddr)
+
+		_ = w.WriteMsg(resp)
+	})
+
+	for _, srv := range []*dns.Server{
+		{PacketConn: pc, Handler: handler},
+		{Listener: l, Handler: handler},
+	} {
+		go func() { _ = srv.ActivateAndServe() }()
+		testutil.CleanupAndRequireSuccess(t, srv.Shutdown)
+	}
+
+	return pc.LocalAddr().String()
+}
+
+func TestRetryTruncated(t *testing.T) {
+	addr := startTruncatingServer(t)
+	ctx := context.Background()
+	req := (&dns.Msg{}).SetQuestion("example.org.", dns.TypeA)
+
+	truncated := (&dns.Msg{}).SetReply(req)
+	truncated.Truncated = true
+
+	testCases := []struct {
+		resp          *dns.Msg
+		name          string
+		addr          string
+		wantTruncated int
+	}{{
+		resp:          (&dns.Msg{}).SetReply(req),
+		name:          "not_truncated",
+		addr:          addr,
+		wantTruncated: 0,
+	}, {
+		resp:          truncated,
+		name:          "truncated",
+		addr:          addr,
+		wantTruncated: 1,
+	}, {
+		resp:          truncated,
+		name:          "not_plain_udp",
+		addr:          "tcp://" + addr,
+		wantTruncated: 0,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := &Options{Address: tc.addr, Timeout: 1}
+			state := &runState{}
+
+			resp, err := retryTruncated(ctx, o, state, req, tc.resp, nil)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantTruncated, state.truncated)
+			if tc.wantTruncated > 0 {
+				// The response has been received over TCP.
+				assert.False(t, resp.Truncated)
+			} else {
+				assert.Same(t, tc.resp, resp)
+			}
+		})
+	}
+}
+
+func TestUDPPerQueryUpstream(t *testing.T) {
+	u := &udpPerQueryUpstream{
+		addr:    startTruncatingServer(t),
+		timeout: time.Second,
+	}
+	testutil.CleanupAndRequireSuccess(t, u.Close)
+
+	req := (&dns.Msg{}).SetQuestion("example.org.", dns.TypeA)
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resp, err := u.Exchange(req)
+			assert.NoError(t, err)
+
+			// Unlike the dnsproxy upstream, it doesn't retry over TCP.
+			assert.True(t, resp.Truncated)
+		}()
+	}
+
+	wg.Wait()
+}
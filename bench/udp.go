@@ -0,0 +1,240 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/errors"
+	"github.com/miekg/dns"
+)
+
+// errLateResponse is returned by [udpUpstream.Exchange] when the response
+// arrived after the query timeout, but before the late wait period expired.
+const errLateResponse errors.Error = "late response"
+
+// isPlainUDPAddress returns true if addr is an address of a plain DNS server
+// that is queried over UDP.
+func isPlainUDPAddress(addr string) (ok bool) {
+	return !strings.Contains(addr, "://") || strings.HasPrefix(addr, "udp://")
+}
+
+// plainHostPort returns the host:port form of a plain DNS address adding the
+// default port if needed.
+func plainHostPort(addr string) (hostPort string) {
+	hostPort = strings.TrimPrefix(addr, "udp://")
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		hostPort = net.JoinHostPort(strings.Trim(hostPort, "[]"), "53")
+	}
+
+	return hostPort
+}
+
+// newTCPUpstream creates an upstream that queries the plain DNS address over
+// TCP.  bootstrap resolves the hostname of addr, nil means the default one.
+func newTCPUpstream(
+	addr string,
+	timeout time.Duration,
+	bootstrap upstream.Resolver,
+) (u upstream.Upstream) {
+	// Ignoring the error here since the address was already verified.
+	u, _ = upstream.AddressToUpstream(
+		"tcp://"+plainHostPort(addr),
+		&upstream.Options{Timeout: timeout, Bootstrap: bootstrap},
+	)
+
+	return u
+}
+
+// udpUpstream is a low-level plain DNS-over-UDP client that keeps listening
+// for a response for some time after the query timeout so that late responses
+// could be distinguished from unanswered queries.  It also allows binding the
+// outgoing connections to a specific local address.
+type udpUpstream struct {
+	// conn is the connection to the server.  It's created on the first
+	// exchange.
+	conn *dns.Conn
+
+	// addr is the server address in the host:port form.
+	addr string
+
+	// network is the network to dial the connection over, e.g. "udp4".
+	network string
+
+	// resolver resolves the hostname of the server.  nil means the system
+	// resolver.
+	resolver *net.Resolver
+
+	// localAddr is the local address the connection is bound to.  If it's
+	// invalid, the local address is chosen automatically.
+	localAddr netip.Addr
+
+	// timeout is the query timeout.
+	timeout time.Duration
+
+	// lateWait is how long to wait for a response after timeout.
+	lateWait time.Duration
+}
+
+// type check
+var (
+	_ upstream.Upstream = (*udpUpstream)(nil)
+	_ contextExchanger  = (*udpUpstream)(nil)
+)
+
+// newUDPUpstream creates a new *udpUpstream for a plain DNS address.
+// ipVersion is the value of --ip-version.  resolver resolves the hostname, nil
+// means the system one.
+func newUDPUpstream(
+	addr string,
+	timeout time.Duration,
+	lateWait time.Duration,
+	localAddr netip.Addr,
+	ipVersion string,
+	resolver *net.Resolver,
+) (u *udpUpstream) {
+	return &udpUpstream{
+		addr:      plainHostPort(addr),
+		network:   ipNetwork("udp", ipVersion),
+		resolver:  resolver,
+		localAddr: localAddr,
+		timeout:   timeout,
+		lateWait:  lateWait,
+	}
+}
+
+// Exchange implements the [upstream.Upstream] interface for *udpUpstream.  If
+// the response arrives late, it returns the response along with
+// [errLateResponse].
+func (u *udpUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	return u.ExchangeContext(context.Background(), req)
+}
+
+// ExchangeContext implements the [contextExchanger] interface for
+// *udpUpstream.
+func (u *udpUpstream) ExchangeContext(
+	ctx context.Context,
+	req *dns.Msg,
+) (resp *dns.Msg, err error) {
+	if u.conn == nil {
+		dialer := &net.Dialer{Timeout: u.timeout, Resolver: u.resolver}
+		if u.localAddr.IsValid() {
+			dialer.LocalAddr = net.UDPAddrFromAddrPort(netip.AddrPortFrom(u.localAddr, 0))
+		}
+
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, u.network, u.addr)
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s: %w: %w", u.addr, errConnect, err)
+		}
+
+		u.conn = &dns.Conn{Conn: conn}
+	}
+
+	deadline := time.Now().Add(u.timeout)
+	_ = u.conn.SetDeadline(earliestDeadline(ctx, deadline))
+
+	err = u.conn.WriteMsg(req)
+	if err != nil {
+		return nil, fmt.Errorf("writing query: %w", err)
+	}
+
+	late := false
+	for {
+		resp, err = u.conn.ReadMsg()
+		if err != nil {
+			var netErr net.Error
+			if !late && errors.As(err, &netErr) && netErr.Timeout() {
+				late = true
+				_ = u.conn.SetReadDeadline(earliestDeadline(ctx, deadline.Add(u.lateWait)))
+
+				continue
+			}
+
+			return nil, fmt.Errorf("reading response: %w", err)
+		}
+
+		// Skip responses to the previous queries that arrived too late.
+		if resp.Id != req.Id {
+			continue
+		}
+
+		if late {
+			return resp, errLateResponse
+		}
+
+		return resp, nil
+	}
+}
+
+// Address implements the [upstream.Upstream] interface for *udpUpstream.
+func (u *udpUpstream) Address() (addr string) {
+	return "udp://" + u.addr
+}
+
+// Close implements the [upstream.Upstream] interface for *udpUpstream.
+func (u *udpUpstream) Close() (err error) {
+	if u.conn == nil {
+		return nil
+	}
+
+	return u.conn.Close()
+}
+
+// udpPerQueryUpstream is a plain DNS-over-UDP client safe for concurrent use
+// that sends every query over a new *udpUpstream.  Unlike the dnsproxy one, it
+// returns the truncated responses as is, so that the retries over TCP could be
+// counted, see [retryTruncated].
+type udpPerQueryUpstream struct {
+	// resolver resolves the hostname of the server.  nil means the system
+	// resolver.
+	resolver *net.Resolver
+
+	// addr is the plain DNS address of the server.
+	addr string
+
+	// ipVersion is the value of --ip-version.
+	ipVersion string
+
+	// timeout is the query timeout.
+	timeout time.Duration
+}
+
+// type check
+var (
+	_ upstream.Upstream = (*udpPerQueryUpstream)(nil)
+	_ contextExchanger  = (*udpPerQueryUpstream)(nil)
+)
+
+// Exchange implements the [upstream.Upstream] interface for
+// *udpPerQueryUpstream.
+func (u *udpPerQueryUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	return u.ExchangeContext(context.Background(), req)
+}
+
+// ExchangeContext implements the [contextExchanger] interface for
+// *udpPerQueryUpstream.
+func (u *udpPerQueryUpstream) ExchangeContext(
+	ctx context.Context,
+	req *dns.Msg,
+) (resp *dns.Msg, err error) {
+	conn := newUDPUpstream(u.addr, u.timeout, 0, netip.Addr{}, u.ipVersion, u.resolver)
+	defer func() { err = errors.WithDeferred(err, conn.Close()) }()
+
+	return conn.ExchangeContext(ctx, req)
+}
+
+// Address implements the [upstream.Upstream] interface for
+// *udpPerQueryUpstream.
+func (u *udpPerQueryUpstream) Address() (addr string) {
+	return "udp://" + plainHostPort(u.addr)
+}
+
+// Close implements the [upstream.Upstream] interface for *udpPerQueryUpstream.
+func (u *udpPerQueryUpstream) Close() (err error) {
+	return nil
+}
@@ -0,0 +1,117 @@
+package bench
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/miekg/dns"
+)
+
+// unixScheme is the scheme of the addresses of the plain DNS servers listening
+// on a UNIX domain socket.
+const unixScheme = "unix://"
+
+// isUnixAddress returns true if addr is an address of a plain DNS server
+// listening on a UNIX domain socket, e.g. unix:///run/resolver.sock.
+func isUnixAddress(addr string) (ok bool) {
+	return strings.HasPrefix(addr, unixScheme)
+}
+
+// unixUpstream is a plain DNS client that sends the queries over a single
+// stream UNIX domain socket connection using the DNS-over-TCP framing, since
+// the dnsproxy upstreams don't allow overriding the dialer.
+type unixUpstream struct {
+	// conn is the connection to the server.  It's created on the first
+	// exchange and re-created after errors.
+	conn *dns.Conn
+
+	// path is the path to the socket.
+	path string
+
+	// timeout is the query timeout.
+	timeout time.Duration
+
+	// connectTimeout is the timeout of establishing a connection.
+	connectTimeout time.Duration
+}
+
+// type check
+var (
+	_ upstream.Upstream = (*unixUpstream)(nil)
+	_ contextExchanger  = (*unixUpstream)(nil)
+)
+
+// newUnixUpstream creates a new *unixUpstream for a unix:// address.
+// connectTimeout of zero means that timeout is used.
+func newUnixUpstream(
+	addr string,
+	timeout time.Duration,
+	connectTimeout time.Duration,
+) (u *unixUpstream, err error) {
+	path := strings.TrimPrefix(addr, unixScheme)
+	if path == "" {
+		return nil, fmt.Errorf("no socket path in %q", addr)
+	}
+
+	return &unixUpstream{
+		path:           path,
+		timeout:        timeout,
+		connectTimeout: cmp.Or(connectTimeout, timeout),
+	}, nil
+}
+
+// Exchange implements the [upstream.Upstream] interface for *unixUpstream.
+func (u *unixUpstream) Exchange(req *dns.Msg) (resp *dns.Msg, err error) {
+	return u.ExchangeContext(context.Background(), req)
+}
+
+// ExchangeContext implements the [contextExchanger] interface for
+// *unixUpstream.
+func (u *unixUpstream) ExchangeContext(
+	ctx context.Context,
+	req *dns.Msg,
+) (resp *dns.Msg, err error) {
+	if u.conn == nil {
+		dialer := &net.Dialer{Timeout: u.connectTimeout}
+
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, "unix", u.path)
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s: %w: %w", u.path, errConnect, err)
+		}
+
+		u.conn = &dns.Conn{Conn: conn}
+	}
+
+	defer func() {
+		if err != nil {
+			// The connection is likely broken, so establish a new one on the
+			// next exchange.
+			_ = u.Close()
+		}
+	}()
+
+	return exchangeConn(ctx, u.conn, req, u.timeout)
+}
+
+// Address implements the [upstream.Upstream] interface for *unixUpstream.
+func (u *unixUpstream) Address() (addr string) {
+	return unixScheme + u.path
+}
+
+// Close implements the [upstream.Upstream] interface for *unixUpstream.
+func (u *unixUpstream) Close() (err error) {
+	if u.conn == nil {
+		return nil
+	}
+
+	err = u.conn.Close()
+	u.conn = nil
+
+	return err
+}
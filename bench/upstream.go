@@ -0,0 +1,164 @@
+package bench
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+)
+
+// createUpstream creates a new upstream for the server address from options.
+// If the address is invalid, which is only possible if its validation is
+// disabled, the returned upstream fails every query.  If the upstream is
+// shared, it's returned instead.
+func createUpstream(options *Options, state *runState) (u upstream.Upstream) {
+	if state.shared != nil {
+		return state.shared
+	}
+
+	state.incUpstreams()
+
+	u, err := newUpstream(options, state)
+	if err != nil {
+		return &invalidUpstream{addr: options.Address, err: err}
+	}
+
+	return u
+}
+
+// newUpstream creates a new upstream for the server address from options.
+func newUpstream(options *Options, state *runState) (u upstream.Upstream, err error) {
+	timeout := time.Duration(options.Timeout) * time.Second
+	if isUnixAddress(options.Address) {
+		return newUnixUpstream(options.Address, timeout, options.ConnectTimeout)
+	}
+	// The plain DNS upstream of the library rejects the responses with more
+	// than one question, so only rely on the message ID then.
+	multiQuestion := options.Questions > 1 && isPlainUDPAddress(options.Address)
+	if options.LateWait > 0 || options.LocalAddress != "" || multiQuestion {
+		// Ignoring the error here since the local address was already
+		// verified.
+		localAddr, _ := netip.ParseAddr(options.LocalAddress)
+
+		return newUDPUpstream(
+			options.Address,
+			timeout,
+			options.LateWait,
+			localAddr,
+			options.IPVersion,
+			state.resolver,
+		), nil
+	}
+
+	if options.QUIC0RTT && isDoQAddress(options.Address) {
+		tlsConf := newTLSConfig(options, state)
+		tlsConf.ClientSessionCache = state.sessionCache
+
+		return newDoQUpstream(
+			options.Address,
+			timeout,
+			options.ConnectTimeout,
+			tlsConf,
+			state.quicTokens,
+			state.count0RTT,
+			options.IPVersion,
+			state.resolver,
+		)
+	}
+
+	// The upstream of the library only supports GET and can't customize the
+	// TLS sessions and the connect timeout.  It also always offers HTTP/2,
+	// since the transport is configured for it, so it can't force HTTP/1.1.
+	isCustomDoH := state.dohMethod == http.MethodPost ||
+		options.HTTPVersion == "1.1" ||
+		options.TLSResumption != "" ||
+		options.ConnectTimeout > 0
+	if isCustomDoH && isDoHAddress(options.Address) {
+		return newDoHUpstream(
+			options.Address,
+			state.dohMethod,
+			timeout,
+			options.ConnectTimeout,
+			newTLSConfig(options, state),
+			options.HTTPVersion,
+			options.IPVersion,
+			state.resolver,
+		)
+	}
+
+	isCustomDoT := options.TLSResumption != "" || options.ConnectTimeout > 0
+	if isCustomDoT && isDoTAddress(options.Address) {
+		return newDoTUpstream(
+			options.Address,
+			timeout,
+			options.ConnectTimeout,
+			newTLSConfig(options, state),
+			options.IPVersion,
+			state.resolver,
+		)
+	}
+
+	if isPlainUDPAddress(options.Address) {
+		// The upstream of the library retries the truncated responses over
+		// TCP without reporting it.
+		return &udpPerQueryUpstream{
+			resolver:  state.resolver,
+			addr:      options.Address,
+			ipVersion: options.IPVersion,
+			timeout:   timeout,
+		}, nil
+	}
+
+	return upstream.AddressToUpstream(
+		options.Address,
+		&upstream.Options{
+			Timeout:            timeout,
+			InsecureSkipVerify: options.InsecureSkipVerify,
+			VerifyConnection:   state.verifyConnection,
+			Bootstrap:          newBootstrap(options.IPVersion, state.resolver),
+			HTTPVersions:       httpVersions(options.HTTPVersion),
+		},
+	)
+}
+
+// httpVersions returns the HTTP versions of the DNS-over-HTTPS upstreams of
+// the library for the value of --http-version.  nil means the default ones.
+// HTTP/1.1 is only forced by our own client, see [newUpstream].
+func httpVersions(v string) (versions []upstream.HTTPVersion) {
+	switch v {
+	case "2":
+		return []upstream.HTTPVersion{upstream.HTTPVersion2}
+	case "3":
+		return []upstream.HTTPVersion{upstream.HTTPVersion3}
+	default:
+		return nil
+	}
+}
+
+// Values of the --tls-resumption flag.
+const (
+	tlsResumptionOn  = "on"
+	tlsResumptionOff = "off"
+)
+
+// newTLSConfig returns the TLS configuration for our own encrypted DNS clients.
+func newTLSConfig(options *Options, state *runState) (conf *tls.Config) {
+	conf = &tls.Config{
+		// #nosec G402 -- The user explicitly asks for it.
+		InsecureSkipVerify: options.InsecureSkipVerify,
+		VerifyConnection:   state.verifyConnection,
+	}
+
+	switch options.TLSResumption {
+	case tlsResumptionOn:
+		conf.ClientSessionCache = state.sessionCache
+	case tlsResumptionOff:
+		conf.ClientSessionCache = nil
+	default:
+		conf.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+
+	return conf
+}
@@ -0,0 +1,126 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/AdguardTeam/dnsproxy/upstream"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// warmupNameQuery returns the parameters of the query for hostname from the
+// warmup file.  It doesn't affect the statistics of the test.
+func (r *runState) warmupNameQuery(hostname string) (q query) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.newQuery(hostname)
+}
+
+// warmUpCache queries every name from hostnames once before the measurement
+// starts, so that the server has them cached.  The queries are sent over up to
+// options.Connections connections in parallel and don't affect the results of
+// the test.  If the queries are split between multiple servers, every name is
+// sent to each of them.
+func warmUpCache(ctx context.Context, options *Options, state *runState, hostnames []string) {
+	options.logProgress("Warming up the cache with %d names from %s", len(hostnames), options.WarmupFile)
+
+	var next, failed atomic.Int64
+	var wg sync.WaitGroup
+	for range min(options.Connections, len(hostnames)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			failed.Add(int64(warmUpCacheConnection(ctx, options, state, hostnames, &next)))
+		}()
+	}
+	wg.Wait()
+
+	// The handshakes of the warmup connections aren't a part of the test.
+	state.m.Lock()
+	state.tlsHandshakes, state.tlsResumed = 0, 0
+	state.quic0RTT, state.quic1RTT, state.quic0RTTRejected = 0, 0, 0
+	state.m.Unlock()
+
+	options.logProgress("Finished the cache warmup, %d queries failed", failed.Load())
+}
+
+// warmUpCacheConnection sends the queries for the names from hostnames
+// starting from the index next points to until there are none left or ctx is
+// cancelled.  It returns the number of the failed queries.
+func warmUpCacheConnection(
+	ctx context.Context,
+	options *Options,
+	state *runState,
+	hostnames []string,
+	next *atomic.Int64,
+) (failed int) {
+	targetOptions := connectionOptions(options, state)
+	upstreams := make([]upstream.Upstream, len(targetOptions))
+	for i, o := range targetOptions {
+		upstreams[i] = newWarmupUpstream(o, state)
+	}
+	defer func() {
+		// Use a closure since the upstreams are re-created on errors.
+		for _, u := range upstreams {
+			log.OnCloserError(u, log.DEBUG)
+		}
+	}()
+
+	for !isCancelled(ctx) {
+		i := int(next.Add(1)) - 1
+		if i >= len(hostnames) {
+			break
+		}
+
+		m := newQueryMsg(options, state.warmupNameQuery(hostnames[i]), hostnames[i])
+		for j, u := range upstreams {
+			state.rate.Take()
+
+			_, err := exchangeTimeout(ctx, u, m, options.queryTimeout())
+			if err != nil {
+				log.Debug("Warmup query for %s failed: %v", hostnames[i], err)
+				failed++
+
+				log.OnCloserError(u, log.DEBUG)
+				upstreams[j] = newWarmupUpstream(targetOptions[j], state)
+			}
+		}
+	}
+
+	return failed
+}
+
+// newWarmupUpstream creates a new upstream for the server address from options
+// to send the warmup queries over.  Unlike [createUpstream], it doesn't count
+// the connection in the test results.
+func newWarmupUpstream(options *Options, state *runState) (u upstream.Upstream) {
+	u, err := newUpstream(options, state)
+	if err != nil {
+		return &invalidUpstream{addr: options.Address, err: err}
+	}
+
+	return u
+}
+
+// readWarmupNames reads the names to warm up the cache with from
+// options.WarmupFile.  names is nil if it's not set.
+func readWarmupNames(options *Options) (names []string, err error) {
+	if options.WarmupFile == "" {
+		return nil, nil
+	}
+
+	names, err = readHostnames(options.WarmupFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading warmup names from %s: %w", options.WarmupFile, err)
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("empty list of hostnames in %s", options.WarmupFile)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,56 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runWarmupFile(t *testing.T) {
+	var mu sync.Mutex
+	queried := map[string]int{}
+	addr := startUDPServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		mu.Lock()
+		queried[req.Question[0].Name]++
+		mu.Unlock()
+
+		resp := &dns.Msg{}
+		resp.SetReply(req)
+		_ = w.WriteMsg(resp)
+	})
+
+	path := filepath.Join(t.TempDir(), "warmup.txt")
+	err := os.WriteFile(path, []byte("# cached\na.example\nb.example\nc.example\n"), 0o644)
+	require.NoError(t, err)
+
+	o := &Options{
+		Address:      addr.String(),
+		Connections:  2,
+		Query:        "example.org",
+		Timeout:      10,
+		QueriesCount: 5,
+		WarmupFile:   path,
+	}
+
+	state := runTest(t, o)
+	require.Equal(t, o.QueriesCount, state.processed)
+	require.Equal(t, o.QueriesCount, state.latency.count())
+
+	// The warmup connections aren't counted.
+	assert.Equal(t, o.Connections, state.upstreams)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, map[string]int{
+		"a.example.":   1,
+		"b.example.":   1,
+		"c.example.":   1,
+		"example.org.": o.QueriesCount,
+	}, queried)
+}
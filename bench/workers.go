@@ -0,0 +1,90 @@
+package bench
+
+import (
+	"cmp"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+)
+
+// workerStats is the statistics of a single connection.
+type workerStats struct {
+	// latency is the latency of the successfully processed queries.
+	latency latencyStats
+
+	// processed is the number of successfully processed queries.
+	processed int
+
+	// errors is the number of failed queries.
+	errors int
+
+	// lastResponse is the last successfully processed response, nil if there
+	// is none.
+	lastResponse *dns.Msg
+}
+
+// compareWorkers compares the connections by the number of processed queries
+// and then by the 99th percentile of the latency, so that the slowest
+// connection is the smallest.
+func compareWorkers(a, b *workerStats) (res int) {
+	return cmp.Or(
+		cmp.Compare(a.processed, b.processed),
+		cmp.Compare(b.latency.percentile(99), a.latency.percentile(99)),
+	)
+}
+
+// slowestAndFastest returns the numbers of the slowest and the fastest
+// connections.  r.workers must not be empty.
+func (r *runState) slowestAndFastest() (slowest, fastest int) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for i := range r.workers {
+		if compareWorkers(&r.workers[i], &r.workers[slowest]) < 0 {
+			slowest = i
+		}
+
+		if compareWorkers(&r.workers[i], &r.workers[fastest]) > 0 {
+			fastest = i
+		}
+	}
+
+	return slowest, fastest
+}
+
+// printWorkerStats prints the statistics of the connection with the number i.
+func printWorkerStats(state *runState, name string, i int) {
+	state.m.Lock()
+	defer state.m.Unlock()
+
+	w := &state.workers[i]
+	elapsed := state.elapsedLocked()
+	log.Info(
+		"%s connection: #%d, %.2f queries per second, latency p99: %s, errors: %d",
+		name,
+		i,
+		float64(w.processed)/elapsed.Seconds(),
+		w.latency.percentile(99),
+		w.errors,
+	)
+}
+
+// printLastResponses prints the last response received over every connection
+// in the presentation format, so that it could be checked the server returns
+// sensible data.  It only prints anything in the verbose mode.
+func printLastResponses(workers []workerStats) {
+	if log.GetLevel() < log.DEBUG {
+		return
+	}
+
+	for i := range workers {
+		resp := workers[i].lastResponse
+		if resp == nil {
+			log.Debug("Last response over connection #%d: none", i)
+
+			continue
+		}
+
+		log.Debug("Last response over connection #%d:\n%s", i, resp)
+	}
+}
@@ -0,0 +1,53 @@
+package bench
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunState_slowestAndFastest(t *testing.T) {
+	state := &runState{workers: make([]workerStats, 3)}
+
+	for i, n := range []int{10, 5, 10} {
+		for range n {
+			state.workers[i].processed++
+			state.workers[i].latency.add(time.Duration(i+1) * time.Millisecond)
+		}
+	}
+
+	// The first and the last connections processed the same number of queries,
+	// but the last one has a higher latency.
+	slowest, fastest := state.slowestAndFastest()
+	assert.Equal(t, 1, slowest)
+	assert.Equal(t, 0, fastest)
+}
+
+func TestPrintLastResponses(t *testing.T) {
+	out := &bytes.Buffer{}
+	log.SetOutput(out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	level := log.GetLevel()
+	t.Cleanup(func() { log.SetLevel(level) })
+
+	resp := &dns.Msg{}
+	resp.SetQuestion("example.org.", dns.TypeA)
+	resp.Rcode = dns.RcodeRefused
+	workers := []workerStats{{lastResponse: resp}, {}}
+
+	log.SetLevel(log.INFO)
+	printLastResponses(workers)
+	assert.Empty(t, out.String())
+
+	log.SetLevel(log.DEBUG)
+	printLastResponses(workers)
+	assert.Contains(t, out.String(), "Last response over connection #0:")
+	assert.Contains(t, out.String(), "status: REFUSED")
+	assert.Contains(t, out.String(), "Last response over connection #1: none")
+}